@@ -2,19 +2,53 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/yuuki/binrep/pkg/command"
 	"github.com/yuuki/binrep/pkg/config"
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
 )
 
 const (
 	defaultKeepReleases int = 5
+	// defaultSelfUpdateProject is the project of the binrep binaries.
+	defaultSelfUpdateProject = "github.com/yuuki/binrep"
+)
+
+// The exit codes, which tell the class of the error to the automation.
+const (
+	exitCodeOK = 0
+	// exitCodeUsage is the invalid global options or the undefined
+	// subcommand. The invalid arguments and options of the subcommands exit
+	// with exitCodeError as they did before the classes of the errors.
+	exitCodeUsage = 1
+	// exitCodeError is the other errors.
+	exitCodeError = 2
+	// exitCodeNotFound is the missing project or release.
+	exitCodeNotFound = 3
+	// exitCodeConflict is the release which already exists.
+	exitCodeConflict = 4
+	// exitCodeChecksum is the checksum mismatch of the pulled binary.
+	exitCodeChecksum = 5
+	// exitCodeTransient is the temporary failure of the backend, such as the
+	// network errors, the throttling and the 5xx responses.
+	exitCodeTransient = 6
+	// exitCodeBrokenChain is the broken hash chain of the releases.
+	exitCodeBrokenChain = 7
 )
 
 var (
@@ -37,63 +71,202 @@ func main() {
 func (cli *CLI) Run(args []string) int {
 	if len(args) <= 1 {
 		fmt.Fprint(cli.errStream, helpText)
-		return 2
+		return exitCodeError
 	}
 
-	config.Load()
+	if err := config.Load(); err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return exitCodeError
+	}
+
+	ctx, cancel := cli.withSignals(context.Background())
+	defer cancel()
 
-	var err error
+	var (
+		err        error
+		webhookSet bool
+	)
 	i := 1
 ARG_LOOP:
 	for i < len(args) {
-		switch cmd := args[i]; cmd {
+		cmd := args[i]
+		if !strings.HasPrefix(cmd, "-") {
+			// The global options are parsed before the subcommand.
+			if err := cli.setupLogger(); err != nil {
+				fmt.Fprintln(cli.errStream, err)
+				fmt.Fprint(cli.errStream, helpText)
+				return exitCodeUsage
+			}
+			config.Config.Command = strings.Join(args[i:], " ")
+		}
+		switch cmd {
 		case "list":
-			err = cli.doList(args[i+1:])
+			err = cli.doList(ctx, args[i+1:])
 			break ARG_LOOP
 		case "show":
-			err = cli.doShow(args[i+1:])
+			err = cli.doShow(ctx, args[i+1:])
 			break ARG_LOOP
 		case "push":
-			err = cli.doPush(args[i+1:])
+			err = cli.doPush(ctx, args[i+1:])
 			break ARG_LOOP
 		case "pull":
-			err = cli.doPull(args[i+1:])
+			err = cli.doPull(ctx, args[i+1:])
+			break ARG_LOOP
+		case "prune":
+			err = cli.doPrune(ctx, args[i+1:])
+			break ARG_LOOP
+		case "restore":
+			err = cli.doRestore(ctx, args[i+1:])
+			break ARG_LOOP
+		case "yank":
+			err = cli.doYank(ctx, args[i+1:])
+			break ARG_LOOP
+		case "unyank":
+			err = cli.doUnyank(ctx, args[i+1:])
+			break ARG_LOOP
+		case "verify":
+			err = cli.doVerify(ctx, args[i+1:])
+			break ARG_LOOP
+		case "audit":
+			err = cli.doAudit(ctx, args[i+1:])
+			break ARG_LOOP
+		case "serve":
+			err = cli.doServe(ctx, args[i+1:])
+			break ARG_LOOP
+		case "keygen":
+			err = cli.doKeygen(args[i+1:])
+			break ARG_LOOP
+		case "self-update":
+			err = cli.doSelfUpdate(ctx, args[i+1:])
 			break ARG_LOOP
 		case "--version":
 			fmt.Fprintf(cli.errStream, "%s version %s, build %s, date %s \n", name, version, commit, date)
-			return 0
+			return exitCodeOK
 		case "--credits":
 			fmt.Fprintln(cli.outStream, creditsText)
-			return 0
+			return exitCodeOK
 		case "-h", "--help":
 			fmt.Fprint(cli.errStream, helpText)
-			return 0
-		case "-e", "--endpoint":
+			return exitCodeOK
+		case "--no-progress", "--no-notify", "-q", "--quiet", "-v", "--verbose":
+			switch cmd {
+			case "--no-progress":
+				config.Config.NoProgress = true
+			case "--no-notify":
+				config.Config.NoNotify = true
+			case "-q", "--quiet":
+				config.Config.LogLevel = "error"
+				config.Config.NoProgress = true
+			case "-v", "--verbose":
+				config.Config.LogLevel = "debug"
+			}
+			i++
+			// No subcommand error
+			if len(args) <= i {
+				fmt.Fprint(cli.errStream, helpText)
+				return exitCodeUsage
+			}
+		case "-e", "--endpoint", "--region", "--profile", "--timeout", "--max-attempts", "--log-level", "--log-format", "--webhook":
 			if len(args) <= i+1 {
-				fmt.Fprint(cli.errStream, "want --endpoint value")
+				fmt.Fprintf(cli.errStream, "want %s value", cmd)
 				fmt.Fprint(cli.errStream, helpText)
-				return 1
+				return exitCodeUsage
+			}
+			switch cmd {
+			case "-e", "--endpoint":
+				config.Config.BackendEndpoint = args[i+1]
+			case "--region":
+				config.Config.Region = args[i+1]
+			case "--profile":
+				config.Config.Profile = args[i+1]
+			case "--timeout":
+				timeout, err := time.ParseDuration(args[i+1])
+				if err != nil || timeout <= 0 {
+					fmt.Fprintf(cli.errStream, "invalid %s value %q\n", cmd, args[i+1])
+					fmt.Fprint(cli.errStream, helpText)
+					return exitCodeUsage
+				}
+				var cancelTimeout context.CancelFunc
+				ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+				defer cancelTimeout()
+			case "--max-attempts":
+				n, err := config.ParseMaxAttempts(args[i+1])
+				if err != nil {
+					fmt.Fprintf(cli.errStream, "invalid %s value %q\n", cmd, args[i+1])
+					fmt.Fprint(cli.errStream, helpText)
+					return exitCodeUsage
+				}
+				config.Config.RetryMaxAttempts = n
+			case "--log-level":
+				config.Config.LogLevel = args[i+1]
+			case "--log-format":
+				config.Config.LogFormat = args[i+1]
+			case "--webhook":
+				if !webhookSet {
+					// The flags override the webhooks of the environment variable.
+					config.Config.Webhooks = nil
+					webhookSet = true
+				}
+				config.Config.Webhooks = append(config.Config.Webhooks, args[i+1])
 			}
-			config.Config.BackendEndpoint = args[i+1]
 			i += 2
 			// No subcommand error
 			if len(args) <= i {
 				fmt.Fprint(cli.errStream, helpText)
-				return 1
+				return exitCodeUsage
 			}
 		default:
 			fmt.Fprintf(cli.errStream, "%s is undefined subcommand or option\n", cmd)
 			fmt.Fprint(cli.errStream, helpText)
-			return 1
+			return exitCodeUsage
 		}
 	}
 
 	if err != nil {
 		fmt.Fprintln(cli.errStream, err)
-		return 2
+		return exitCode(err)
 	}
 
-	return 0
+	return exitCodeOK
+}
+
+// exitCode returns the exit code for the class of err.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrProjectNotFound), errors.Is(err, storage.ErrReleaseNotFound):
+		return exitCodeNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return exitCodeConflict
+	case errors.Is(err, release.ErrChecksumMismatch):
+		return exitCodeChecksum
+	case errors.Is(err, storage.ErrTransient):
+		return exitCodeTransient
+	case errors.Is(err, release.ErrBrokenChain):
+		return exitCodeBrokenChain
+	}
+	return exitCodeError
+}
+
+// withSignals returns the context canceled on SIGINT or SIGTERM, so that the
+// command stops and cleans up what it is writing. The second signal kills
+// the process as usual.
+func (cli *CLI) withSignals(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			signal.Stop(sigCh)
+			fmt.Fprintf(cli.errStream, "Received %s, canceling...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigCh)
+		cancel()
+	}
 }
 
 var helpText = `Usage: binrep [options]
@@ -105,16 +278,51 @@ Commands:
   show          show binary information.
   push		push binary.
   pull		pull binary.
+  prune		prune old releases.
+  restore	restore an archived release.
+  yank		mark a bad release not to be pulled as the latest.
+  unyank	restore a yanked release.
+  audit		show who changed the repository.
+  verify	verify the hash chain of the releases.
+  serve		serve the repository read-only over HTTP.
+  keygen	generate a key pair to encrypt releases.
+  self-update	update binrep itself to the latest release.
 
 Options:
+  --endpoint, -e URL    backend endpoint such as 's3://bucket' (or 'bucket'), 'mem://name' or 's3://bucket?endpoint=http://minio:9000&region=us-east-1&path_style=true' (default: $BINREP_BACKEND_ENDPOINT)
+  --region REGION       region of the bucket (default: the region of the endpoint or the AWS config)
+  --profile PROFILE     profile of the AWS shared config
+  --timeout DURATION    cancel the command after the duration such as '30s' or '10m'
+  --max-attempts N      number of the attempts of the storage operations failing temporarily (default: $BINREP_RETRY_MAX_ATTEMPTS or 4)
+  --no-progress         don't show the progress of the transfers (default: $BINREP_NO_PROGRESS)
+  --webhook URL         post the created and pruned releases to the URL (can be specified multiple times) (default: $BINREP_WEBHOOKS eg. 'URL1,URL2')
+  --no-notify           don't post to the webhooks (default: $BINREP_NO_NOTIFY)
+  --log-level LEVEL     minimum level of the logs: 'debug', 'info', 'warn' or 'error' (default: $BINREP_LOG_LEVEL or 'info')
+  --log-format FORMAT   format of the logs: 'text' or 'json' (default: $BINREP_LOG_FORMAT or 'text')
+  --quiet, -q           log only the errors without the progress, same as '--log-level error --no-progress'
+  --verbose, -v         log the details, same as '--log-level debug'
   --version             print version
   --help, -h            print help
 `
 
+// setupLogger sets the default logger writing into errStream by the config.
+// The messages of package log are also written by it.
+func (cli *CLI) setupLogger() error {
+	logger, err := logging.New(cli.errStream, config.Config.LogLevel, config.Config.LogFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
 func validateConfig() error {
 	if config.Config.BackendEndpoint == "" {
 		return errors.New("BackendEndpoint required. Use --endpoint or BINREP_BACKEND_ENDPOINT")
 	}
+	if _, err := storage.ParseEndpoint(config.Config.BackendEndpoint); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -134,7 +342,7 @@ show releases on remote repository
 Options:
 `
 
-func (cli *CLI) doList(args []string) error {
+func (cli *CLI) doList(ctx context.Context, args []string) error {
 	var param command.ListParam
 	flags := cli.prepareFlags(listHelpText)
 	if err := flags.Parse(args); err != nil {
@@ -147,7 +355,7 @@ func (cli *CLI) doList(args []string) error {
 	if err := validateConfig(); err != nil {
 		return err
 	}
-	return command.List(&param)
+	return command.List(ctx, &param)
 }
 
 var showHelpText = `Usage: binrep show [options] <host>/<user>/<project>
@@ -158,7 +366,7 @@ Options:
   --timestamp, -t       binary timestamp
 `
 
-func (cli *CLI) doShow(args []string) error {
+func (cli *CLI) doShow(ctx context.Context, args []string) error {
 	var param command.ShowParam
 	flags := cli.prepareFlags(showHelpText)
 	flags.StringVar(&param.Timestamp, "t", "", "")
@@ -173,31 +381,76 @@ func (cli *CLI) doShow(args []string) error {
 	if err := validateConfig(); err != nil {
 		return err
 	}
-	return command.Show(&param, flags.Arg(0))
+	return command.Show(ctx, &param, flags.Arg(0))
 }
 
-var pushHelpText = `Usage: binrep push [options] <host>/<user>/<project> /path/to/binary ...
+var pushHelpText = `Usage: binrep push [options] <host>/<user>/<project> /path/to/binary|/path/to/directory ...
 
-push binary.
+push binary. The directory is pushed recursively with the relative paths.
 
 Options:
   --timestamp, -t       binary timestamp
   --keep-releases, -k	the number of releases that it keeps (default: 5)
+  --no-prune		skip pruning the old releases (default: false)
+  --archive-storage-class CLASS	archive the pruned releases with the storage class such as GLACIER instead of deleting them (default: $BINREP_ARCHIVE_STORAGE_CLASS)
   --force, -f		always push even if each checksum of binaries is the same with each one on remote storage (default: false)
+  --require-static	reject dynamically linked ELF executables (default: false)
+  --compress ALGO	store the binaries compressed with ALGO ('gzip' or 'zstd')
+  --rename src=dst	push the file of the local path 'src' as the name 'dst' (can be specified multiple times)
+  --sse ALGO		encrypt the objects on the server side with ALGO ('AES256' or 'aws:kms') (default: $BINREP_SSE)
+  --sse-kms-key-id ID	the KMS key ID for '--sse aws:kms' (default: $BINREP_SSE_KMS_KEY_ID)
+  --storage-class CLASS	store the objects with the storage class such as STANDARD_IA (default: $BINREP_STORAGE_CLASS)
+  --tag key=value	tag the objects (can be specified multiple times) (default: $BINREP_OBJECT_TAGS eg. 'k1=v1,k2=v2')
+  --metadata key=value	set the user-defined metadata of the objects (can be specified multiple times) (default: $BINREP_OBJECT_METADATA)
+  --encrypt-to KEY	encrypt the binaries to the public key generated by 'binrep keygen' (can be specified multiple times) (default: $BINREP_RECIPIENTS eg. 'KEY1,KEY2')
+  --release-tag TAG	tag the release with the name such as 'v1.2.0', which is never pruned (can be specified multiple times)
+  --channel CHANNEL	push the release to the channel such as 'stable', whose latest release is never pruned (can be specified multiple times)
 `
 
-func (cli *CLI) doPush(args []string) error {
+func (cli *CLI) doPush(ctx context.Context, args []string) error {
 	var param command.PushParam
 	flags := cli.prepareFlags(pushHelpText)
 	flags.StringVar(&param.Timestamp, "t", "", "")
 	flags.StringVar(&param.Timestamp, "timestamp", "", "")
 	flags.IntVar(&param.KeepReleases, "k", defaultKeepReleases, "")
 	flags.IntVar(&param.KeepReleases, "keep-releases", defaultKeepReleases, "")
+	flags.BoolVar(&param.NoPrune, "no-prune", false, "")
+	flags.StringVar(&param.ArchiveStorageClass, "archive-storage-class", config.Config.ArchiveStorageClass, "")
 	flags.BoolVar(&param.Force, "f", false, "")
 	flags.BoolVar(&param.Force, "force", false, "")
+	flags.BoolVar(&param.RequireStatic, "require-static", false, "")
+	flags.StringVar(&param.Compress, "compress", "", "")
+	param.Renames = map[string]string{}
+	flags.Var(renameFlag(param.Renames), "rename", "")
+	param.Object = storage.ObjectOptionsFromConfig()
+	flags.StringVar(&param.Object.SSE, "sse", param.Object.SSE, "")
+	flags.StringVar(&param.Object.SSEKMSKeyID, "sse-kms-key-id", param.Object.SSEKMSKeyID, "")
+	flags.StringVar(&param.Object.StorageClass, "storage-class", param.Object.StorageClass, "")
+	tags, metadata := keyValueFlag{name: "tag"}, keyValueFlag{name: "metadata"}
+	flags.Var(&tags, "tag", "")
+	flags.Var(&metadata, "metadata", "")
+	var recipients, releaseTags, channels stringsFlag
+	flags.Var(&recipients, "encrypt-to", "")
+	flags.Var(&releaseTags, "release-tag", "")
+	flags.Var(&channels, "channel", "")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
+	param.Tags, param.Channels = releaseTags, channels
+	param.Recipients = config.Config.Recipients
+	if recipients != nil {
+		param.Recipients = recipients
+	}
+	// The flags override the whole settings of the environment variables.
+	if tags.kvs != nil {
+		param.Object.Tags = tags.kvs
+	}
+	if metadata.kvs != nil {
+		param.Object.Metadata = metadata.kvs
+	}
+	if err := param.Object.Validate(); err != nil {
+		return err
+	}
 	argLen := len(flags.Args())
 	if argLen < 2 {
 		fmt.Fprint(cli.errStream, pushHelpText)
@@ -206,7 +459,65 @@ func (cli *CLI) doPush(args []string) error {
 	if err := validateConfig(); err != nil {
 		return err
 	}
-	return command.Push(&param, flags.Arg(0), flags.Args()[1:argLen])
+	return command.Push(ctx, &param, flags.Arg(0), flags.Args()[1:argLen])
+}
+
+// renameFlag is the flag.Value for `--rename src=dst`.
+type renameFlag map[string]string
+
+func (f renameFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for src, dst := range f {
+		pairs = append(pairs, src+"="+dst)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f renameFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return errors.Errorf("invalid --rename %q, want src=dst", v)
+	}
+	f[filepath.Clean(kv[0])] = kv[1]
+	return nil
+}
+
+// keyValueFlag is the flag.Value for the repeatable `--name key=value`.
+type keyValueFlag struct {
+	name string
+	kvs  map[string]string
+}
+
+func (f *keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f.kvs))
+	for k, v := range f.kvs {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *keyValueFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.Errorf("invalid --%s %q, want key=value", f.name, v)
+	}
+	if f.kvs == nil {
+		f.kvs = map[string]string{}
+	}
+	f.kvs[kv[0]] = kv[1]
+	return nil
+}
+
+// stringsFlag is the flag.Value for the repeatable string flags.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
 
 var pullHelpText = `Usage: binrep pull [options] <host>/<user>/<project> /path/to/binary
@@ -216,15 +527,24 @@ pull binary.
 Options:
   --timestamp, -t       binary timestamp
   --max-bandwidth, -bw	max bandwidth for download binaries (Bytes/sec) eg. '1 MB', '1024 KB'
+  --extract, -x		extract .tar.gz, .tar.zst and .zip binaries into the install path (default: false)
+  --strip-components N	strip N leading components from the file names on extraction (default: 0)
+  --include PATTERN	extract only the files matching the glob PATTERN eg. '*/bin/*'
+  --identity FILE	decrypt the encrypted release with the identity file generated by 'binrep keygen' (default: $BINREP_IDENTITY_FILE)
 `
 
-func (cli *CLI) doPull(args []string) error {
+func (cli *CLI) doPull(ctx context.Context, args []string) error {
 	var param command.PullParam
 	flags := cli.prepareFlags(pullHelpText)
 	flags.StringVar(&param.Timestamp, "t", "", "")
 	flags.StringVar(&param.Timestamp, "timestamp", "", "")
 	flags.StringVar(&param.MaxBandWidth, "bw", "", "")
 	flags.StringVar(&param.MaxBandWidth, "max-bandwidth", "", "")
+	flags.BoolVar(&param.Extract, "x", false, "")
+	flags.BoolVar(&param.Extract, "extract", false, "")
+	flags.IntVar(&param.StripComponents, "strip-components", 0, "")
+	flags.StringVar(&param.Include, "include", "", "")
+	flags.StringVar(&param.IdentityFile, "identity", config.Config.IdentityFile, "")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -235,5 +555,289 @@ func (cli *CLI) doPull(args []string) error {
 	if err := validateConfig(); err != nil {
 		return err
 	}
-	return command.Pull(&param, flags.Arg(0), flags.Arg(1))
+	return command.Pull(ctx, &param, flags.Arg(0), flags.Arg(1))
+}
+
+var pruneHelpText = `Usage: binrep prune [options] <host>/<user>/<project>|--all
+
+prune old releases. A release is kept if any of the --keep options keeps it.
+
+Options:
+  --all			prune the releases of all projects
+  --dry-run, -n		report which releases would be pruned without deleting them
+  --keep-releases, -k	the number of releases that it keeps (default: 5)
+  --keep-within DURATION	keep the releases newer than DURATION eg. '720h', '30d'
+  --keep-weekly DURATION	keep the latest release of each week within DURATION eg. '13w'
+  --archive-storage-class CLASS	archive the pruned releases with the storage class such as GLACIER instead of deleting them (default: $BINREP_ARCHIVE_STORAGE_CLASS)
+`
+
+func (cli *CLI) doPrune(ctx context.Context, args []string) error {
+	var param command.PruneParam
+	flags := cli.prepareFlags(pruneHelpText)
+	flags.BoolVar(&param.All, "all", false, "")
+	flags.BoolVar(&param.DryRun, "n", false, "")
+	flags.BoolVar(&param.DryRun, "dry-run", false, "")
+	flags.IntVar(&param.KeepReleases, "k", defaultKeepReleases, "")
+	flags.IntVar(&param.KeepReleases, "keep-releases", defaultKeepReleases, "")
+	flags.StringVar(&param.KeepWithin, "keep-within", "", "")
+	flags.StringVar(&param.KeepWeekly, "keep-weekly", "", "")
+	flags.StringVar(&param.ArchiveStorageClass, "archive-storage-class", config.Config.ArchiveStorageClass, "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if param.All && len(flags.Args()) != 0 || !param.All && len(flags.Args()) != 1 {
+		fmt.Fprint(cli.errStream, pruneHelpText)
+		return errors.Errorf("want either <host>/<user>/<project> or --all")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.Prune(ctx, &param, flags.Arg(0))
+}
+
+var restoreHelpText = `Usage: binrep restore [options] <host>/<user>/<project> <timestamp>
+
+restore an archived release into the live releases. The release archived
+with GLACIER or DEEP_ARCHIVE is restored asynchronously, so run restore
+again after the restoration is completed.
+
+Options:
+`
+
+func (cli *CLI) doRestore(ctx context.Context, args []string) error {
+	var param command.RestoreParam
+	flags := cli.prepareFlags(restoreHelpText)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 2 {
+		fmt.Fprint(cli.errStream, restoreHelpText)
+		return errors.Errorf("too few or many arguments")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.Restore(ctx, &param, flags.Arg(0), flags.Arg(1))
+}
+
+var yankHelpText = `Usage: binrep yank [options] <host>/<user>/<project> <timestamp>
+
+mark a bad release not to be pulled as the latest. 'show' still displays it with the reason.
+
+Options:
+  --reason, -r		the reason why the release is yanked (required)
+`
+
+func (cli *CLI) doYank(ctx context.Context, args []string) error {
+	var param command.YankParam
+	flags := cli.prepareFlags(yankHelpText)
+	flags.StringVar(&param.Reason, "r", "", "")
+	flags.StringVar(&param.Reason, "reason", "", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 2 {
+		fmt.Fprint(cli.errStream, yankHelpText)
+		return errors.Errorf("too few or many arguments")
+	}
+	if param.Reason == "" {
+		fmt.Fprint(cli.errStream, yankHelpText)
+		return errors.Errorf("--reason required")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.Yank(ctx, &param, flags.Arg(0), flags.Arg(1))
+}
+
+var unyankHelpText = `Usage: binrep unyank [options] <host>/<user>/<project> <timestamp>
+
+restore a yanked release.
+
+Options:
+`
+
+func (cli *CLI) doUnyank(ctx context.Context, args []string) error {
+	var param command.UnyankParam
+	flags := cli.prepareFlags(unyankHelpText)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 2 {
+		fmt.Fprint(cli.errStream, unyankHelpText)
+		return errors.Errorf("too few or many arguments")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.Unyank(ctx, &param, flags.Arg(0), flags.Arg(1))
+}
+
+var verifyHelpText = `Usage: binrep verify [options] --chain <host>/<user>/<project>
+
+verify the hash chain of the releases. Each release links to the hash of the
+meta.yml of the previous release, and the deleted releases leave the tombstones,
+so that the releases deleted, rewritten or inserted out of order are detected.
+The releases forked by the concurrent pushes are reported without failing.
+
+Options:
+  --chain		verify the hash chain of the releases (required)
+`
+
+func (cli *CLI) doVerify(ctx context.Context, args []string) error {
+	var param command.VerifyParam
+	flags := cli.prepareFlags(verifyHelpText)
+	flags.BoolVar(&param.Chain, "chain", false, "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 1 {
+		fmt.Fprint(cli.errStream, verifyHelpText)
+		return errors.Errorf("too few or many arguments")
+	}
+	if !param.Chain {
+		fmt.Fprint(cli.errStream, verifyHelpText)
+		return errors.Errorf("--chain required")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.Verify(ctx, &param, flags.Arg(0))
+}
+
+var serveHelpText = `Usage: binrep serve [options]
+
+serve the repository read-only over HTTP for the hosts without the credentials
+of the storage, until it is interrupted.
+
+  GET /projects					the names of the projects
+  GET /projects/<host>/<user>/<project>		the releases of the project
+  GET /projects/<host>/<user>/<project>/<timestamp>		the meta of the release
+  GET /projects/<host>/<user>/<project>/<timestamp>/<binary>	the binary
+
+<timestamp> may be 'latest' to be redirected to the latest release.
+
+Options:
+  --listen ADDR		listen on ADDR (default: ':8080')
+  --cache-dir DIR	cache the binaries served into DIR (default: 'binrep-serve' under the temporary directory)
+  --cache-size SIZE	remove the least recently served binaries over SIZE from the cache eg. '10 GB' (default: '1 GB')
+  --identity FILE	decrypt the encrypted releases with the identity file generated by 'binrep keygen' (default: $BINREP_IDENTITY_FILE),
+			which serves the plaintext to every client without authentication
+`
+
+func (cli *CLI) doServe(ctx context.Context, args []string) error {
+	var param command.ServeParam
+	flags := cli.prepareFlags(serveHelpText)
+	flags.StringVar(&param.Listen, "listen", ":8080", "")
+	flags.StringVar(&param.CacheDir, "cache-dir", "", "")
+	flags.StringVar(&param.CacheSize, "cache-size", "1 GB", "")
+	flags.StringVar(&param.IdentityFile, "identity", config.Config.IdentityFile, "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 0 {
+		fmt.Fprint(cli.errStream, serveHelpText)
+		return errors.Errorf("too many arguments")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.Serve(ctx, &param)
+}
+
+var auditHelpText = `Usage: binrep audit [options]
+
+show the audit records of the releases created, deleted, pruned, archived,
+restored, yanked and unyanked, with who did it by which command.
+
+Options:
+  --since DURATION|TIME	show the records since the duration ago eg. '24h', '7d', or the time eg. '2017-10-17', '2017-10-17T15:25:08Z'
+  --project NAME	show the records of the project <host>/<user>/<project>
+  --json		print the records as the JSON lines with the checksums
+`
+
+func (cli *CLI) doAudit(ctx context.Context, args []string) error {
+	var param command.AuditParam
+	flags := cli.prepareFlags(auditHelpText)
+	flags.StringVar(&param.Since, "since", "", "")
+	flags.StringVar(&param.Project, "project", "", "")
+	flags.BoolVar(&param.JSON, "json", false, "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 0 {
+		fmt.Fprint(cli.errStream, auditHelpText)
+		return errors.Errorf("too many arguments")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.Audit(ctx, &param)
+}
+
+var selfUpdateHelpText = `Usage: binrep self-update [options]
+
+update the running binrep to the binary of the latest release of the project.
+The binary is chosen by the name and the platform such as 'binrep_linux_amd64',
+and replaces the executable after validating the checksum. The releases are not
+signed, so the binary is trusted as far as the storage is.
+
+Options:
+  --project NAME	the project of the binrep binaries (default: $BINREP_SELF_UPDATE_PROJECT or 'github.com/yuuki/binrep')
+  --timestamp, -t	update to the release of the timestamp instead of the latest
+  --channel CHANNEL	update to the latest release of the channel such as 'stable' pushed with '--channel'
+  --dry-run, -n		report the binary to update to without replacing the executable
+  --identity FILE	decrypt the encrypted release with the identity file generated by 'binrep keygen' (default: $BINREP_IDENTITY_FILE)
+`
+
+func (cli *CLI) doSelfUpdate(ctx context.Context, args []string) error {
+	var param command.SelfUpdateParam
+	project := config.Config.SelfUpdateProject
+	if project == "" {
+		project = defaultSelfUpdateProject
+	}
+	flags := cli.prepareFlags(selfUpdateHelpText)
+	flags.StringVar(&param.Project, "project", project, "")
+	flags.StringVar(&param.Timestamp, "t", "", "")
+	flags.StringVar(&param.Timestamp, "timestamp", "", "")
+	flags.StringVar(&param.Channel, "channel", "", "")
+	flags.BoolVar(&param.DryRun, "n", false, "")
+	flags.BoolVar(&param.DryRun, "dry-run", false, "")
+	flags.StringVar(&param.IdentityFile, "identity", config.Config.IdentityFile, "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 0 {
+		fmt.Fprint(cli.errStream, selfUpdateHelpText)
+		return errors.Errorf("too many arguments")
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+	return command.SelfUpdate(ctx, &param)
+}
+
+var keygenHelpText = `Usage: binrep keygen [options]
+
+generate a key pair to encrypt releases. The identity (private key) is written
+to stdout or the output file, and the public key to pass to 'push --encrypt-to'
+is printed to stderr.
+
+Options:
+  --output, -o FILE	write the identity to FILE instead of stdout
+`
+
+func (cli *CLI) doKeygen(args []string) error {
+	var param command.KeygenParam
+	flags := cli.prepareFlags(keygenHelpText)
+	flags.StringVar(&param.Output, "o", "", "")
+	flags.StringVar(&param.Output, "output", "", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(flags.Args()) != 0 {
+		fmt.Fprint(cli.errStream, keygenHelpText)
+		return errors.Errorf("too many arguments")
+	}
+	return command.Keygen(&param)
 }
@@ -7,9 +7,34 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/pkg/errors"
+
 	"github.com/yuuki/binrep/pkg/config"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
 )
 
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		desc     string
+		err      error
+		expected int
+	}{
+		{"other", errors.New("failed"), 2},
+		{"project not found", errors.Wrap(storage.ErrProjectNotFound, "failed to pull"), 3},
+		{"release not found", errors.Wrap(storage.ErrReleaseNotFound, "failed to pull"), 3},
+		{"conflict", errors.Wrap(storage.ErrConflict, "failed to push"), 4},
+		{"checksum mismatch", errors.Wrap(release.ErrChecksumMismatch, "failed to pull"), 5},
+		{"transient", errors.Wrap(&storage.TransientError{Err: errors.New("connection reset")}, "failed to push"), 6},
+		{"broken chain", errors.Wrap(&release.BrokenChainError{Name: "github.com/yuuki/droot"}, "failed to verify"), 7},
+	}
+	for _, tc := range tests {
+		if got := exitCode(tc.err); got != tc.expected {
+			t.Errorf("desc: %s, got %d, want %d", tc.desc, got, tc.expected)
+		}
+	}
+}
+
 func TestRun_global(t *testing.T) {
 	if err := os.Setenv("BINREP_BACKEND_ENDPOINT", "s3://binrep-testing"); err != nil {
 		panic(err)
@@ -97,6 +122,113 @@ func TestRun_endpoint(t *testing.T) {
 			expectedStatus: 1,
 			expectedSubErr: "want --endpoint value",
 		},
+		{
+			desc:           "no region value",
+			arg:            "binrep --endpoint s3://binrep-testing --region",
+			expectedStatus: 1,
+			expectedSubErr: "want --region value",
+		},
+		{
+			desc:           "invalid timeout value",
+			arg:            "binrep --timeout 10 list",
+			expectedStatus: 1,
+			expectedSubErr: `invalid --timeout value "10"`,
+		},
+		{
+			desc:           "invalid max attempts value",
+			arg:            "binrep --max-attempts 0 list",
+			expectedStatus: 1,
+			expectedSubErr: `invalid --max-attempts value "0"`,
+		},
+		{
+			desc:           "max attempts",
+			arg:            "binrep --endpoint mem://binrep-testing --max-attempts 2 list",
+			expectedStatus: 0,
+		},
+		{
+			desc:           "no progress",
+			arg:            "binrep --no-progress --endpoint mem://binrep-testing list",
+			expectedStatus: 0,
+		},
+		{
+			desc:           "no progress without subcommand",
+			arg:            "binrep --no-progress",
+			expectedStatus: 1,
+		},
+		{
+			desc:           "invalid log level",
+			arg:            "binrep --log-level verbose list",
+			expectedStatus: 1,
+			expectedSubErr: `invalid log level "verbose"`,
+		},
+		{
+			desc:           "invalid log format",
+			arg:            "binrep --log-format yaml list",
+			expectedStatus: 1,
+			expectedSubErr: `invalid log format "yaml"`,
+		},
+		{
+			desc:           "json logs",
+			arg:            "binrep --log-format json --verbose --endpoint mem://binrep-testing list",
+			expectedStatus: 0,
+		},
+		{
+			desc:           "quiet",
+			arg:            "binrep -q --endpoint mem://binrep-testing list",
+			expectedStatus: 0,
+		},
+		{
+			desc:           "timeout",
+			arg:            "binrep --endpoint mem://binrep-testing --timeout 1m list",
+			expectedStatus: 0,
+		},
+		{
+			desc:           "project not found",
+			arg:            "binrep --endpoint mem://binrep-testing show github.com/yuuki/droot",
+			expectedStatus: 3,
+			expectedSubErr: "no such projects github.com/yuuki/droot",
+		},
+		{
+			desc:           "audit",
+			arg:            "binrep --endpoint mem://binrep-testing audit --since 24h --project github.com/yuuki/droot",
+			expectedStatus: 0,
+		},
+		{
+			desc:           "audit: invalid since",
+			arg:            "binrep --endpoint mem://binrep-testing audit --since yesterday",
+			expectedStatus: 2,
+			expectedSubErr: `failed to parse --since "yesterday"`,
+		},
+		{
+			desc:           "serve: invalid cache size",
+			arg:            "binrep --endpoint mem://binrep-testing serve --cache-size 10XB",
+			expectedStatus: 2,
+			expectedSubErr: "failed to parse --cache-size 10XB",
+		},
+		{
+			desc:           "invalid webhook",
+			arg:            "binrep --endpoint mem://binrep-testing --webhook ftp://hooks.example.com/binrep prune github.com/yuuki/droot",
+			expectedStatus: 2,
+			expectedSubErr: `invalid webhook URL "ftp://hooks.example.com/..."`,
+		},
+		{
+			desc:           "no notify",
+			arg:            "binrep --endpoint mem://binrep-testing --no-notify --webhook ftp://hooks.example.com/binrep prune github.com/yuuki/droot",
+			expectedStatus: 3,
+			expectedSubErr: "no such projects github.com/yuuki/droot",
+		},
+		{
+			desc:           "verify: project not found",
+			arg:            "binrep --endpoint mem://binrep-testing verify --chain github.com/yuuki/droot",
+			expectedStatus: 3,
+			expectedSubErr: "no such projects github.com/yuuki/droot",
+		},
+		{
+			desc:           "invalid endpoint",
+			arg:            "binrep --endpoint s3://binrep-testing?path_style=yes list",
+			expectedStatus: 2,
+			expectedSubErr: "path_style must be true or false",
+		},
 		{
 			desc:           "no list --help option",
 			arg:            "binrep list",
@@ -225,6 +357,30 @@ func TestRun_subCommand(t *testing.T) {
 			expectedStatus: 2,
 			expectedSubOut: "too few arguments",
 		},
+		{
+			desc:           "push: unsupported sse",
+			arg:            "binrep push --sse des hoge /bin/ls",
+			expectedStatus: 2,
+			expectedSubOut: "unsupported server-side encryption",
+		},
+		{
+			desc:           "push: kms key id without aws:kms",
+			arg:            "binrep push --sse AES256 --sse-kms-key-id alias/binrep hoge /bin/ls",
+			expectedStatus: 2,
+			expectedSubOut: "--sse-kms-key-id requires --sse aws:kms",
+		},
+		{
+			desc:           "push: invalid tag",
+			arg:            "binrep push --tag owner hoge /bin/ls",
+			expectedStatus: 2,
+			expectedSubOut: "invalid --tag",
+		},
+		{
+			desc:           "push: invalid recipient",
+			arg:            "binrep push --encrypt-to hoge hoge /bin/ls",
+			expectedStatus: 2,
+			expectedSubOut: "invalid recipient",
+		},
 
 		// pull
 		{
@@ -245,6 +401,150 @@ func TestRun_subCommand(t *testing.T) {
 			expectedStatus: 2,
 			expectedSubOut: "too few or many arguments",
 		},
+
+		// prune
+		{
+			desc:           "prune: display help",
+			arg:            "binrep prune --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep prune",
+		},
+		{
+			desc:           "prune: arguments error (len: 0)",
+			arg:            "binrep prune",
+			expectedStatus: 2,
+			expectedSubOut: "want either <host>/<user>/<project> or --all",
+		},
+		{
+			desc:           "prune: arguments error (name with --all)",
+			arg:            "binrep prune --all hoge",
+			expectedStatus: 2,
+			expectedSubOut: "want either <host>/<user>/<project> or --all",
+		},
+
+		// restore
+		{
+			desc:           "restore: display help",
+			arg:            "binrep restore --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep restore",
+		},
+		{
+			desc:           "restore: arguments error (len: 1)",
+			arg:            "binrep restore hoge",
+			expectedStatus: 2,
+			expectedSubOut: "too few or many arguments",
+		},
+
+		// yank
+		{
+			desc:           "yank: display help",
+			arg:            "binrep yank --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep yank",
+		},
+		{
+			desc:           "yank: arguments error (len: 1)",
+			arg:            "binrep yank hoge",
+			expectedStatus: 2,
+			expectedSubOut: "too few or many arguments",
+		},
+		{
+			desc:           "yank: no reason",
+			arg:            "binrep yank hoge 20171019204009",
+			expectedStatus: 2,
+			expectedSubOut: "--reason required",
+		},
+
+		// unyank
+		{
+			desc:           "unyank: display help",
+			arg:            "binrep unyank --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep unyank",
+		},
+		{
+			desc:           "unyank: arguments error (len: 1)",
+			arg:            "binrep unyank hoge",
+			expectedStatus: 2,
+			expectedSubOut: "too few or many arguments",
+		},
+
+		// audit
+		{
+			desc:           "audit: display help",
+			arg:            "binrep audit --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep audit",
+		},
+		{
+			desc:           "audit: arguments error",
+			arg:            "binrep audit hoge",
+			expectedStatus: 2,
+			expectedSubOut: "too many arguments",
+		},
+
+		// verify
+		{
+			desc:           "verify: display help",
+			arg:            "binrep verify --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep verify",
+		},
+		{
+			desc:           "verify: arguments error",
+			arg:            "binrep verify --chain",
+			expectedStatus: 2,
+			expectedSubOut: "too few or many arguments",
+		},
+		{
+			desc:           "verify: chain required",
+			arg:            "binrep verify github.com/yuuki/droot",
+			expectedStatus: 2,
+			expectedSubOut: "--chain required",
+		},
+
+		// serve
+		{
+			desc:           "serve: display help",
+			arg:            "binrep serve --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep serve",
+		},
+		{
+			desc:           "serve: arguments error",
+			arg:            "binrep serve hoge",
+			expectedStatus: 2,
+			expectedSubOut: "too many arguments",
+		},
+
+		// keygen
+		{
+			desc:           "keygen: display help",
+			arg:            "binrep keygen --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep keygen",
+		},
+		{
+			desc:           "keygen: arguments error",
+			arg:            "binrep keygen hoge",
+			expectedStatus: 2,
+			expectedSubOut: "too many arguments",
+		},
+
+		// self-update
+		{
+			desc:           "self-update: display help",
+			arg:            "binrep self-update --help",
+			expectedStatus: 2,
+			expectedSubOut: "Usage: binrep self-update",
+		},
+		{
+			desc:           "self-update: arguments error",
+			arg:            "binrep self-update hoge",
+			expectedStatus: 2,
+			expectedSubOut: "too many arguments",
+		},
 	}
 	for _, tc := range tests {
 		outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
@@ -0,0 +1,378 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Options represents the options of the extraction.
+type Options struct {
+	// StripComponents strips the number of leading components from the
+	// file names like `tar --strip-components`.
+	StripComponents int
+	// Include extracts only the files whose names after stripping match the
+	// glob pattern. All files are extracted if it is empty.
+	Include string
+}
+
+// IsArchive returns whether name is a supported archive file name or not.
+func IsArchive(name string) bool {
+	return format(name) != ""
+}
+
+func format(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return "tar.zst"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// Extract extracts the archive file of src into the directory dst. The
+// entries pointing outside of dst by the names or the links are rejected.
+func Extract(dst, src string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Include != "" {
+		if _, err := path.Match(opts.Include, ""); err != nil {
+			return errors.Wrapf(err, "invalid include pattern %q", opts.Include)
+		}
+	}
+	x := &extractor{dst: dst, opts: opts}
+	switch format(src) {
+	case "tar.gz":
+		f, err := os.Open(src)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %v", src)
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read gzip header of %v", src)
+		}
+		defer zr.Close()
+		return x.extractTar(zr)
+	case "tar.zst":
+		f, err := os.Open(src)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %v", src)
+		}
+		defer f.Close()
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create zstd reader of %v", src)
+		}
+		defer zr.Close()
+		return x.extractTar(zr)
+	case "zip":
+		zr, err := zip.OpenReader(src)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %v", src)
+		}
+		defer zr.Close()
+		return x.extractZip(&zr.Reader)
+	}
+	return errors.Errorf("%v is not a supported archive (.tar.gz, .tar.zst or .zip)", src)
+}
+
+type extractor struct {
+	dst  string
+	opts *Options
+}
+
+func (x *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read tar")
+		}
+		name, ok, err := x.entryName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := x.mkdir(name); err != nil {
+				return err
+			}
+		case tar.TypeReg: // tar.Reader reads the old '\x00' type as TypeReg
+			if err := x.writeFile(name, mode, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := x.symlink(name, hdr.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, ok, err := x.entryName(hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errors.Errorf("invalid hard link %q -> %q: the target is not extracted", hdr.Name, hdr.Linkname)
+			}
+			if err := x.link(name, target); err != nil {
+				return err
+			}
+		default:
+			slog.Warn("Skip the entry which is not a regular file, a directory or a link", "entry", hdr.Name)
+		}
+	}
+}
+
+func (x *extractor) extractZip(zr *zip.Reader) error {
+	for _, f := range zr.File {
+		name, ok, err := x.entryName(f.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := x.mkdir(name); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return errors.Wrapf(err, "failed to open %q in zip", f.Name)
+			}
+			target, err := readLinkTarget(rc)
+			rc.Close()
+			if err != nil {
+				return errors.Wrapf(err, "failed to read link %q in zip", f.Name)
+			}
+			if err := x.symlink(name, target); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return errors.Wrapf(err, "failed to open %q in zip", f.Name)
+			}
+			err = x.writeFile(name, mode.Perm(), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			slog.Warn("Skip the entry which is not a regular file, a directory or a link", "entry", f.Name)
+		}
+	}
+	return nil
+}
+
+func readLinkTarget(r io.Reader) (string, error) {
+	// Limit the link target to PATH_MAX.
+	b, err := ioutil.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// entryName validates and strips the entry name. It returns false if the
+// entry is stripped off entirely or excluded.
+func (x *extractor) entryName(name string) (string, bool, error) {
+	name = strings.Replace(name, "\\", "/", -1)
+	if path.IsAbs(name) {
+		return "", false, errors.Errorf("invalid entry %q: absolute path", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", false, errors.Errorf("invalid entry %q: must not contain '..'", name)
+		}
+	}
+	elems := strings.Split(strings.Trim(path.Clean(name), "/"), "/")
+	if len(elems) > 0 && elems[0] == "." {
+		elems = elems[1:]
+	}
+	if len(elems) <= x.opts.StripComponents {
+		return "", false, nil
+	}
+	name = strings.Join(elems[x.opts.StripComponents:], "/")
+	if x.opts.Include != "" {
+		ok, err := path.Match(x.opts.Include, name)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "invalid include pattern %q", x.opts.Include)
+		}
+		if !ok {
+			return "", false, nil
+		}
+	}
+	return name, true, nil
+}
+
+// path returns the path of name within dst. It returns error if any
+// parent directory of the path is a symbolic link, not to write files
+// outside of dst through the links.
+func (x *extractor) path(name string) (string, error) {
+	elems := strings.Split(name, "/")
+	p := x.dst
+	for _, elem := range elems[:len(elems)-1] {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to stat %v", p)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", errors.Errorf("invalid entry %q: %v is a symbolic link", name, p)
+		}
+		if !fi.IsDir() {
+			return "", errors.Errorf("invalid entry %q: %v is not a directory", name, p)
+		}
+	}
+	return filepath.Join(x.dst, filepath.FromSlash(name)), nil
+}
+
+func (x *extractor) mkdir(name string) error {
+	p, err := x.path(name + "/.")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %v", p)
+	}
+	return nil
+}
+
+// prepare creates the parent directories of name, and removes the existing
+// file of name not to write through the existing link.
+func (x *extractor) prepare(name string) (string, error) {
+	p, err := x.path(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %v", filepath.Dir(p))
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "failed to remove %v", p)
+	}
+	return p, nil
+}
+
+func (x *extractor) writeFile(name string, mode os.FileMode, r io.Reader) error {
+	p, err := x.prepare(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %v", p)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "failed to write %v", p)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close %v", p)
+	}
+	return nil
+}
+
+func (x *extractor) symlink(name, target string) error {
+	if path.IsAbs(target) || filepath.IsAbs(target) {
+		return errors.Errorf("invalid link %q -> %q: must point within the destination", name, target)
+	}
+	if err := x.resolveLink(name, target); err != nil {
+		return err
+	}
+	p, err := x.prepare(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, p); err != nil {
+		return errors.Wrapf(err, "failed to create link %v", p)
+	}
+	return nil
+}
+
+// resolveLink resolves the target of the link name against the files
+// already extracted. It returns error if the target leaves dst or goes
+// through another link, such as 'l2 -> l1/..' after 'l1 -> .', which the
+// lexical check of the target alone can't detect.
+func (x *extractor) resolveLink(name, target string) error {
+	var elems []string
+	if dir := path.Dir(name); dir != "." {
+		elems = strings.Split(dir, "/")
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(target), "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(elems) == 0 {
+				return errors.Errorf("invalid link %q -> %q: must point within the destination", name, target)
+			}
+			elems = elems[:len(elems)-1]
+			continue
+		}
+		elems = append(elems, elem)
+		p := filepath.Join(x.dst, filepath.FromSlash(strings.Join(elems, "/")))
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %v", p)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return errors.Errorf("invalid link %q -> %q: %v is a symbolic link", name, target, p)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) link(name, target string) error {
+	tp, err := x.path(target)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(tp)
+	if err != nil {
+		return errors.Wrapf(err, "invalid hard link %q -> %q", name, target)
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("invalid hard link %q -> %q: the target is not a regular file", name, target)
+	}
+	p, err := x.prepare(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(tp, p); err != nil {
+		return errors.Wrapf(err, "failed to create hard link %v", p)
+	}
+	return nil
+}
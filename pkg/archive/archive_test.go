@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+type entry struct {
+	name string
+	body string
+	link string
+	dir  bool
+}
+
+func writeTar(w io.Writer, entries []entry) {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0755}
+		switch {
+		case e.dir:
+			hdr.Typeflag = tar.TypeDir
+		case e.link != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.link
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			panic(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			panic(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+}
+
+func buildArchive(dir, name string, entries []entry) string {
+	buf := new(bytes.Buffer)
+	switch format(name) {
+	case "tar.gz":
+		zw := gzip.NewWriter(buf)
+		writeTar(zw, entries)
+		zw.Close()
+	case "tar.zst":
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			panic(err)
+		}
+		writeTar(zw, entries)
+		zw.Close()
+	case "zip":
+		zw := zip.NewWriter(buf)
+		for _, e := range entries {
+			fh := &zip.FileHeader{Name: e.name}
+			body := e.body
+			switch {
+			case e.dir:
+				fh.Name += "/"
+				fh.SetMode(os.ModeDir | 0755)
+			case e.link != "":
+				fh.SetMode(os.ModeSymlink | 0777)
+				body = e.link
+			default:
+				fh.SetMode(0755)
+			}
+			w, err := zw.CreateHeader(fh)
+			if err != nil {
+				panic(err)
+			}
+			w.Write([]byte(body))
+		}
+		zw.Close()
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// listTree lists the files in dir as 'name' for files and 'name -> target' for links.
+func listTree(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, _ := os.Readlink(path)
+			rel += " -> " + target
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestIsArchive(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"droot_linux_amd64.tar.gz":  true,
+		"droot_linux_amd64.tgz":     true,
+		"droot_linux_amd64.tar.zst": true,
+		"droot_linux_amd64.zip":     true,
+		"droot":                     false,
+		"app.css":                   false,
+	} {
+		if got := IsArchive(name); got != expected {
+			t.Errorf("IsArchive(%q) = %v; want %v", name, got, expected)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	entries := []entry{
+		{name: "droot_linux_amd64", dir: true},
+		{name: "droot_linux_amd64/droot", body: "droot-body"},
+		{name: "droot_linux_amd64/README.md", body: "readme"},
+		{name: "droot_linux_amd64/bin/droot", link: "../droot"},
+	}
+	tests := []struct {
+		desc     string
+		opts     *Options
+		expected []string
+	}{
+		{
+			desc:     "no options",
+			opts:     nil,
+			expected: []string{"droot_linux_amd64/README.md", "droot_linux_amd64/bin/droot -> ../droot", "droot_linux_amd64/droot"},
+		},
+		{
+			desc:     "strip components",
+			opts:     &Options{StripComponents: 1},
+			expected: []string{"README.md", "bin/droot -> ../droot", "droot"},
+		},
+		{
+			desc:     "include",
+			opts:     &Options{StripComponents: 1, Include: "droot"},
+			expected: []string{"droot"},
+		},
+	}
+	for _, name := range []string{"droot.tar.gz", "droot.tar.zst", "droot.zip"} {
+		for _, tc := range tests {
+			tmp, err := ioutil.TempDir("", "binrep-archive")
+			if err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(tmp)
+			src := buildArchive(tmp, name, entries)
+			dst := filepath.Join(tmp, "dst")
+			if err := os.Mkdir(dst, 0755); err != nil {
+				panic(err)
+			}
+
+			if err := Extract(dst, src, tc.opts); err != nil {
+				t.Fatalf("archive: %s, desc: %s, should not raise error: %s", name, tc.desc, err)
+			}
+
+			if diff := pretty.Compare(listTree(dst), tc.expected); diff != "" {
+				t.Errorf("archive: %s, desc: %s, diff: (-actual +expected)\n%s", name, tc.desc, diff)
+			}
+		}
+	}
+}
+
+func TestExtract_error(t *testing.T) {
+	tests := []struct {
+		desc    string
+		entries []entry
+		errMsg  string
+	}{
+		{
+			desc:    "parent directory",
+			entries: []entry{{name: "../evil", body: "evil"}},
+			errMsg:  "must not contain '..'",
+		},
+		{
+			desc:    "nested parent directory",
+			entries: []entry{{name: "bin/../../evil", body: "evil"}},
+			errMsg:  "must not contain '..'",
+		},
+		{
+			desc:    "absolute path",
+			entries: []entry{{name: "/tmp/evil", body: "evil"}},
+			errMsg:  "absolute path",
+		},
+		{
+			desc:    "link escaping destination",
+			entries: []entry{{name: "bin/evil", link: "../../evil"}},
+			errMsg:  "must point within the destination",
+		},
+		{
+			desc:    "absolute link",
+			entries: []entry{{name: "evil", link: "/etc/passwd"}},
+			errMsg:  "must point within the destination",
+		},
+		{
+			desc: "write through link",
+			entries: []entry{
+				{name: "sub", dir: true},
+				{name: "bin", link: "sub"},
+				{name: "bin/evil", body: "evil"},
+			},
+			errMsg: "is a symbolic link",
+		},
+		{
+			desc: "chained links escaping destination",
+			entries: []entry{
+				{name: "l1", link: "."},
+				{name: "l2", link: "l1/.."},
+			},
+			errMsg: "is a symbolic link",
+		},
+	}
+	for _, name := range []string{"evil.tar.gz", "evil.zip"} {
+		for _, tc := range tests {
+			tmp, err := ioutil.TempDir("", "binrep-archive")
+			if err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(tmp)
+			src := buildArchive(tmp, name, tc.entries)
+			dst := filepath.Join(tmp, "a", "dst")
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				panic(err)
+			}
+
+			err = Extract(dst, src, nil)
+
+			if err == nil {
+				t.Fatalf("archive: %s, desc: %s, should raise error", name, tc.desc)
+			}
+			if !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("archive: %s, desc: %s, error %q should contain %q", name, tc.desc, err, tc.errMsg)
+			}
+			if _, err := os.Lstat(filepath.Join(tmp, "evil")); !os.IsNotExist(err) {
+				t.Errorf("archive: %s, desc: %s, evil should not be extracted outside of the destination", name, tc.desc)
+			}
+			if _, err := os.Lstat(filepath.Join(tmp, "a", "evil")); !os.IsNotExist(err) {
+				t.Errorf("archive: %s, desc: %s, evil should not be extracted outside of the destination", name, tc.desc)
+			}
+		}
+	}
+}
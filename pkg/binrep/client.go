@@ -0,0 +1,106 @@
+// Package binrep is the client library of the binrep repository for the
+// programs embedding binrep, such as the deploy tools. The methods of Client
+// return the results instead of printing them, and the `binrep` command is
+// a thin layer over it.
+package binrep
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// Progress is notified of the transfer of the stored bodies of the binaries.
+// The methods may be called concurrently for the different binaries.
+type Progress interface {
+	// Start is called before transferring the body of the size in bytes.
+	Start(bin *release.Binary, size int64)
+	// Update is called with the number of the bytes transferred so far,
+	// which goes back if the upload is rewound to be retried.
+	Update(bin *release.Binary, transferred int64)
+	// Finish is called after the transfer with its error.
+	Finish(bin *release.Binary, err error)
+}
+
+// Client operates the repository of Storage.
+type Client struct {
+	Storage storage.API
+	// Logger receives the records of the operations with the fields of
+	// package logging. It is nil not to log anything. Use SetLogger to pass
+	// it to Storage too.
+	Logger *slog.Logger
+	// Progress is nil not to report the progress.
+	Progress Progress
+}
+
+// New creates the client of st.
+func New(st storage.API) *Client {
+	return &Client{Storage: st}
+}
+
+// SetLogger sets Logger, and passes it to Storage for the records which the
+// storage doesn't return, such as the retries.
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.Logger = logger
+	storage.SetLogger(c.Storage, c.logger())
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger == nil {
+		return logging.Discard()
+	}
+	return c.Logger
+}
+
+// Resolve resolves the release of the name(<host>/<user>/<project>) with
+// the timestamp, or the latest release if timestamp is empty. The bodies of
+// the binaries of the returned release are nil.
+func (c *Client) Resolve(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	rel, err := c.Storage.FindReleaseMeta(ctx, name, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	c.logger().Debug("Resolved the release",
+		logging.KeyProject, rel.Name(), logging.KeyTimestamp, rel.Timestamp(), "url", rel.URL.String())
+	return rel, nil
+}
+
+// find finds the release with the opened bodies of the binaries.
+func (c *Client) find(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	if timestamp == "" {
+		return c.Storage.FindLatestRelease(ctx, name)
+	}
+	return c.Storage.FindReleaseByTimestamp(ctx, name, timestamp)
+}
+
+// List lists the releases of the name(<host>/<user>/<project>), or of all
+// projects if name is empty, in the order of the prefixes. The bodies of the
+// binaries of the returned releases are nil. It returns
+// storage.ErrProjectNotFound if the project of the name is not found.
+func (c *Client) List(ctx context.Context, name string) ([]*release.Release, error) {
+	if name != "" {
+		return c.Storage.ListReleases(ctx, name)
+	}
+	names, err := c.Storage.ProjectNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rels []*release.Release
+	for _, name := range names {
+		r, err := c.Storage.ListReleases(ctx, name)
+		// The project may be pruned after listing the names.
+		if errors.Is(err, storage.ErrProjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, r...)
+	}
+	return rels, nil
+}
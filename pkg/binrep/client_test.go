@@ -0,0 +1,219 @@
+package binrep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// recordProgress records the calls of Progress.
+type recordProgress struct {
+	mu          sync.Mutex
+	sizes       map[string]int64
+	transferred map[string]int64
+	finished    map[string]error
+}
+
+func newRecordProgress() *recordProgress {
+	return &recordProgress{
+		sizes:       map[string]int64{},
+		transferred: map[string]int64{},
+		finished:    map[string]error{},
+	}
+}
+
+func (p *recordProgress) Start(bin *release.Binary, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sizes[bin.Name] = size
+}
+
+func (p *recordProgress) Update(bin *release.Binary, transferred int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transferred[bin.Name] = transferred
+}
+
+func (p *recordProgress) Finish(bin *release.Binary, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished[bin.Name] = err
+}
+
+func TestClient(t *testing.T) {
+	dir := setupPushFiles("droot", "grabeni")
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	progress := newRecordProgress()
+	client := &Client{Storage: storage.NewMemory(), Progress: progress}
+	paths := []string{filepath.Join(dir, "droot"), filepath.Join(dir, "grabeni")}
+
+	// push
+	for _, ts := range []string{"20171017152508", "20171018000000"} {
+		result, err := client.Push(ctx, "github.com/yuuki/droot", paths, &PushOptions{Timestamp: ts, Force: true, Compress: "gzip"})
+		if err != nil {
+			t.Fatalf("timestamp: %s, should not raise error: %s", ts, err)
+		}
+		if result.Skipped || result.Release.Timestamp() != ts {
+			t.Errorf("timestamp: %s, got %+v", ts, result)
+		}
+	}
+	for _, name := range []string{"droot", "grabeni"} {
+		if progress.sizes[name] == 0 || progress.transferred[name] != progress.sizes[name] {
+			t.Errorf("name: %s, transferred %d bytes of %d bytes", name, progress.transferred[name], progress.sizes[name])
+		}
+		if err, ok := progress.finished[name]; !ok || err != nil {
+			t.Errorf("name: %s, should finish without error: %v", name, err)
+		}
+	}
+	result, err := client.Push(ctx, "github.com/yuuki/droot", paths, &PushOptions{
+		Prune: &storage.PruneOptions{Policy: &release.RetentionPolicy{KeepLast: 1}},
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if !result.Skipped || result.Release != nil {
+		t.Errorf("the same binaries should be skipped, got %+v", result)
+	}
+
+	// list
+	rels, err := client.List(ctx, "github.com/yuuki/droot")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	var prefixes []string
+	for _, rel := range rels {
+		prefixes = append(prefixes, rel.Prefix())
+	}
+	expected := []string{"github.com/yuuki/droot/20171017152508", "github.com/yuuki/droot/20171018000000"}
+	if diff := pretty.Compare(prefixes, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+
+	// resolve
+	rel, err := client.Resolve(ctx, "github.com/yuuki/droot", "")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if rel.Timestamp() != "20171018000000" || len(rel.Meta.Binaries) != 2 || rel.Meta.Binaries[0].Body != nil {
+		t.Errorf("got %s with %d binaries", rel.Prefix(), len(rel.Meta.Binaries))
+	}
+
+	// pull
+	installPath, err := ioutil.TempDir("", "binrep-pull")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(installPath)
+	rel, err = client.Pull(ctx, "github.com/yuuki/droot", installPath, &PullOptions{Timestamp: "20171017152508"})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if rel.Timestamp() != "20171017152508" {
+		t.Errorf("got %q, want %q", rel.Timestamp(), "20171017152508")
+	}
+	body, err := ioutil.ReadFile(filepath.Join(installPath, "grabeni"))
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if string(body) != "grabeni" {
+		t.Errorf("got %q, want %q", body, "grabeni")
+	}
+	if progress.transferred["grabeni"] != rel.Meta.Binaries[1].StoredSize() {
+		t.Errorf("downloaded %d bytes, want %d bytes", progress.transferred["grabeni"], rel.Meta.Binaries[1].StoredSize())
+	}
+}
+
+func TestClientPull_noIdentity(t *testing.T) {
+	dir := setupPushFiles("droot")
+	defer os.RemoveAll(dir)
+	id, err := release.GenerateIdentity()
+	if err != nil {
+		panic(err)
+	}
+	client := New(storage.NewMemory())
+	_, err = client.Push(context.Background(), "github.com/yuuki/droot", []string{filepath.Join(dir, "droot")}, &PushOptions{
+		Recipients: []string{id.Recipient()},
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	_, err = client.Pull(context.Background(), "github.com/yuuki/droot", dir, nil)
+
+	if !release.IsNoIdentityError(err) {
+		t.Errorf("should raise NoIdentityError, got %v", err)
+	}
+}
+
+func TestClientPull_chainedLinks(t *testing.T) {
+	link := func(name, target string) *release.Binary {
+		return &release.Binary{Name: name, Link: target}
+	}
+	evil, err := release.BuildBinary("c/evil", 0644, strings.NewReader("evil"))
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	tests := []struct {
+		desc     string
+		releases [][]*release.Binary
+	}{
+		{
+			// c resolves to the parent of installPath on disk although each
+			// link is within installPath lexically.
+			desc:     "in one release",
+			releases: [][]*release.Binary{{link("a/b", ".."), link("c", "a/b/.."), evil}},
+		},
+		{
+			desc:     "links of the previous release",
+			releases: [][]*release.Binary{{link("a/b", ".."), link("c", "a/b/..")}, {evil}},
+		},
+		{
+			desc:     "link through the link of the previous release",
+			releases: [][]*release.Binary{{link("a/b", "..")}, {link("c", "a/b/.."), evil}},
+		},
+	}
+	for _, tc := range tests {
+		tmp, err := ioutil.TempDir("", "binrep-pull")
+		if err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+		installPath := filepath.Join(tmp, "install")
+		if err := os.Mkdir(installPath, 0755); err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+		st := storage.NewMemory()
+		client := New(st)
+		timestamps := []string{"20171017152508", "20171018000000"}
+
+		failed := false
+		for i, bins := range tc.releases {
+			if _, err := st.CreateRelease(context.Background(), "github.com/yuuki/droot", timestamps[i], release.NewMeta(bins)); err != nil {
+				t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+			}
+			if _, err := client.Pull(context.Background(), "github.com/yuuki/droot", installPath, &PullOptions{Timestamp: timestamps[i]}); err != nil {
+				failed = true
+			}
+		}
+
+		if !failed {
+			t.Errorf("desc: %s, should raise error", tc.desc)
+		}
+		if fi, err := os.Lstat(filepath.Join(installPath, "c")); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("desc: %s, the link c pointing outside of the install path should not be created", tc.desc)
+		}
+		if _, err := os.Lstat(filepath.Join(tmp, "evil")); !os.IsNotExist(err) {
+			t.Errorf("desc: %s, evil should not be written outside of the install path", tc.desc)
+		}
+		os.RemoveAll(tmp)
+	}
+}
@@ -0,0 +1,56 @@
+package binrep
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+// progressReader reports the bytes read from r to the progress.
+type progressReader struct {
+	r        io.Reader
+	bin      *release.Binary
+	progress Progress
+	read     int64
+}
+
+func newProgressReader(r io.Reader, bin *release.Binary, progress Progress) *progressReader {
+	progress.Start(bin, bin.StoredSize())
+	return &progressReader{r: r, bin: bin, progress: progress}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.progress.Update(r.bin, r.read)
+	}
+	return n, err
+}
+
+// Seek seeks r if it is io.Seeker, so that the upload can be retried.
+func (r *progressReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := r.r.(io.Seeker)
+	if !ok {
+		return 0, errors.Errorf("failed to seek %s: the body is not seekable", r.bin.Name)
+	}
+	n, err := s.Seek(offset, whence)
+	if err != nil {
+		return n, err
+	}
+	if n != r.read {
+		r.read = n
+		r.progress.Update(r.bin, r.read)
+	}
+	return n, nil
+}
+
+// Close closes r if it is io.Closer.
+func (r *progressReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
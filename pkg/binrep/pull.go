@@ -0,0 +1,332 @@
+package binrep
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/fujiwara/shapeio"
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/archive"
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+// PullOptions represents the options of Pull.
+type PullOptions struct {
+	// Timestamp is the timestamp of the release, which is the latest release
+	// if it is empty.
+	Timestamp string
+	// MaxBandWidth is the limit of the bytes per second, which is unlimited
+	// if it is 0.
+	MaxBandWidth uint64
+	// Extract is the options to extract the archives after validating the
+	// checksums. The archives are not extracted if it is nil.
+	Extract *archive.Options
+	// Identities is the private keys to decrypt the encrypted releases.
+	Identities []*release.Identity
+}
+
+// Pull pulls the release of the name(<host>/<user>/<project>) into
+// installPath, and returns the pulled release.
+func (c *Client) Pull(ctx context.Context, name, installPath string, opts *PullOptions) (*release.Release, error) {
+	if opts == nil {
+		opts = &PullOptions{}
+	}
+	fi, err := os.Stat(installPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %q", installPath)
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf("%q not directory", installPath)
+	}
+
+	rel, err := c.find(ctx, name, opts.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBodies(rel)
+
+	dataKey, err := unwrapDataKey(rel, opts.Identities)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger().Info("Downloading the release", logging.KeyProject, rel.Name(), logging.KeyTimestamp, rel.Timestamp(),
+		"url", rel.URL.String(), "path", installPath)
+
+	start := time.Now()
+	if err := c.pullRelease(ctx, rel, installPath, opts, dataKey); err != nil {
+		return nil, err
+	}
+	c.logger().Info("Downloaded the release", logging.KeyProject, rel.Name(), logging.KeyTimestamp, rel.Timestamp(),
+		logging.KeyBytes, storedSize(rel.Meta), logging.KeyDuration, time.Since(start))
+	return rel, nil
+}
+
+// unwrapDataKey unwraps the data key of the encrypted release with ids. It
+// returns nil if the release is not encrypted, and release.NoIdentityError
+// if no identity matches the recipients.
+func unwrapDataKey(rel *release.Release, ids []*release.Identity) ([]byte, error) {
+	enc := rel.Meta.Encryption
+	if enc == nil {
+		return nil, nil
+	}
+	dataKey, err := enc.Unwrap(ids)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt %s", rel.URL)
+	}
+	return dataKey, nil
+}
+
+// pullRelease pulls the binaries of rel into installPath. The encrypted
+// binaries are decrypted with dataKey. The links are created after all
+// regular files are written, and no file is written through a link, so that
+// the links of a malicious release can't redirect the files outside of
+// installPath.
+func (c *Client) pullRelease(ctx context.Context, rel *release.Release, installPath string, opts *PullOptions, dataKey []byte) error {
+	// Validate all names before writing anything into installPath.
+	for _, bin := range rel.Meta.Binaries {
+		if err := bin.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, bin := range rel.Meta.Binaries {
+		if bin.IsLink() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path, err := preparePath(installPath, bin.Name)
+		if err != nil {
+			return err
+		}
+		if opts.Extract != nil && archive.IsArchive(bin.Name) {
+			if err := c.pullArchive(ctx, bin, path, opts, dataKey); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.pullBinary(ctx, bin, path, opts.MaxBandWidth, dataKey); err != nil {
+			return err
+		}
+	}
+	for _, bin := range rel.Meta.Binaries {
+		if !bin.IsLink() {
+			continue
+		}
+		if err := resolveLink(installPath, bin); err != nil {
+			return err
+		}
+		path, err := preparePath(installPath, bin.Name)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(filepath.FromSlash(bin.Link), path); err != nil {
+			return errors.Wrapf(err, "failed to create link %v", path)
+		}
+	}
+	return nil
+}
+
+// preparePath returns the path of name within installPath after creating
+// the parent directories and removing the existing file of name. It returns
+// error if any parent directory of the path is a symbolic link, not to write
+// files outside of installPath through the links.
+func preparePath(installPath, name string) (string, error) {
+	elems := strings.Split(name, "/")
+	p := installPath
+	for _, elem := range elems[:len(elems)-1] {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to stat %v", p)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", errors.Errorf("invalid binary %q: %v is a symbolic link", name, p)
+		}
+		if !fi.IsDir() {
+			return "", errors.Errorf("invalid binary %q: %v is not a directory", name, p)
+		}
+	}
+	path := filepath.Join(installPath, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %v", filepath.Dir(path))
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "failed to remove %v", path)
+	}
+	return path, nil
+}
+
+// resolveLink resolves the target of the link against the files within
+// installPath. It returns error if the target leaves installPath or goes
+// through another link, such as 'c -> a/b/..' after 'a/b -> ..', which
+// Binary.Validate can't detect lexically.
+func resolveLink(installPath string, bin *release.Binary) error {
+	var elems []string
+	if dir := path.Dir(bin.Name); dir != "." {
+		elems = strings.Split(dir, "/")
+	}
+	for _, elem := range strings.Split(bin.Link, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(elems) == 0 {
+				return errors.Errorf("invalid link %q -> %q: must point within the release", bin.Name, bin.Link)
+			}
+			elems = elems[:len(elems)-1]
+			continue
+		}
+		elems = append(elems, elem)
+		p := filepath.Join(installPath, filepath.FromSlash(strings.Join(elems, "/")))
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %v", p)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return errors.Errorf("invalid link %q -> %q: %v is a symbolic link", bin.Name, bin.Link, p)
+		}
+	}
+	return nil
+}
+
+// pullArchive pulls the archive into the temporary file next to path, and
+// extracts it into the directory of path.
+func (c *Client) pullArchive(ctx context.Context, bin *release.Binary, path string, opts *PullOptions, dataKey []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".binrep-*-"+filepath.Base(path))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file for %v", path)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = c.copyBinary(ctx, tmp, bin, opts.MaxBandWidth, dataKey)
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = errors.Wrapf(cerr, "failed to close %v", tmp.Name())
+	}
+	if err != nil {
+		return err
+	}
+	c.logger().Info("Extracting the archive", logging.KeyBinary, bin.Name, "path", filepath.Dir(path))
+	return archive.Extract(filepath.Dir(path), tmp.Name(), opts.Extract)
+}
+
+// pullBinary pulls the binary into path, which preparePath has removed. It
+// fails rather than writing through the link created at path meanwhile.
+func (c *Client) pullBinary(ctx context.Context, bin *release.Binary, path string, maxBandWidth uint64, dataKey []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, bin.Mode)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %v", path)
+	}
+	defer file.Close()
+	if err := c.copyBinary(ctx, file, bin, maxBandWidth, dataKey); err != nil {
+		// Don't leave the partial binary on the interruption.
+		if release.IsChecksumError(err) || ctx.Err() != nil {
+			os.Remove(path)
+		}
+		return err
+	}
+	return nil
+}
+
+// copyBinary copies the plaintext of the body of bin into w, and validates
+// the checksum of it.
+func (c *Client) copyBinary(ctx context.Context, w io.Writer, bin *release.Binary, maxBandWidth uint64, dataKey []byte) (err error) {
+	lsrc := shapeio.NewReader(bin.Body)
+	if maxBandWidth != 0 {
+		c.logger().Debug("Limit the bandwidth", logging.KeyBinary, bin.Name,
+			"max_bandwidth", humanize.Bytes(maxBandWidth)+"/s")
+		lsrc.SetRateLimit(float64(maxBandWidth))
+	}
+	var stored io.Reader = lsrc
+	if c.Progress != nil {
+		stored = newProgressReader(lsrc, bin, c.Progress)
+		defer func() { c.Progress.Finish(bin, err) }()
+	}
+	// Throttle the stored stream, which is encrypted and compressed if the
+	// binary is so. The plaintext is validated by the checksum.
+	plain, err := bin.NewDecryptReader(stored, dataKey)
+	if err != nil {
+		return err
+	}
+	src, err := bin.NewDecompressReader(plain)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	start := time.Now()
+	n, err := bin.CopyAndValidateChecksum(w, src)
+	if err != nil {
+		return err
+	}
+	c.logger().Debug("Downloaded the binary", logging.KeyBinary, bin.Name,
+		logging.KeyBytes, n, logging.KeyDuration, time.Since(start))
+	return nil
+}
+
+// Download downloads the binary of binName of the release of the
+// name(<host>/<user>/<project>) into w, and returns the release. The data
+// written into w should be discarded if it fails, such as on the checksum
+// mismatch. opts.Extract is ignored.
+func (c *Client) Download(ctx context.Context, name, binName string, w io.Writer, opts *PullOptions) (*release.Release, error) {
+	if opts == nil {
+		opts = &PullOptions{}
+	}
+	rel, err := c.find(ctx, name, opts.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBodies(rel)
+
+	var bin *release.Binary
+	for _, b := range rel.Meta.Binaries {
+		if b.Name == binName && !b.IsLink() {
+			bin = b
+		}
+	}
+	if bin == nil {
+		return nil, errors.Errorf("no such binary %q in %s", binName, rel.URL)
+	}
+	dataKey, err := unwrapDataKey(rel, opts.Identities)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger().Info("Downloading the binary", logging.KeyProject, rel.Name(), logging.KeyTimestamp, rel.Timestamp(),
+		logging.KeyBinary, bin.Name)
+
+	if err := c.copyBinary(ctx, w, bin, opts.MaxBandWidth, dataKey); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// closeBodies closes the bodies of rel which are io.Closer.
+func closeBodies(rel *release.Release) {
+	closeBinaries(rel.Meta.Binaries)
+}
+
+// closeBinaries closes the bodies of bins which are io.Closer, such as the
+// temporary files of the compressed bodies.
+func closeBinaries(bins []*release.Binary) {
+	for _, bin := range bins {
+		if closer, ok := bin.Body.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
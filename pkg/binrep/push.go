@@ -0,0 +1,366 @@
+package binrep
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// PushOptions represents the options of Push.
+type PushOptions struct {
+	// Timestamp is the timestamp of the release, which is the current time
+	// if it is empty.
+	Timestamp string
+	// Force pushes the binaries even if they have the same checksums as the
+	// latest release.
+	Force         bool
+	RequireStatic bool
+	// Compress is the compression algorithm of the binaries such as 'gzip'.
+	Compress string
+	// Renames maps the local paths to the binary names within the release.
+	Renames map[string]string
+	// Recipients is the public keys to which the binaries are encrypted.
+	Recipients []string
+	// Tags are the names of the release such as the versions. The tagged
+	// releases are never pruned.
+	Tags []string
+	// Channels are the channels such as 'stable' which the release is pushed
+	// to. The latest release of each channel is never pruned.
+	Channels []string
+	// Prune is the options to prune the old releases after pushing. The
+	// releases are not pruned if it is nil.
+	Prune *storage.PruneOptions
+}
+
+// PushResult represents the result of Push.
+type PushResult struct {
+	// Release is the pushed release, which is nil if skipped.
+	Release *release.Release
+	// Skipped is whether the push is skipped because the binaries have the
+	// same checksums as the latest release.
+	Skipped bool
+	// Retentions is the retentions of the releases by pruning.
+	Retentions []*release.Retention
+}
+
+// Push pushes the files as the release of the name(<host>/<user>/<project>).
+// The directories of files are pushed recursively with the relative paths.
+func (c *Client) Push(ctx context.Context, name string, files []string, opts *PushOptions) (*PushResult, error) {
+	if opts == nil {
+		opts = &PushOptions{}
+	}
+	if opts.Compress != "" {
+		if err := release.ValidateCompression(opts.Compress); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range opts.Recipients {
+		if _, err := release.ParseRecipient(r); err != nil {
+			return nil, err
+		}
+	}
+	timestamp := opts.Timestamp
+	if timestamp == "" {
+		timestamp = release.Now()
+	} else if _, err := release.ParseTimestamp(timestamp); err != nil {
+		return nil, errors.Wrapf(err, "invalid timestamp %q", timestamp)
+	}
+	pfiles, err := c.collectFiles(files, opts.Renames)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFileNames(pfiles); err != nil {
+		return nil, err
+	}
+	bins := make([]*release.Binary, 0, len(pfiles))
+	defer func() { closeBinaries(bins) }()
+	for _, f := range pfiles {
+		bin, err := f.buildBinary(opts)
+		if err != nil {
+			return nil, err
+		}
+		bins = append(bins, bin)
+	}
+
+	if !opts.Force {
+		ok, err := c.Storage.ExistRelease(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ok, err := c.Storage.HaveSameChecksums(ctx, name, bins)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				c.logger().Info("Skip pushing the binaries because they have the same checksums as the latest release",
+					logging.KeyProject, name)
+				return &PushResult{Skipped: true}, nil
+			}
+		}
+	}
+
+	if opts.Compress != "" {
+		for _, bin := range bins {
+			if bin.IsLink() {
+				continue
+			}
+			if err := bin.Compress(opts.Compress); err != nil {
+				return nil, err
+			}
+			c.logger().Info("Compressed the binary", logging.KeyBinary, bin.Name, "compression", bin.Compression,
+				"size", bin.Size, "compressed_size", bin.CompressedSize)
+		}
+	}
+
+	meta := release.NewMeta(bins)
+	meta.Tags, meta.Channels = opts.Tags, opts.Channels
+	if len(opts.Recipients) > 0 {
+		enc, dataKey, err := release.NewEncryption(opts.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		for _, bin := range bins {
+			if bin.IsLink() {
+				continue
+			}
+			if err := bin.Encrypt(dataKey); err != nil {
+				return nil, err
+			}
+		}
+		meta.Encryption = enc
+		c.logger().Info("Encrypting the binaries", "recipients", opts.Recipients)
+	}
+
+	c.logger().Info("Uploading the release", logging.KeyProject, name, logging.KeyTimestamp, timestamp, "files", files)
+
+	start := time.Now()
+	rel, err := c.createRelease(ctx, name, timestamp, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger().Info("Uploaded the release", logging.KeyProject, name, logging.KeyTimestamp, timestamp,
+		"url", rel.URL.String(), logging.KeyBytes, storedSize(meta), logging.KeyDuration, time.Since(start))
+
+	result := &PushResult{Release: rel}
+	if opts.Prune == nil {
+		return result, nil
+	}
+
+	c.logger().Info("Cleaning up the old releases", logging.KeyProject, name)
+
+	result.Retentions, err = c.Storage.PruneReleases(ctx, name, opts.Prune)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger().Info("Cleaned up the old releases", logging.KeyProject, name,
+		"pruned", prunedTimestamps(result.Retentions))
+
+	return result, nil
+}
+
+// createRelease creates the release reporting the progress of the uploads.
+func (c *Client) createRelease(ctx context.Context, name, timestamp string, meta *release.Meta) (*release.Release, error) {
+	if c.Progress == nil {
+		return c.Storage.CreateRelease(ctx, name, timestamp, meta)
+	}
+	var uploading []*release.Binary
+	for _, bin := range meta.Binaries {
+		if bin.IsLink() {
+			continue
+		}
+		bin.Body = newProgressReader(bin.Body, bin, c.Progress)
+		uploading = append(uploading, bin)
+	}
+	rel, err := c.Storage.CreateRelease(ctx, name, timestamp, meta)
+	for _, bin := range uploading {
+		c.Progress.Finish(bin, err)
+	}
+	return rel, err
+}
+
+// storedSize returns the total size of the stored bodies of the binaries.
+func storedSize(meta *release.Meta) int64 {
+	var size int64
+	for _, bin := range meta.Binaries {
+		if !bin.IsLink() {
+			size += bin.StoredSize()
+		}
+	}
+	return size
+}
+
+// prunedTimestamps returns the timestamps of the releases not to be kept.
+func prunedTimestamps(rets []*release.Retention) []string {
+	var timestamps []string
+	for _, ret := range rets {
+		if !ret.Keep {
+			timestamps = append(timestamps, ret.Timestamp)
+		}
+	}
+	return timestamps
+}
+
+// pushFile represents a local file to push.
+type pushFile struct {
+	path string      // local path
+	name string      // slash-separated binary name within the release
+	info os.FileInfo // lstat info for files in directories, stat info for the others
+}
+
+// collectFiles collects the files of binPaths. The directories are walked
+// recursively, and the files within them are named by the slash-separated
+// paths relative to the directories. Symbolic links within the directories
+// are collected as links rather than followed.
+func (c *Client) collectFiles(binPaths []string, renames map[string]string) ([]*pushFile, error) {
+	var files []*pushFile
+	add := func(p, name string, fi os.FileInfo) {
+		if dst, ok := renames[filepath.Clean(p)]; ok {
+			name = dst
+		}
+		files = append(files, &pushFile{path: p, name: path.Clean(filepath.ToSlash(name)), info: fi})
+	}
+	for _, binPath := range binPaths {
+		fi, err := os.Stat(binPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat %q", binPath)
+		}
+		if !fi.IsDir() {
+			add(binPath, filepath.Base(binPath), fi)
+			continue
+		}
+		err = filepath.Walk(binPath, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return errors.Wrapf(err, "failed to walk %q", p)
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			if !fi.Mode().IsRegular() && fi.Mode()&os.ModeSymlink == 0 {
+				c.logger().Warn("Skip the file which is not a regular file", "path", p)
+				return nil
+			}
+			rel, err := filepath.Rel(binPath, p)
+			if err != nil {
+				return errors.Wrapf(err, "failed to get relative path of %q", p)
+			}
+			add(p, rel, fi)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// validateFileNames validates that no two files have the same name within
+// the release and that no file name is the directory of another file name,
+// such as 'bin' and 'bin/tool', and suggests --rename mappings if they
+// collide.
+func validateFileNames(files []*pushFile) error {
+	paths := make(map[string][]string, len(files))
+	var names []string
+	for _, f := range files {
+		if _, ok := paths[f.name]; !ok {
+			names = append(names, f.name)
+		}
+		paths[f.name] = append(paths[f.name], f.path)
+	}
+	var msgs, suggestions []string
+	for _, name := range names {
+		if len(paths[name]) < 2 {
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("%q: %s", name, strings.Join(paths[name], ", ")))
+		for _, p := range paths[name][1:] {
+			suggestions = append(suggestions, fmt.Sprintf("--rename %s=%s", p, suggestName(p)))
+		}
+	}
+	for _, name := range names {
+		elems := strings.Split(name, "/")
+		for i := 1; i < len(elems); i++ {
+			dir := strings.Join(elems[:i], "/")
+			if _, ok := paths[dir]; !ok {
+				continue
+			}
+			msgs = append(msgs, fmt.Sprintf("%q is the directory of %q: %s, %s", dir, name, paths[dir][0], paths[name][0]))
+			suggestions = append(suggestions, fmt.Sprintf("--rename %s=%s", paths[dir][0], suggestName(paths[dir][0])))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.Errorf("duplicate file names in a release: %s\nUse the unique names such as: %s",
+		strings.Join(msgs, "; "), strings.Join(suggestions, " "))
+}
+
+// suggestName suggests the unique name by the parent directory of path.
+// eg. 'bin/b/tool' => 'b/tool'
+func suggestName(p string) string {
+	dir, base := filepath.Split(filepath.Clean(p))
+	parent := filepath.Base(dir)
+	if parent == "." || parent == string(filepath.Separator) {
+		return base + ".1"
+	}
+	return parent + "/" + base
+}
+
+// buildBinary builds the binary of the file.
+func (f *pushFile) buildBinary(opts *PushOptions) (*release.Binary, error) {
+	if f.info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(f.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read link %q", f.path)
+		}
+		bin := release.BuildLink(f.name, filepath.ToSlash(target))
+		if err := bin.Validate(); err != nil {
+			return nil, err
+		}
+		return bin, nil
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", f.path)
+	}
+	bin, err := inspectBinary(f.name, f.info.Mode(), file, opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return bin, nil
+}
+
+// inspectBinary builds the binary of the opened file and validates it. The
+// caller closes file if it returns an error.
+func inspectBinary(name string, mode os.FileMode, file *os.File, opts *PushOptions) (*release.Binary, error) {
+	bin, err := release.BuildBinary(name, mode, file)
+	if err != nil {
+		return nil, err
+	}
+	if err := bin.Validate(); err != nil {
+		return nil, err
+	}
+	bin.ELF, err = release.InspectELF(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect %q", file.Name())
+	}
+	if opts.RequireStatic {
+		if err := bin.ValidateStatic(); err != nil {
+			return nil, err
+		}
+	}
+	return bin, nil
+}
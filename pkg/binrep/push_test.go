@@ -0,0 +1,144 @@
+package binrep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+func setupPushFiles(paths ...string) string {
+	dir, err := ioutil.TempDir("", "binrep-push")
+	if err != nil {
+		panic(err)
+	}
+	for _, p := range paths {
+		path := filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p), 0755); err != nil {
+			panic(err)
+		}
+	}
+	return dir
+}
+
+func TestPush_duplicateFileNames(t *testing.T) {
+	dir := setupPushFiles("bin/a/tool", "bin/b/tool")
+	defer os.RemoveAll(dir)
+
+	a, b := filepath.Join(dir, "bin/a/tool"), filepath.Join(dir, "bin/b/tool")
+
+	_, err := New(storage.NewMemory()).Push(context.Background(), "github.com/yuuki/tools", []string{a, b}, nil)
+
+	if err == nil {
+		t.Fatal("should raise error")
+	}
+	for _, want := range []string{`"tool": ` + a + ", " + b, "--rename " + b + "=b/tool"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q should contain %q", err, want)
+		}
+	}
+}
+
+func TestPush_fileNameCollidesWithDirectory(t *testing.T) {
+	dir := setupPushFiles("bin", "dist/bin/tool")
+	defer os.RemoveAll(dir)
+
+	file, tool := filepath.Join(dir, "bin"), filepath.Join(dir, "dist/bin/tool")
+
+	_, err := New(storage.NewMemory()).Push(context.Background(), "github.com/yuuki/tools", []string{file, tool}, &PushOptions{
+		Renames: map[string]string{tool: "bin/tool"},
+	})
+
+	if err == nil {
+		t.Fatal("should raise error")
+	}
+	for _, want := range []string{`"bin" is the directory of "bin/tool": ` + file + ", " + tool, "--rename " + file + "="} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q should contain %q", err, want)
+		}
+	}
+}
+
+func TestCollectFiles(t *testing.T) {
+	dir := setupPushFiles("bin/a/tool", "bin/b/tool", "dist/index.html", "dist/css/app.css")
+	defer os.RemoveAll(dir)
+	if err := os.Symlink("index.html", filepath.Join(dir, "dist/default.html")); err != nil {
+		panic(err)
+	}
+
+	tests := []struct {
+		desc          string
+		paths         []string
+		renames       map[string]string
+		expectedNames []string
+		isErr         bool
+	}{
+		{
+			desc:          "duplicate file names",
+			paths:         []string{"bin/a/tool", "bin/b/tool"},
+			expectedNames: []string{"tool", "tool"},
+			isErr:         true,
+		},
+		{
+			desc:          "renamed",
+			paths:         []string{"bin/a/tool", "bin/b/tool"},
+			renames:       map[string]string{filepath.Join(dir, "bin/b/tool"): "b/tool"},
+			expectedNames: []string{"tool", "b/tool"},
+			isErr:         false,
+		},
+		{
+			desc:          "normalized name collides with directory entry",
+			paths:         []string{"dist", "bin/a/tool"},
+			renames:       map[string]string{filepath.Join(dir, "bin/a/tool"): "./css//app.css"},
+			expectedNames: []string{"css/app.css", "default.html", "index.html", "css/app.css"},
+			isErr:         true,
+		},
+		{
+			desc:          "file name collides with directory",
+			paths:         []string{"dist", "bin/a/tool"},
+			renames:       map[string]string{filepath.Join(dir, "bin/a/tool"): "css"},
+			expectedNames: []string{"css/app.css", "default.html", "index.html", "css"},
+			isErr:         true,
+		},
+		{
+			desc:          "directory",
+			paths:         []string{"dist"},
+			expectedNames: []string{"css/app.css", "default.html", "index.html"},
+			isErr:         false,
+		},
+	}
+	for _, tc := range tests {
+		paths := make([]string, 0, len(tc.paths))
+		for _, p := range tc.paths {
+			paths = append(paths, filepath.Join(dir, p))
+		}
+
+		files, err := New(nil).collectFiles(paths, tc.renames)
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			names = append(names, f.name)
+		}
+		if strings.Join(names, ",") != strings.Join(tc.expectedNames, ",") {
+			t.Errorf("desc: %s, got %v, want %v", tc.desc, names, tc.expectedNames)
+		}
+
+		err = validateFileNames(files)
+		if tc.isErr && err == nil {
+			t.Errorf("desc: %s, should raise error", tc.desc)
+		}
+		if !tc.isErr && err != nil {
+			t.Errorf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// AuditParam represents the option parameter of `audit`.
+type AuditParam struct {
+	// Since is the duration such as '24h' and '7d', or the time such as
+	// '2017-10-17' and '2017-10-17T15:25:08Z'.
+	Since   string
+	Project string
+	JSON    bool
+}
+
+// Audit prints the audit records of the mutations of the repository.
+func Audit(ctx context.Context, param *AuditParam) error {
+	var since time.Time
+	if param.Since != "" {
+		var err error
+		since, err = parseSince(param.Since, time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse --since %q", param.Since)
+		}
+	}
+
+	st, err := storage.Open()
+	if err != nil {
+		return err
+	}
+	log, ok := st.(storage.AuditLog)
+	if !ok {
+		return errors.New("the storage has no audit log")
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	// Format in tab-separated columns with a tab stop of 8.
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	if !param.JSON {
+		fmt.Fprintln(tw, "TIME\tACTION\tPROJECT\tTIMESTAMP\tACTOR\tHOST\tCOMMAND")
+	}
+	err = log.WalkAudit(ctx, since, func(rec *storage.AuditRecord) error {
+		if param.Project != "" && rec.Project != param.Project {
+			return nil
+		}
+		if param.JSON {
+			return enc.Encode(rec)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.Time.UTC().Format(time.RFC3339),
+			rec.Action, rec.Project, rec.Timestamp, rec.Actor, rec.Host, rec.Command)
+		return nil
+	})
+	tw.Flush()
+	return err
+}
+
+// parseSince parses the duration before now, or the date or the time in
+// RFC 3339.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid duration or time %q", s)
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,36 @@
+package command
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2017, 10, 17, 15, 25, 8, 0, time.UTC)
+	tests := []struct {
+		input    string
+		expected time.Time
+		isErr    bool
+	}{
+		{input: "24h", expected: time.Date(2017, 10, 16, 15, 25, 8, 0, time.UTC)},
+		{input: "7d", expected: time.Date(2017, 10, 10, 15, 25, 8, 0, time.UTC)},
+		{input: "2017-10-01T00:00:00Z", expected: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{input: "2017-10-01", expected: time.Date(2017, 10, 1, 0, 0, 0, 0, time.Local)},
+		{input: "yesterday", isErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseSince(tc.input, now)
+		if tc.isErr {
+			if err == nil {
+				t.Errorf("input: %q, should raise error", tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("input: %q, should not raise error: %s", tc.input, err)
+		}
+		if !got.Equal(tc.expected) {
+			t.Errorf("input: %q, got %s, want %s", tc.input, got, tc.expected)
+		}
+	}
+}
@@ -1,9 +1,63 @@
 package command
 
 import (
-	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/yuuki/binrep/pkg/binrep"
+	"github.com/yuuki/binrep/pkg/config"
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/notify"
+	"github.com/yuuki/binrep/pkg/progress"
+	"github.com/yuuki/binrep/pkg/storage"
 )
 
-func init() {
-	log.SetFlags(0)
+// openStorage opens the storage of the config, which notifies the webhooks
+// of the config unless NoNotify.
+func openStorage() (storage.API, error) {
+	return openStorageWithOptions(storage.ObjectOptionsFromConfig())
+}
+
+// openStorageWithOptions opens the storage like openStorage, which puts the
+// objects with opts.
+func openStorageWithOptions(opts *storage.ObjectOptions) (storage.API, error) {
+	st, err := storage.OpenWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if config.Config.NoNotify || len(config.Config.Webhooks) == 0 {
+		return st, nil
+	}
+	n, err := notify.New(config.Config.Webhooks, config.Config.WebhookSecret, config.Config.RetryMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	return notify.WithNotify(st, n), nil
+}
+
+// newClient creates the client of the storage of the config, which logs to
+// the default logger. The progress is drawn as the bars on the terminal, and
+// logged as the records otherwise.
+func newClient() (*binrep.Client, error) {
+	return newClientWithOptions(storage.ObjectOptionsFromConfig())
+}
+
+// newClientWithOptions creates the client like newClient, which puts the
+// objects with opts.
+func newClientWithOptions(opts *storage.ObjectOptions) (*binrep.Client, error) {
+	st, err := openStorageWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	client := binrep.New(st)
+	client.SetLogger(slog.Default())
+	if !config.Config.NoProgress {
+		if progress.IsTerminal(os.Stderr) && strings.ToLower(config.Config.LogFormat) != logging.FormatJSON {
+			client.Progress = progress.New(os.Stderr)
+		} else {
+			client.Progress = progress.NewLogger(slog.Default())
+		}
+	}
+	return client, nil
 }
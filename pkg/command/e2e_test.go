@@ -0,0 +1,214 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/yuuki/binrep/pkg/config"
+	"github.com/yuuki/binrep/pkg/notify"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+	"github.com/yuuki/binrep/pkg/storage/storagetest"
+)
+
+// setupServer starts the S3-compatible server, and points the config to it.
+func setupServer(t *testing.T) (*storagetest.Server, func()) {
+	srv := storagetest.NewServer("binrep-testing")
+	// Page every response to test the composition of the list calls.
+	srv.MaxKeys = 1
+
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID":       "AKID",
+		"AWS_SECRET_ACCESS_KEY":   "SECRET",
+		"AWS_SESSION_TOKEN":       "",
+		"AWS_PROFILE":             "",
+		"BINREP_BACKEND_ENDPOINT": srv.Endpoint("binrep-testing"),
+	}
+	saved := map[string]string{}
+	for k, v := range env {
+		saved[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	savedConfig := *config.Config
+	if err := config.Load(); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	return srv, func() {
+		srv.Close()
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+		*config.Config = savedConfig
+	}
+}
+
+func writeBinary(t *testing.T, dir, name, body string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(body), 0755); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	return path
+}
+
+func walkPrefixes(t *testing.T) []string {
+	st, err := storage.Open()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	var prefixes []string
+	err = st.WalkReleases(context.Background(), 1, func(rel *release.Release) error {
+		prefixes = append(prefixes, rel.Prefix())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	return prefixes
+}
+
+func TestEndToEnd(t *testing.T) {
+	_, teardown := setupServer(t)
+	defer teardown()
+
+	dir, err := ioutil.TempDir("", "binrep-e2e")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		eventsMu sync.Mutex
+		events   []string
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !notify.Verify("webhook-secret", body, r.Header.Get(notify.HeaderSignature)) {
+			t.Errorf("invalid signature %q", r.Header.Get(notify.HeaderSignature))
+		}
+		var ev notify.Event
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Errorf("should not raise error: %s", err)
+		}
+		e := ev.Action + " " + ev.Project + " " + ev.Timestamp
+		for _, p := range ev.Pruned {
+			e += p.Timestamp + ","
+		}
+		eventsMu.Lock()
+		events = append(events, e)
+		eventsMu.Unlock()
+	}))
+	defer receiver.Close()
+	config.Config.Webhooks = []string{receiver.URL + "/hooks/binrep"}
+	config.Config.WebhookSecret = "webhook-secret"
+
+	// push
+	timestamps := []string{"20171017152508", "20171017152626", "20171018000000", "20171019000000"}
+	for i, ts := range timestamps {
+		bin := writeBinary(t, dir, "droot", "droot-body-"+ts)
+		param := &PushParam{Timestamp: ts, KeepReleases: 3, NoPrune: i < len(timestamps)-1}
+		if err := Push(context.Background(), param, "github.com/yuuki/droot", []string{bin}); err != nil {
+			t.Fatalf("timestamp: %s, should not raise error: %s", ts, err)
+		}
+	}
+	bin := writeBinary(t, dir, "grabeni", "grabeni-body")
+	if err := Push(context.Background(), &PushParam{Timestamp: "20171017152508", NoPrune: true}, "github.com/yuuki/grabeni", []string{bin}); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	// list
+	expected := []string{
+		"github.com/yuuki/droot/20171017152626",
+		"github.com/yuuki/droot/20171018000000",
+		"github.com/yuuki/droot/20171019000000",
+		"github.com/yuuki/grabeni/20171017152508",
+	}
+	if diff := pretty.Compare(walkPrefixes(t), expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+
+	// pull
+	installPath := filepath.Join(dir, "bin")
+	if err := os.Mkdir(installPath, 0755); err != nil {
+		panic(err)
+	}
+	if err := Pull(context.Background(), &PullParam{}, "github.com/yuuki/droot", installPath); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	body, err := ioutil.ReadFile(filepath.Join(installPath, "droot"))
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if string(body) != "droot-body-20171019000000" {
+		t.Errorf("got %q, want %q", body, "droot-body-20171019000000")
+	}
+
+	// prune
+	if err := Prune(context.Background(), &PruneParam{All: true, KeepReleases: 1}, ""); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expected = []string{
+		"github.com/yuuki/droot/20171019000000",
+		"github.com/yuuki/grabeni/20171017152508",
+	}
+	if diff := pretty.Compare(walkPrefixes(t), expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+
+	// audit
+	st, err := storage.Open()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	var actions []string
+	err = st.(storage.AuditLog).WalkAudit(context.Background(), time.Time{}, func(rec *storage.AuditRecord) error {
+		if rec.Project == "github.com/yuuki/droot" {
+			actions = append(actions, rec.Action+" "+rec.Timestamp)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expected = []string{
+		"create 20171017152508",
+		"create 20171017152626",
+		"create 20171018000000",
+		"create 20171019000000",
+		"prune 20171017152508",
+		"prune 20171017152626",
+		"prune 20171018000000",
+	}
+	if diff := pretty.Compare(actions, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+
+	// webhooks
+	expected = []string{
+		"create github.com/yuuki/droot 20171017152508",
+		"create github.com/yuuki/droot 20171017152626",
+		"create github.com/yuuki/droot 20171018000000",
+		"create github.com/yuuki/droot 20171019000000",
+		"prune github.com/yuuki/droot 20171017152508,",
+		"create github.com/yuuki/grabeni 20171017152508",
+		"prune github.com/yuuki/droot 20171017152626,20171018000000,",
+	}
+	if diff := pretty.Compare(events, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+
+	// verify: the pruned releases leave the tombstones in the chain.
+	if err := Verify(context.Background(), &VerifyParam{Chain: true}, "github.com/yuuki/droot"); err != nil {
+		t.Errorf("should not raise error: %s", err)
+	}
+}
@@ -0,0 +1,46 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+// KeygenParam represents the option parameter of `keygen`.
+type KeygenParam struct {
+	Output string
+}
+
+// Keygen generates a new identity to decrypt the encrypted releases, and
+// writes it into the output file, or stdout if the output is empty. The
+// public key is printed to be passed to `push --encrypt-to`.
+func Keygen(param *KeygenParam) error {
+	id, err := release.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+	content := id.Format(time.Now().UTC())
+
+	if param.Output == "" {
+		fmt.Fprint(os.Stdout, content)
+		fmt.Fprintln(os.Stderr, "Public key:", id.Recipient())
+		return nil
+	}
+	f, err := os.OpenFile(param.Output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create identity file %q", param.Output)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "failed to write identity file %q", param.Output)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close identity file %q", param.Output)
+	}
+	fmt.Fprintln(os.Stderr, "Public key:", id.Recipient())
+	return nil
+}
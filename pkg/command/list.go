@@ -1,11 +1,8 @@
 package command
 
 import (
+	"context"
 	"fmt"
-
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/yuuki/binrep/pkg/release"
-	"github.com/yuuki/binrep/pkg/storage"
 )
 
 // ListParam represents the option parameter of `list`.
@@ -13,17 +10,19 @@ type ListParam struct {
 }
 
 // List lists releases.
-func List(param *ListParam) error {
-	sess := session.New()
-	st := storage.New(sess)
+func List(ctx context.Context, param *ListParam) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
 
-	err := st.WalkReleases(1, func(rel *release.Release) error {
-		fmt.Println(rel.Prefix())
-		return nil
-	})
+	rels, err := client.List(ctx, "")
 	if err != nil {
 		return err
 	}
+	for _, rel := range rels {
+		fmt.Println(rel.Prefix())
+	}
 
 	return nil
 }
@@ -0,0 +1,104 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// PruneParam represents the option parameter of `prune`.
+type PruneParam struct {
+	All                 bool
+	DryRun              bool
+	KeepReleases        int
+	KeepWithin          string
+	KeepWeekly          string
+	ArchiveStorageClass string
+}
+
+// Prune prunes the old releases of the name(<host>/<user>/<project>), or
+// of all projects if param.All is true, by the retention policy.
+func Prune(ctx context.Context, param *PruneParam, name string) error {
+	policy := &release.RetentionPolicy{KeepLast: param.KeepReleases}
+	var err error
+	if param.KeepWithin != "" {
+		policy.KeepWithin, err = parseDuration(param.KeepWithin)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse --keep-within %q", param.KeepWithin)
+		}
+	}
+	if param.KeepWeekly != "" {
+		policy.KeepWeeklyWithin, err = parseDuration(param.KeepWeekly)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse --keep-weekly %q", param.KeepWeekly)
+		}
+	}
+
+	st, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	names := []string{name}
+	if param.All {
+		names, err = st.ProjectNames(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Format in tab-separated columns with a tab stop of 8.
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "NAME\tTIMESTAMP\tACTION\tREASON")
+	for _, name := range names {
+		rets, err := st.PruneReleases(ctx, name, &storage.PruneOptions{
+			Policy:              policy,
+			DryRun:              param.DryRun,
+			ArchiveStorageClass: param.ArchiveStorageClass,
+		})
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+		for _, ret := range rets {
+			action := "keep"
+			if !ret.Keep {
+				action = "delete"
+				if param.ArchiveStorageClass != "" {
+					action = "archive (" + param.ArchiveStorageClass + ")"
+				}
+				if param.DryRun {
+					action += " (dry-run)"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, ret.Timestamp, action, ret.Reason)
+		}
+	}
+	tw.Flush()
+
+	return nil
+}
+
+// parseDuration parses the duration string accepting the units of
+// day ('d') and week ('w') in addition to time.ParseDuration, eg. '90d', '13w'.
+func parseDuration(s string) (time.Duration, error) {
+	for unit, d := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, unit) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, unit))
+			if err != nil {
+				return 0, errors.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * d, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,36 @@
+package command
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		isErr    bool
+	}{
+		{input: "720h", expected: 720 * time.Hour},
+		{input: "90d", expected: 90 * 24 * time.Hour},
+		{input: "13w", expected: 13 * 7 * 24 * time.Hour},
+		{input: "d", isErr: true},
+		{input: "1.5w", isErr: true},
+		{input: "forever", isErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseDuration(tc.input)
+		if tc.isErr {
+			if err == nil {
+				t.Errorf("input: %q, should raise error", tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("input: %q, should not raise error: %s", tc.input, err)
+		}
+		if got != tc.expected {
+			t.Errorf("input: %q, got %s, want %s", tc.input, got, tc.expected)
+		}
+	}
+}
@@ -1,75 +1,74 @@
 package command
 
 import (
-	"log"
+	"context"
 	"os"
-	"path/filepath"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	humanize "github.com/dustin/go-humanize"
-	"github.com/fujiwara/shapeio"
 	"github.com/pkg/errors"
 
+	"github.com/yuuki/binrep/pkg/archive"
+	"github.com/yuuki/binrep/pkg/binrep"
 	"github.com/yuuki/binrep/pkg/release"
-	"github.com/yuuki/binrep/pkg/storage"
 )
 
 // PullParam represents the option parameter of `pull`.
 type PullParam struct {
-	Timestamp    string
-	MaxBandWidth string
+	Timestamp       string
+	MaxBandWidth    string
+	Extract         bool
+	StripComponents int
+	Include         string
+	// IdentityFile is the file of the private keys to decrypt the encrypted releases.
+	IdentityFile string
 }
 
 // Pull pulls the latest release of the name(<host>/<user>/<project>) to installPath.
-func Pull(param *PullParam, name, installPath string) error {
-	sess := session.New()
-	st := storage.New(sess)
-
-	fi, err := os.Stat(installPath)
-	if err != nil {
-		return errors.Wrapf(err, "failed to open %q", installPath)
+func Pull(ctx context.Context, param *PullParam, name, installPath string) error {
+	opts := &binrep.PullOptions{Timestamp: param.Timestamp}
+	if param.MaxBandWidth != "" {
+		maxBandWidth, err := humanize.ParseBytes(param.MaxBandWidth)
+		if err != nil {
+			return errors.Errorf("failed to parse --max-bandwidth %v", param.MaxBandWidth)
+		}
+		opts.MaxBandWidth = maxBandWidth
+	}
+	if param.Extract {
+		opts.Extract = &archive.Options{
+			StripComponents: param.StripComponents,
+			Include:         param.Include,
+		}
 	}
-	if !fi.IsDir() {
-		return errors.Errorf("%q not directory", installPath)
+	if param.IdentityFile != "" {
+		ids, err := readIdentities(param.IdentityFile)
+		if err != nil {
+			return err
+		}
+		opts.Identities = ids
 	}
 
-	rel, err := st.FindLatestRelease(name)
+	client, err := newClient()
 	if err != nil {
 		return err
 	}
 
-	var maxBandWidth uint64
-	if param.MaxBandWidth != "" {
-		maxBandWidth, err = humanize.ParseBytes(param.MaxBandWidth)
-		if err != nil {
-			return errors.Errorf("failed to parse --max-bandwidth %v", humanize.Bytes(maxBandWidth))
-		}
+	_, err = client.Pull(ctx, name, installPath, opts)
+	if param.IdentityFile == "" && release.IsNoIdentityError(err) {
+		return errors.Errorf("%s is encrypted, use --identity or BINREP_IDENTITY_FILE to decrypt it", name)
 	}
-
-	log.Println("-->", "Downloading", rel.URL, "to", installPath)
-
-	return pullRelease(rel, installPath, maxBandWidth)
+	return err
 }
 
-func pullRelease(rel *release.Release, installPath string, maxBandWidth uint64) error {
-	for _, bin := range rel.Meta.Binaries {
-		path := filepath.Join(installPath, bin.Name)
-		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, bin.Mode)
-		if err != nil {
-			return errors.Wrapf(err, "failed to open %v", path)
-		}
-		lsrc := shapeio.NewReader(bin.Body)
-		if maxBandWidth != 0 {
-			log.Printf("Set max bandwidth total: %s/sec\n", humanize.Bytes(uint64(maxBandWidth)))
-			lsrc.SetRateLimit(float64(maxBandWidth))
-		}
-		_, err = bin.CopyAndValidateChecksum(file, lsrc)
-		if err != nil {
-			if release.IsChecksumError(err) {
-				os.Remove(path)
-			}
-			return err
-		}
+// readIdentities reads the private keys of identityFile.
+func readIdentities(identityFile string) ([]*release.Identity, error) {
+	f, err := os.Open(identityFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open identity file %q", identityFile)
+	}
+	defer f.Close()
+	ids, err := release.ParseIdentities(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse identity file %q", identityFile)
 	}
-	return nil
+	return ids, nil
 }
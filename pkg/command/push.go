@@ -1,82 +1,61 @@
 package command
 
 import (
-	"log"
-	"os"
-	"path/filepath"
-	"strings"
-
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/pkg/errors"
+	"context"
 
+	"github.com/yuuki/binrep/pkg/binrep"
 	"github.com/yuuki/binrep/pkg/release"
 	"github.com/yuuki/binrep/pkg/storage"
 )
 
 // PushParam represents the option parameter of `push`.
 type PushParam struct {
-	Timestamp    string
-	KeepReleases int
-	Force        bool
+	Timestamp           string
+	KeepReleases        int
+	NoPrune             bool
+	ArchiveStorageClass string
+	Force               bool
+	RequireStatic       bool
+	Compress            string
+	// Renames maps the local paths to the binary names within the release.
+	Renames map[string]string
+	// Recipients is the public keys to which the binaries are encrypted.
+	Recipients []string
+	// Tags are the names of the release, which is never pruned if tagged.
+	Tags []string
+	// Channels are the channels which the release is pushed to.
+	Channels []string
+	// Object is the settings of the objects put on S3 such as the
+	// server-side encryption. The objects are put without them if it is nil.
+	Object *storage.ObjectOptions
 }
 
 // Push pushes the binary files of binPaths as release of the name(<host>/<user>/<project>).
-func Push(param *PushParam, name string, binPaths []string) error {
-	// TODO: Validate the same file name
-	bins := make([]*release.Binary, 0, len(binPaths))
-	for _, binPath := range binPaths {
-		file, err := os.Open(binPath)
-		if err != nil {
-			return errors.Wrapf(err, "failed to open %v", binPath)
-		}
-		fi, err := file.Stat()
-		if err != nil {
-			return errors.Wrapf(err, "failed to stat %q", file.Name())
-		}
-		bin, err := release.BuildBinary(filepath.Base(file.Name()), fi.Mode(), file)
-		if err != nil {
-			return err
-		}
-		bins = append(bins, bin)
-	}
-
-	sess := session.New()
-	st := storage.New(sess)
-
-	if !param.Force {
-		ok, err := st.ExistRelease(name)
-		if err != nil {
-			return err
-		}
-		if ok {
-			ok, err := st.HaveSameChecksums(name, bins)
-			if err != nil {
-				return err
-			}
-			if ok {
-				log.Println("Skip pushing the binaries because they have the same checksum with the latest binaries on the remote storage")
-				return nil
-			}
-		}
-	}
-
-	log.Println("-->", "Uploading", binPaths)
-
-	rel, err := st.CreateRelease(name, release.Now(), bins)
-	if err != nil {
+func Push(ctx context.Context, param *PushParam, name string, binPaths []string) error {
+	if err := param.Object.Validate(); err != nil {
 		return err
 	}
-
-	log.Println("Uploaded", "to", rel.URL)
-
-	log.Println("--> Cleaning up the old releases")
-
-	timestamps, err := st.PruneReleases(name, param.KeepReleases)
+	client, err := newClientWithOptions(param.Object)
 	if err != nil {
 		return err
 	}
 
-	log.Println("Cleaned", "up", strings.Join(timestamps, ","))
-
-	return nil
+	opts := &binrep.PushOptions{
+		Timestamp:     param.Timestamp,
+		Force:         param.Force,
+		RequireStatic: param.RequireStatic,
+		Compress:      param.Compress,
+		Renames:       param.Renames,
+		Recipients:    param.Recipients,
+		Tags:          param.Tags,
+		Channels:      param.Channels,
+	}
+	if !param.NoPrune {
+		opts.Prune = &storage.PruneOptions{
+			Policy:              &release.RetentionPolicy{KeepLast: param.KeepReleases},
+			ArchiveStorageClass: param.ArchiveStorageClass,
+		}
+	}
+	_, err = client.Push(ctx, name, binPaths, opts)
+	return err
 }
@@ -0,0 +1,38 @@
+package command
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// RestoreParam represents the option parameter of `restore`.
+type RestoreParam struct {
+}
+
+// Restore restores the archived release of the name(<host>/<user>/<project>)
+// and the timestamp into the live releases.
+func Restore(ctx context.Context, param *RestoreParam, name, timestamp string) error {
+	st, err := storage.Open()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Restoring the release", logging.KeyProject, name, logging.KeyTimestamp, timestamp)
+
+	ok, err := st.RestoreRelease(ctx, name, timestamp)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		slog.Info("The restoration from the archive storage class is in progress. Run restore again later, which takes up to several hours.",
+			logging.KeyProject, name, logging.KeyTimestamp, timestamp)
+		return nil
+	}
+
+	slog.Info("Restored the release", logging.KeyProject, name, logging.KeyTimestamp, timestamp)
+
+	return nil
+}
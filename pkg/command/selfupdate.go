@@ -0,0 +1,65 @@
+package command
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/selfupdate"
+)
+
+// SelfUpdateParam represents the option parameter of `self-update`.
+type SelfUpdateParam struct {
+	Project   string
+	Timestamp string
+	Channel   string
+	DryRun    bool
+	// IdentityFile is the file of the private keys to decrypt the encrypted releases.
+	IdentityFile string
+}
+
+// SelfUpdate updates the running binrep to the binary of the release of param.Project.
+func SelfUpdate(ctx context.Context, param *SelfUpdateParam) error {
+	var ids []*release.Identity
+	if param.IdentityFile != "" {
+		var err error
+		ids, err = readIdentities(param.IdentityFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	result, err := selfupdate.Update(ctx, client, &selfupdate.Options{
+		Name:       param.Project,
+		Timestamp:  param.Timestamp,
+		Channel:    param.Channel,
+		Identities: ids,
+		DryRun:     param.DryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{
+		"executable", result.Executable,
+		logging.KeyProject, result.Release.Name(),
+		logging.KeyTimestamp, result.Release.Timestamp(),
+		logging.KeyBinary, result.Binary.Name,
+	}
+	switch {
+	case result.Updated:
+		slog.Info("Updated the executable", fields...)
+	case result.Checksum == result.Binary.Checksum:
+		slog.Info("The executable is up to date", fields...)
+	default:
+		slog.Info("Would update the executable (dry-run)", fields...)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/server"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+const (
+	// shutdownTimeout is the time to wait for the requests in flight on the
+	// cancellation.
+	shutdownTimeout = 10 * time.Second
+	// readHeaderTimeout, readTimeout and idleTimeout bound the slow or idle
+	// clients holding the connections. The responses are not bounded by
+	// any write timeout, because the large binaries take long to send.
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+)
+
+// ServeParam represents the option parameter of `serve`.
+type ServeParam struct {
+	Listen string
+	// CacheDir is the directory of the cached binaries, which is under the
+	// temporary directory if it is empty.
+	CacheDir  string
+	CacheSize string
+	// IdentityFile is the file of the private keys to decrypt the encrypted releases.
+	IdentityFile string
+}
+
+// Serve serves the repository read-only over HTTP until ctx is canceled.
+func Serve(ctx context.Context, param *ServeParam) error {
+	cacheSize, err := humanize.ParseBytes(param.CacheSize)
+	if err != nil {
+		return errors.Errorf("failed to parse --cache-size %v", param.CacheSize)
+	}
+	cacheDir := param.CacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "binrep-serve")
+	}
+
+	st, err := storage.Open()
+	if err != nil {
+		return err
+	}
+	h := server.New(st, cacheDir)
+	h.CacheSize = int64(cacheSize)
+	h.Logger = slog.Default()
+	if param.IdentityFile != "" {
+		h.Identities, err = readIdentities(param.IdentityFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              param.Listen,
+		Handler:           h,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	slog.Info("Serving the repository", "listen", param.Listen, "cache_dir", cacheDir)
+
+	select {
+	case err := <-errCh:
+		return errors.Wrapf(err, "failed to listen on %s", param.Listen)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "failed to shut down the server")
+	}
+	return nil
+}
@@ -1,13 +1,9 @@
 package command
 
 import (
+	"context"
 	"os"
 	"text/tabwriter"
-
-	"github.com/aws/aws-sdk-go/aws/session"
-
-	"github.com/yuuki/binrep/pkg/release"
-	"github.com/yuuki/binrep/pkg/storage"
 )
 
 // ShowParam represents the option parameter of `show`.
@@ -16,24 +12,15 @@ type ShowParam struct {
 }
 
 // Show shows the latest release of the name(<host>/<user>/<project>).
-func Show(param *ShowParam, name string) error {
-	sess := session.New()
-	st := storage.New(sess)
+func Show(ctx context.Context, param *ShowParam, name string) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
 
-	var (
-		rel *release.Release
-		err error
-	)
-	if param.Timestamp == "" {
-		rel, err = st.FindLatestRelease(name)
-		if err != nil {
-			return err
-		}
-	} else {
-		rel, err = st.FindReleaseByTimestamp(name, param.Timestamp)
-		if err != nil {
-			return err
-		}
+	rel, err := client.Resolve(ctx, name, param.Timestamp)
+	if err != nil {
+		return err
 	}
 
 	// Format in tab-separated columns with a tab stop of 8.
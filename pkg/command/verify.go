@@ -0,0 +1,67 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// VerifyParam represents the option parameter of `verify`.
+type VerifyParam struct {
+	Chain bool
+}
+
+// Verify verifies the hash chain of the releases of the name(<host>/<user>/<project>),
+// and returns BrokenChainError if any link is broken. The forks by the
+// concurrent pushes are only reported.
+func Verify(ctx context.Context, param *VerifyParam, name string) error {
+	st, err := storage.Open()
+	if err != nil {
+		return err
+	}
+
+	links, err := st.ChainLinks(ctx, name)
+	if err != nil {
+		return err
+	}
+	problems := release.VerifyChain(links)
+	byTimestamp := map[string][]string{}
+	broken := map[string]bool{}
+	for _, p := range problems {
+		byTimestamp[p.Timestamp] = append(byTimestamp[p.Timestamp], p.Message)
+		broken[p.Timestamp] = broken[p.Timestamp] || !p.Fork
+	}
+
+	// Format in tab-separated columns with a tab stop of 8.
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "TIMESTAMP\tHASH\tSTATE\tDETAIL")
+	for _, l := range links {
+		state := "ok"
+		switch {
+		case broken[l.Timestamp]:
+			state = "BROKEN"
+		case len(byTimestamp[l.Timestamp]) > 0:
+			state = "forked"
+		case l.Deleted:
+			state = "deleted"
+		case l.Chain == nil:
+			state = "unchained"
+		}
+		hash := l.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", l.Timestamp, hash, state, strings.Join(byTimestamp[l.Timestamp], "; "))
+	}
+	tw.Flush()
+
+	if problems := release.BrokenProblems(problems); len(problems) > 0 {
+		return &release.BrokenChainError{Name: name, Problems: problems}
+	}
+	return nil
+}
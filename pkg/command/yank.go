@@ -0,0 +1,51 @@
+package command
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// YankParam represents the option parameter of `yank`.
+type YankParam struct {
+	Reason string
+}
+
+// Yank marks the release of the name(<host>/<user>/<project>) and the timestamp
+// as yanked, so that it is skipped when resolving the latest release.
+func Yank(ctx context.Context, param *YankParam, name, timestamp string) error {
+	st, err := storage.Open()
+	if err != nil {
+		return err
+	}
+
+	if err := st.YankRelease(ctx, name, timestamp, param.Reason); err != nil {
+		return err
+	}
+
+	slog.Info("Yanked the release", logging.KeyProject, name, logging.KeyTimestamp, timestamp, "reason", param.Reason)
+
+	return nil
+}
+
+// UnyankParam represents the option parameter of `unyank`.
+type UnyankParam struct {
+}
+
+// Unyank restores the yanked release of the name(<host>/<user>/<project>) and the timestamp.
+func Unyank(ctx context.Context, param *UnyankParam, name, timestamp string) error {
+	st, err := storage.Open()
+	if err != nil {
+		return err
+	}
+
+	if err := st.UnyankRelease(ctx, name, timestamp); err != nil {
+		return err
+	}
+
+	slog.Info("Unyanked the release", logging.KeyProject, name, logging.KeyTimestamp, timestamp)
+
+	return nil
+}
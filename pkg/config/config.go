@@ -2,20 +2,154 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // Param represents config parameters.
 type config struct {
-	// BackendEndpoint is an endpoint for backend storage.
+	// BackendEndpoint is an endpoint for backend storage such as
+	// `s3://bucket?endpoint=http://minio:9000&region=us-east-1&path_style=true`.
 	BackendEndpoint string
+	// Region overrides the region of the endpoint and the AWS config.
+	Region string
+	// Profile is the profile of the AWS shared config.
+	Profile string
+	// ArchiveStorageClass is the storage class such as GLACIER with which
+	// the pruned releases are archived instead of being deleted.
+	ArchiveStorageClass string
+	// SSE is the server-side encryption algorithm ('AES256' or 'aws:kms')
+	// of the pushed objects.
+	SSE string
+	// SSEKMSKeyID is the KMS key ID for the 'aws:kms' server-side encryption.
+	SSEKMSKeyID string
+	// StorageClass is the storage class such as STANDARD_IA of the pushed objects.
+	StorageClass string
+	// ObjectTags is the tags of the pushed objects.
+	ObjectTags map[string]string
+	// ObjectMetadata is the user-defined metadata of the pushed objects.
+	ObjectMetadata map[string]string
+	// Recipients is the public keys to which the pushed binaries are encrypted.
+	Recipients []string
+	// IdentityFile is the file of the private keys to decrypt the pulled binaries.
+	IdentityFile string
+	// RetryMaxAttempts is the number of the attempts of the storage operations
+	// failing temporarily. The default is used if it is 0.
+	RetryMaxAttempts int
+	// NoProgress disables the progress of the transfers.
+	NoProgress bool
+	// LogLevel is the minimum level of the logs such as 'debug' and 'warn'.
+	LogLevel string
+	// LogFormat is the format of the logs ('text' or 'json').
+	LogFormat string
+	// SelfUpdateProject is the project of the binrep binaries for `self-update`.
+	SelfUpdateProject string
+	// Command is the command line recorded in the audit records.
+	Command string
+	// Webhooks is the URLs to which the created and pruned releases are posted.
+	Webhooks []string
+	// WebhookSecret is the key of the HMAC signatures of the webhooks.
+	WebhookSecret string
+	// NoNotify disables the webhooks.
+	NoNotify bool
 }
 
 // Config is set from the environment variables.
 var Config = &config{}
 
-// Load loads into Config from environment values.
-func Load() {
+// Load loads into Config from environment values. The values set by the
+// previous Load or flags are reset.
+func Load() error {
+	*Config = config{}
 	if v := os.Getenv("BINREP_BACKEND_ENDPOINT"); v != "" {
 		Config.BackendEndpoint = v
 	}
+	if v := os.Getenv("BINREP_ARCHIVE_STORAGE_CLASS"); v != "" {
+		Config.ArchiveStorageClass = v
+	}
+	if v := os.Getenv("BINREP_SSE"); v != "" {
+		Config.SSE = v
+	}
+	if v := os.Getenv("BINREP_SSE_KMS_KEY_ID"); v != "" {
+		Config.SSEKMSKeyID = v
+	}
+	if v := os.Getenv("BINREP_STORAGE_CLASS"); v != "" {
+		Config.StorageClass = v
+	}
+	if v := os.Getenv("BINREP_OBJECT_TAGS"); v != "" {
+		tags, err := ParseKeyValues(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid BINREP_OBJECT_TAGS")
+		}
+		Config.ObjectTags = tags
+	}
+	if v := os.Getenv("BINREP_OBJECT_METADATA"); v != "" {
+		metadata, err := ParseKeyValues(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid BINREP_OBJECT_METADATA")
+		}
+		Config.ObjectMetadata = metadata
+	}
+	if v := os.Getenv("BINREP_RECIPIENTS"); v != "" {
+		Config.Recipients = strings.Split(v, ",")
+	}
+	if v := os.Getenv("BINREP_IDENTITY_FILE"); v != "" {
+		Config.IdentityFile = v
+	}
+	if v := os.Getenv("BINREP_LOG_LEVEL"); v != "" {
+		Config.LogLevel = v
+	}
+	if v := os.Getenv("BINREP_LOG_FORMAT"); v != "" {
+		Config.LogFormat = v
+	}
+	if v := os.Getenv("BINREP_NO_PROGRESS"); v != "" {
+		Config.NoProgress = true
+	}
+	if v := os.Getenv("BINREP_WEBHOOKS"); v != "" {
+		Config.Webhooks = strings.Split(v, ",")
+	}
+	if v := os.Getenv("BINREP_WEBHOOK_SECRET"); v != "" {
+		Config.WebhookSecret = v
+	}
+	if v := os.Getenv("BINREP_NO_NOTIFY"); v != "" {
+		Config.NoNotify = true
+	}
+	if v := os.Getenv("BINREP_SELF_UPDATE_PROJECT"); v != "" {
+		Config.SelfUpdateProject = v
+	}
+	if v := os.Getenv("BINREP_RETRY_MAX_ATTEMPTS"); v != "" {
+		n, err := ParseMaxAttempts(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid BINREP_RETRY_MAX_ATTEMPTS")
+		}
+		Config.RetryMaxAttempts = n
+	}
+	return nil
+}
+
+// ParseMaxAttempts parses the positive number of the attempts.
+func ParseMaxAttempts(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, errors.Errorf("%q is not a positive number", s)
+	}
+	return n, nil
+}
+
+// ParseKeyValues parses the comma-separated pairs such as 'k1=v1,k2=v2'.
+func ParseKeyValues(s string) (map[string]string, error) {
+	kvs := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("%q is not key=value", pair)
+		}
+		kvs[kv[0]] = kv[1]
+	}
+	return kvs, nil
 }
@@ -0,0 +1,83 @@
+// Package logging creates the structured loggers of binrep, which write the
+// records in the text (logfmt) or JSON format.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The keys of the fields shared by the records.
+const (
+	// KeyProject is the name(<host>/<user>/<project>) of the project.
+	KeyProject = "project"
+	// KeyTimestamp is the timestamp of the release.
+	KeyTimestamp = "timestamp"
+	// KeyBinary is the name of the binary within the release.
+	KeyBinary = "binary"
+	// KeyBytes is the number of the bytes transferred.
+	KeyBytes = "bytes"
+	// KeyDuration is the elapsed time of the operation.
+	KeyDuration = "duration"
+)
+
+// The formats of the records.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses the level such as 'debug', 'info', 'warn' and 'error'.
+// The empty level is 'info'.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, errors.Errorf("invalid log level %q, want 'debug', 'info', 'warn' or 'error'", s)
+	}
+	return level, nil
+}
+
+// ValidateFormat validates the format, which is 'text' or 'json'. The empty
+// format is 'text'.
+func ValidateFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return errors.Errorf("invalid log format %q, want 'text' or 'json'", format)
+}
+
+// New creates the logger writing the records of the level or higher into w
+// in the format. The records in the text format have no time like the
+// messages of the command line tools.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lv, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	if strings.ToLower(format) == FormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), nil
+}
+
+// Discard returns the logger discarding all records.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		level    string
+		format   string
+		expected string
+		isErr    bool
+	}{
+		{level: "", format: "", expected: "level=INFO msg=Uploaded project=github.com/yuuki/droot bytes=1024 duration=1.5s\n"},
+		{level: "info", format: "text", expected: "level=INFO msg=Uploaded project=github.com/yuuki/droot bytes=1024 duration=1.5s\n"},
+		{level: "debug", format: "TEXT", expected: "level=DEBUG msg=Resolved project=github.com/yuuki/droot\nlevel=INFO msg=Uploaded project=github.com/yuuki/droot bytes=1024 duration=1.5s\n"},
+		{level: "error", format: "text", expected: ""},
+		{level: "verbose", format: "text", isErr: true},
+		{level: "info", format: "yaml", isErr: true},
+	}
+	for _, tc := range tests {
+		out := new(bytes.Buffer)
+		logger, err := New(out, tc.level, tc.format)
+		if tc.isErr {
+			if err == nil {
+				t.Errorf("level: %q, format: %q, should raise error", tc.level, tc.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("level: %q, format: %q, should not raise error: %s", tc.level, tc.format, err)
+		}
+
+		logger.Debug("Resolved", KeyProject, "github.com/yuuki/droot")
+		logger.Info("Uploaded", KeyProject, "github.com/yuuki/droot", KeyBytes, 1024, KeyDuration, 1500*time.Millisecond)
+
+		if out.String() != tc.expected {
+			t.Errorf("level: %q, format: %q, got %q, want %q", tc.level, tc.format, out.String(), tc.expected)
+		}
+	}
+}
+
+func TestNew_json(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger, err := New(out, "info", "json")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	logger.Info("Uploaded", KeyProject, "github.com/yuuki/droot", KeyTimestamp, "20171017152508", KeyBytes, 1024)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	for k, v := range map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "Uploaded",
+		KeyProject:   "github.com/yuuki/droot",
+		KeyTimestamp: "20171017152508",
+		KeyBytes:     float64(1024),
+	} {
+		if record[k] != v {
+			t.Errorf("key: %s, got %v, want %v", k, record[k], v)
+		}
+	}
+	if _, ok := record["time"]; !ok || !strings.HasSuffix(out.String(), "\n") {
+		t.Errorf("should be the line with the time, got %q", out.String())
+	}
+}
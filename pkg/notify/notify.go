@@ -0,0 +1,271 @@
+// Package notify posts the events of the repository, such as the created
+// releases and the pruned releases, to the webhooks, so that the other
+// systems such as the deploy tools and the chat react to them.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+const (
+	// HeaderSignature is the header of the HMAC-SHA256 of the body with the
+	// secret such as `sha256=<hex>`, which is sent if the secret is set.
+	HeaderSignature = "X-Binrep-Signature"
+	// HeaderEvent is the header of the action of the event.
+	HeaderEvent = "X-Binrep-Event"
+	// HeaderDelivery is the header of the unique ID of the delivery, which
+	// is the same between the retries.
+	HeaderDelivery = "X-Binrep-Delivery"
+
+	// DefaultTimeout is the default timeout of each request.
+	DefaultTimeout = 10 * time.Second
+)
+
+// The actions of the events.
+const (
+	ActionCreate = "create"
+	ActionPrune  = "prune"
+)
+
+// Event represents the payload of the webhooks.
+type Event struct {
+	// Action is one of the Action* actions.
+	Action  string    `json:"action"`
+	Time    time.Time `json:"time"`
+	Project string    `json:"project"`
+	// Timestamp and URL are of the created release.
+	Timestamp string    `json:"timestamp,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Binaries  []*Binary `json:"binaries,omitempty"`
+	// Pruned is the releases deleted or archived by the prune.
+	Pruned []*Pruned `json:"pruned,omitempty"`
+}
+
+// Binary represents the binary of the created release.
+type Binary struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	// Link is the name of the binary which the link refers to.
+	Link string `json:"link,omitempty"`
+}
+
+// Pruned represents the pruned release.
+type Pruned struct {
+	Timestamp string `json:"timestamp"`
+	Reason    string `json:"reason"`
+	// StorageClass is the storage class of the archived release.
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+// Sign returns the value of HeaderSignature of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns whether the signature of HeaderSignature is of the body
+// with the secret, for the receivers of the webhooks.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Notifier posts the events to the webhooks.
+type Notifier struct {
+	URLs []string
+	// Secret is the key of HeaderSignature. The requests are not signed if
+	// it is empty.
+	Secret string
+	// Client is the client with DefaultTimeout if it is nil.
+	Client *http.Client
+	// Retry is the policy of the requests failing with the network errors,
+	// the 429 and the 5xx responses.
+	Retry *storage.RetryPolicy
+	// Logger receives the failures of WithNotify. It is nil to log them to
+	// the default logger.
+	Logger *slog.Logger
+}
+
+// New creates the notifier of the webhooks of the URLs signed with secret,
+// retrying the requests up to maxAttempts times, which is
+// storage.DefaultMaxAttempts if it is 0.
+func New(urls []string, secret string, maxAttempts int) (*Notifier, error) {
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Host == "" || parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, errors.Errorf("invalid webhook URL %q, want http:// or https://", redact(u))
+		}
+	}
+	return &Notifier{URLs: urls, Secret: secret, Retry: storage.NewRetryPolicy(maxAttempts)}, nil
+}
+
+func (n *Notifier) client() *http.Client {
+	if n.Client == nil {
+		return &http.Client{Timeout: DefaultTimeout}
+	}
+	return n.Client
+}
+
+func (n *Notifier) logger() *slog.Logger {
+	if n.Logger == nil {
+		return slog.Default()
+	}
+	return n.Logger
+}
+
+// Notify posts the event to all webhooks, and returns the error of the
+// first webhook failing after the retries.
+func (n *Notifier) Notify(ctx context.Context, ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the event")
+	}
+	id, err := deliveryID()
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, u := range n.URLs {
+		err := n.Retry.Do(ctx, "webhook "+redact(u), func(int) error {
+			return n.post(ctx, u, ev.Action, id, body)
+		})
+		if err != nil && first == nil {
+			first = errors.Wrapf(err, "failed to notify the webhook %s", redact(u))
+		}
+	}
+	return first
+}
+
+func (n *Notifier) post(ctx context.Context, u, action, id string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "binrep")
+	req.Header.Set(HeaderEvent, action)
+	req.Header.Set(HeaderDelivery, id)
+	if n.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(n.Secret, body))
+	}
+	resp, err := n.client().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &storage.TransientError{Err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = errors.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return &storage.TransientError{Err: err}
+	}
+	return err
+}
+
+// deliveryID returns the random ID of the delivery.
+func deliveryID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate the delivery ID")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// redact returns the URL without the path and the query, which often
+// contain the token such as the incoming webhooks of the chat.
+func redact(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return "<invalid URL>"
+	}
+	return fmt.Sprintf("%s://%s/...", parsed.Scheme, parsed.Host)
+}
+
+type notifyStorage struct {
+	storage.API
+	n *Notifier
+}
+
+// SetLogger sets the logger of the wrapped storage.
+func (s *notifyStorage) SetLogger(logger *slog.Logger) {
+	storage.SetLogger(s.API, logger)
+}
+
+// WithNotify returns the storage notifying the releases created and pruned
+// successfully by st. The failure of the notification is logged without
+// failing the operation, which is already done.
+func WithNotify(st storage.API, n *Notifier) storage.API {
+	return &notifyStorage{API: st, n: n}
+}
+
+func (s *notifyStorage) notify(ctx context.Context, ev *Event) {
+	ev.Time = time.Now()
+	if err := s.n.Notify(ctx, ev); err != nil {
+		s.n.logger().Warn("Failed to notify the event", "action", ev.Action,
+			logging.KeyProject, ev.Project, logging.KeyTimestamp, ev.Timestamp, "error", err)
+	}
+}
+
+// CreateRelease creates the release, and notifies it with the binaries.
+func (s *notifyStorage) CreateRelease(ctx context.Context, name string, timestamp string, meta *release.Meta) (*release.Release, error) {
+	rel, err := s.API.CreateRelease(ctx, name, timestamp, meta)
+	if err != nil {
+		return nil, err
+	}
+	bins := make([]*Binary, 0, len(meta.Binaries))
+	for _, bin := range meta.Binaries {
+		bins = append(bins, &Binary{Name: bin.Name, Checksum: bin.Checksum, Size: bin.Size, Link: bin.Link})
+	}
+	s.notify(ctx, &Event{
+		Action:    ActionCreate,
+		Project:   name,
+		Timestamp: timestamp,
+		URL:       rel.URL.String(),
+		Binaries:  bins,
+	})
+	return rel, nil
+}
+
+// PruneReleases prunes the releases, and notifies the pruned releases if any.
+func (s *notifyStorage) PruneReleases(ctx context.Context, name string, opts *storage.PruneOptions) ([]*release.Retention, error) {
+	rets, err := s.API.PruneReleases(ctx, name, opts)
+	if err != nil || opts.DryRun {
+		return rets, err
+	}
+	var pruned []*Pruned
+	for _, ret := range rets {
+		if !ret.Keep {
+			pruned = append(pruned, &Pruned{Timestamp: ret.Timestamp, Reason: ret.Reason, StorageClass: opts.ArchiveStorageClass})
+		}
+	}
+	if len(pruned) > 0 {
+		s.notify(ctx, &Event{Action: ActionPrune, Project: name, Pruned: pruned})
+	}
+	return rets, nil
+}
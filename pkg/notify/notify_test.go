@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+type request struct {
+	event     string
+	delivery  string
+	signature string
+	body      []byte
+}
+
+// receiver records the requests, and responds with the statuses in order
+// and then 200.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*request
+	statuses []int
+}
+
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, &request{
+			event:     req.Header.Get(HeaderEvent),
+			delivery:  req.Header.Get(HeaderDelivery),
+			signature: req.Header.Get(HeaderSignature),
+			body:      body,
+		})
+		if len(r.statuses) > 0 {
+			w.WriteHeader(r.statuses[0])
+			r.statuses = r.statuses[1:]
+		}
+	}))
+	return r
+}
+
+func newTestNotifier(urls ...string) *Notifier {
+	return &Notifier{
+		URLs:   urls,
+		Secret: "secret",
+		Retry:  &storage.RetryPolicy{MaxAttempts: 3},
+		Logger: logging.Discard(),
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"action":"create"}`)
+	sig := Sign("secret", body)
+
+	if !strings.HasPrefix(sig, "sha256=") || len(sig) != len("sha256=")+64 {
+		t.Errorf("got %q, want sha256=<hex>", sig)
+	}
+	if !Verify("secret", body, sig) {
+		t.Errorf("should verify the signature %q", sig)
+	}
+	if Verify("another", body, sig) {
+		t.Errorf("should not verify the signature %q with another secret", sig)
+	}
+	if Verify("secret", []byte(`{"action":"prune"}`), sig) {
+		t.Errorf("should not verify the signature %q of another body", sig)
+	}
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	tests := []struct {
+		desc          string
+		statuses      []int
+		expectedCount int
+		isErr         bool
+	}{
+		{desc: "ok", expectedCount: 1},
+		{desc: "retried", statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}, expectedCount: 3},
+		{desc: "attempts run out", statuses: []int{502, 503, 504}, expectedCount: 3, isErr: true},
+		{desc: "not retried", statuses: []int{http.StatusBadRequest}, expectedCount: 1, isErr: true},
+	}
+	for _, tc := range tests {
+		r := newReceiver(tc.statuses...)
+		n := newTestNotifier(r.URL + "/hooks/token")
+
+		err := n.Notify(context.Background(), &Event{Action: ActionCreate, Project: "github.com/yuuki/droot", Timestamp: "20171017152508"})
+
+		r.Close()
+		if tc.isErr {
+			if err == nil {
+				t.Errorf("desc: %s, should raise error", tc.desc)
+			} else if strings.Contains(err.Error(), "token") {
+				t.Errorf("desc: %s, the error should not contain the path of the URL: %s", tc.desc, err)
+			}
+		} else if err != nil {
+			t.Errorf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		if len(r.requests) != tc.expectedCount {
+			t.Fatalf("desc: %s, got %d requests, want %d", tc.desc, len(r.requests), tc.expectedCount)
+		}
+		for _, req := range r.requests {
+			if req.event != ActionCreate {
+				t.Errorf("desc: %s, got event %q, want %q", tc.desc, req.event, ActionCreate)
+			}
+			if req.delivery == "" || req.delivery != r.requests[0].delivery {
+				t.Errorf("desc: %s, the retries should have the same delivery %q, got %q", tc.desc, r.requests[0].delivery, req.delivery)
+			}
+			if !Verify("secret", req.body, req.signature) {
+				t.Errorf("desc: %s, invalid signature %q", tc.desc, req.signature)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		url   string
+		isErr bool
+	}{
+		{url: "https://hooks.example.com/binrep"},
+		{url: "http://localhost:8080/"},
+		{url: "ftp://hooks.example.com/binrep", isErr: true},
+		{url: "hooks.example.com/binrep", isErr: true},
+	}
+	for _, tc := range tests {
+		_, err := New([]string{tc.url}, "", 0)
+		if tc.isErr && err == nil {
+			t.Errorf("url: %s, should raise error", tc.url)
+		}
+		if !tc.isErr && err != nil {
+			t.Errorf("url: %s, should not raise error: %s", tc.url, err)
+		}
+	}
+}
+
+func TestNew_maxAttempts(t *testing.T) {
+	tests := []struct {
+		maxAttempts   int
+		expectedCount int
+	}{
+		{maxAttempts: 1, expectedCount: 1},
+		{maxAttempts: 2, expectedCount: 2},
+		{maxAttempts: 0, expectedCount: storage.DefaultMaxAttempts},
+	}
+	for _, tc := range tests {
+		r := newReceiver(502, 502, 502, 502, 502, 502, 502, 502, 502, 502)
+		n, err := New([]string{r.URL}, "", tc.maxAttempts)
+		if err != nil {
+			t.Fatalf("maxAttempts: %d, should not raise error: %s", tc.maxAttempts, err)
+		}
+		n.Retry.BaseDelay, n.Logger = time.Millisecond, logging.Discard()
+
+		err = n.Notify(context.Background(), &Event{Action: ActionCreate, Project: "github.com/yuuki/droot", Timestamp: "20171017152508"})
+
+		r.Close()
+		if err == nil {
+			t.Errorf("maxAttempts: %d, should raise error", tc.maxAttempts)
+		}
+		if len(r.requests) != tc.expectedCount {
+			t.Errorf("maxAttempts: %d, got %d requests, want %d", tc.maxAttempts, len(r.requests), tc.expectedCount)
+		}
+	}
+}
+
+func TestWithNotify(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+	st := WithNotify(storage.NewMemory(), newTestNotifier(r.URL))
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+
+	for _, ts := range []string{"20171017152508", "20171017152626"} {
+		bin, err := release.BuildBinary("droot", 0755, strings.NewReader("droot-"+ts))
+		if err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+		if _, err := st.CreateRelease(ctx, name, ts, release.NewMeta([]*release.Binary{bin})); err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+	}
+	policy := &release.RetentionPolicy{KeepLast: 1}
+	if _, err := st.PruneReleases(ctx, name, &storage.PruneOptions{Policy: policy, DryRun: true}); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if _, err := st.PruneReleases(ctx, name, &storage.PruneOptions{Policy: policy}); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	// Nothing is pruned.
+	if _, err := st.PruneReleases(ctx, name, &storage.PruneOptions{Policy: policy}); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	var got []string
+	for _, req := range r.requests {
+		var ev Event
+		if err := json.Unmarshal(req.body, &ev); err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+		if ev.Time.IsZero() {
+			t.Errorf("the time of %s should be set", ev.Action)
+		}
+		e := fmt.Sprintf("%s %s %s %s", ev.Action, ev.Project, ev.Timestamp, ev.URL)
+		for _, bin := range ev.Binaries {
+			e += fmt.Sprintf(" %s:%d", bin.Name, bin.Size)
+		}
+		for _, p := range ev.Pruned {
+			e += " " + p.Timestamp
+		}
+		got = append(got, e)
+	}
+	expected := []string{
+		"create github.com/yuuki/droot 20171017152508 mem://memory/github.com/yuuki/droot/20171017152508 droot:20",
+		"create github.com/yuuki/droot 20171017152626 mem://memory/github.com/yuuki/droot/20171017152626 droot:20",
+		"prune github.com/yuuki/droot   20171017152508",
+	}
+	if diff := pretty.Compare(got, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
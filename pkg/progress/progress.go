@@ -0,0 +1,287 @@
+// Package progress renders the progress of the transfers of the binaries
+// reported to binrep.Progress, as the bars on the terminal or as the periodic
+// lines in the logs.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/yuuki/binrep/pkg/binrep"
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+const (
+	// DefaultInterval is the default interval of the lines of each binary.
+	DefaultInterval = 10 * time.Second
+	// redrawInterval is the interval to redraw the bars on the terminal.
+	redrawInterval = 100 * time.Millisecond
+	barWidth       = 30
+)
+
+// Reporter renders the progress of the binaries into the writer. It draws
+// the bars of the binaries on the terminal, and prints the line of each
+// binary every Interval otherwise.
+type Reporter struct {
+	// TTY is whether the writer is the terminal or not.
+	TTY bool
+	// Interval is the interval of the lines, which is DefaultInterval if it
+	// is 0. It is ignored on the terminal.
+	Interval time.Duration
+	// Logger receives the lines as the records with the fields of package
+	// logging instead of the writer if it is not nil.
+	Logger *slog.Logger
+
+	w   io.Writer
+	now func() time.Time
+
+	mu        sync.Mutex
+	transfers []*transfer
+	drawn     int // the number of the lines of the bars drawn last
+	lastDrawn time.Time
+}
+
+var _ binrep.Progress = (*Reporter)(nil)
+
+type transfer struct {
+	name        string
+	size        int64
+	transferred int64
+	started     time.Time
+	printed     time.Time
+	finished    time.Time
+	err         error
+	done        bool
+}
+
+// New creates the reporter into w, drawing the bars if w is the terminal.
+func New(w io.Writer) *Reporter {
+	return &Reporter{TTY: IsTerminal(w), w: w, now: time.Now}
+}
+
+// NewLogger creates the reporter logging the lines into l.
+func NewLogger(l *slog.Logger) *Reporter {
+	return &Reporter{Logger: l, now: time.Now}
+}
+
+// IsTerminal returns whether w is the terminal or not.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Start starts rendering the transfer of the binary.
+func (r *Reporter) Start(bin *release.Binary, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.transfers = append(r.transfers, &transfer{name: bin.Name, size: size, started: now, printed: now})
+	if r.tty() {
+		r.draw(now)
+	}
+}
+
+// Update renders the bytes transferred so far.
+func (r *Reporter) Update(bin *release.Binary, transferred int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.find(bin.Name)
+	if t == nil {
+		return
+	}
+	t.transferred = transferred
+	now := r.now()
+	if r.tty() {
+		if now.Sub(r.lastDrawn) >= redrawInterval {
+			r.draw(now)
+		}
+		return
+	}
+	if now.Sub(t.printed) >= r.interval() {
+		t.printed = now
+		r.print(t, now)
+	}
+}
+
+// Finish renders the result of the transfer.
+func (r *Reporter) Finish(bin *release.Binary, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.find(bin.Name)
+	if t == nil {
+		return
+	}
+	now := r.now()
+	t.done, t.err, t.finished = true, err, now
+	if r.tty() {
+		r.draw(now)
+	} else {
+		r.print(t, now)
+	}
+	// Forget the transfers once all of them are finished, so that the bars
+	// of the next transfers are drawn below them.
+	for _, t := range r.transfers {
+		if !t.done {
+			return
+		}
+	}
+	r.transfers, r.drawn = nil, 0
+}
+
+func (r *Reporter) tty() bool {
+	return r.TTY && r.Logger == nil
+}
+
+// print prints the line of the transfer, or logs it into the logger.
+func (r *Reporter) print(t *transfer, now time.Time) {
+	if r.Logger == nil {
+		fmt.Fprintln(r.w, t.line(now))
+		return
+	}
+	switch {
+	case !t.done:
+		elapsed := now.Sub(t.started)
+		r.Logger.Info("Transferring the binary", logging.KeyBinary, t.name, logging.KeyBytes, t.transferred,
+			"size", t.size, logging.KeyDuration, elapsed, "bytes_per_sec", rate(t.transferred, elapsed))
+	case t.err != nil:
+		r.Logger.Error("Failed to transfer the binary", logging.KeyBinary, t.name, logging.KeyBytes, t.transferred,
+			logging.KeyDuration, t.finished.Sub(t.started), "error", t.err)
+	default:
+		elapsed := t.finished.Sub(t.started)
+		r.Logger.Info("Transferred the binary", logging.KeyBinary, t.name, logging.KeyBytes, t.transferred,
+			logging.KeyDuration, elapsed, "bytes_per_sec", rate(t.transferred, elapsed))
+	}
+}
+
+func (r *Reporter) interval() time.Duration {
+	if r.Interval > 0 {
+		return r.Interval
+	}
+	return DefaultInterval
+}
+
+// find finds the last transfer of the binary, which is not finished.
+func (r *Reporter) find(name string) *transfer {
+	for i := len(r.transfers) - 1; i >= 0; i-- {
+		if t := r.transfers[i]; t.name == name && !t.done {
+			return t
+		}
+	}
+	return nil
+}
+
+// draw redraws the bars over the bars drawn last.
+func (r *Reporter) draw(now time.Time) {
+	var b strings.Builder
+	if r.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", r.drawn)
+	}
+	for _, t := range r.transfers {
+		fmt.Fprintf(&b, "\r\x1b[K%s\n", t.bar(now))
+	}
+	io.WriteString(r.w, b.String())
+	r.drawn = len(r.transfers)
+	r.lastDrawn = now
+}
+
+// bar formats the transfer as the bar such as
+// `droot [=======>      ]  45% 12 MB / 27 MB  3.2 MB/s  ETA 5s`.
+func (t *transfer) bar(now time.Time) string {
+	filled := barWidth
+	if t.size > 0 && t.transferred < t.size {
+		filled = int(int64(barWidth) * t.transferred / t.size)
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return fmt.Sprintf("%s [%s] %s", t.name, bar, t.status(now))
+}
+
+// line formats the transfer as the line such as
+// `droot: 45% 12 MB / 27 MB  3.2 MB/s  ETA 5s`.
+func (t *transfer) line(now time.Time) string {
+	return fmt.Sprintf("%s: %s", t.name, t.status(now))
+}
+
+func (t *transfer) status(now time.Time) string {
+	if t.done {
+		elapsed := t.finished.Sub(t.started)
+		if t.err != nil {
+			return fmt.Sprintf("failed after %s in %s: %s", humanize.Bytes(uint64(t.transferred)), roundDuration(elapsed), t.err)
+		}
+		return fmt.Sprintf("done %s in %s  %s/s", humanize.Bytes(uint64(t.transferred)), roundDuration(elapsed), humanize.Bytes(rate(t.transferred, elapsed)))
+	}
+	elapsed := now.Sub(t.started)
+	bps := rate(t.transferred, elapsed)
+	if t.size <= 0 {
+		return fmt.Sprintf("%s  %s/s", humanize.Bytes(uint64(t.transferred)), humanize.Bytes(bps))
+	}
+	eta := "-"
+	if bps > 0 && t.transferred <= t.size {
+		eta = roundDuration(time.Duration(float64(t.size-t.transferred) / float64(bps) * float64(time.Second))).String()
+	}
+	return fmt.Sprintf("%3d%% %s / %s  %s/s  ETA %s", 100*t.transferred/t.size,
+		humanize.Bytes(uint64(t.transferred)), humanize.Bytes(uint64(t.size)), humanize.Bytes(bps), eta)
+}
+
+// rate returns the bytes per second.
+func rate(n int64, elapsed time.Duration) uint64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return uint64(float64(n) / elapsed.Seconds())
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// Funcs adapts the functions to binrep.Progress for the library users to
+// hook the progress. The nil functions are not called.
+type Funcs struct {
+	OnStart  func(bin *release.Binary, size int64)
+	OnUpdate func(bin *release.Binary, transferred int64)
+	OnFinish func(bin *release.Binary, err error)
+}
+
+var _ binrep.Progress = Funcs{}
+
+// Start calls OnStart.
+func (f Funcs) Start(bin *release.Binary, size int64) {
+	if f.OnStart != nil {
+		f.OnStart(bin, size)
+	}
+}
+
+// Update calls OnUpdate.
+func (f Funcs) Update(bin *release.Binary, transferred int64) {
+	if f.OnUpdate != nil {
+		f.OnUpdate(bin, transferred)
+	}
+}
+
+// Finish calls OnFinish.
+func (f Funcs) Finish(bin *release.Binary, err error) {
+	if f.OnFinish != nil {
+		f.OnFinish(bin, err)
+	}
+}
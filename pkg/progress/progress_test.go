@@ -0,0 +1,116 @@
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+// fakeClock advances by the step on each call.
+func fakeClock(step time.Duration) func() time.Time {
+	now := time.Date(2017, 10, 17, 15, 25, 8, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestReporter_lines(t *testing.T) {
+	out := new(bytes.Buffer)
+	r := &Reporter{Interval: 10 * time.Second, w: out, now: fakeClock(4 * time.Second)}
+	droot, grabeni := &release.Binary{Name: "droot"}, &release.Binary{Name: "grabeni"}
+
+	r.Start(droot, 8*1000*1000)          // 4s
+	r.Update(droot, 2*1000*1000)         // 8s
+	r.Update(droot, 4*1000*1000)         // 12s
+	r.Update(droot, 5*1000*1000)         // 16s: printed
+	r.Finish(droot, nil)                 // 20s
+	r.Start(grabeni, 0)                  // 24s
+	r.Finish(grabeni, errors.New("EOF")) // 28s
+
+	expected := []string{
+		"droot:  62% 5.0 MB / 8.0 MB  417 kB/s  ETA 7s",
+		"droot: done 5.0 MB in 16s  312 kB/s",
+		"grabeni: failed after 0 B in 4s: EOF",
+	}
+	if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestReporter_tty(t *testing.T) {
+	out := new(bytes.Buffer)
+	r := &Reporter{TTY: true, w: out, now: fakeClock(time.Second)}
+	droot, grabeni := &release.Binary{Name: "droot"}, &release.Binary{Name: "grabeni"}
+
+	r.Start(droot, 1000)
+	r.Start(grabeni, 1000)
+	out.Reset()
+	r.Update(droot, 500)
+
+	expected := "\x1b[2A" +
+		"\r\x1b[Kdroot [===============>              ]  50% 500 B / 1.0 kB  250 B/s  ETA 2s\n" +
+		"\r\x1b[Kgrabeni [>                             ]   0% 0 B / 1.0 kB  0 B/s  ETA -\n"
+	if out.String() != expected {
+		t.Errorf("got %q, want %q", out.String(), expected)
+	}
+
+	r.Finish(droot, nil)
+	r.Finish(grabeni, nil)
+	out.Reset()
+	r.Start(droot, 1000)
+
+	// The bars of the finished transfers are left as they are.
+	if strings.HasPrefix(out.String(), "\x1b[") && !strings.HasPrefix(out.String(), "\x1b[K") {
+		t.Errorf("should not redraw the finished bars, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "droot [>") {
+		t.Errorf("should draw the new bar, got %q", out.String())
+	}
+}
+
+func TestReporter_logger(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	r := &Reporter{TTY: true, Logger: logger, Interval: 10 * time.Second, now: fakeClock(10 * time.Second)}
+	droot := &release.Binary{Name: "droot"}
+
+	r.Start(droot, 2000)
+	r.Update(droot, 1000)
+	r.Finish(droot, nil)
+
+	expected := "level=INFO msg=\"Transferring the binary\" binary=droot bytes=1000 size=2000 duration=10s bytes_per_sec=100\n" +
+		"level=INFO msg=\"Transferred the binary\" binary=droot bytes=1000 duration=20s bytes_per_sec=50\n"
+	if out.String() != expected {
+		t.Errorf("got %q, want %q", out.String(), expected)
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	var got []int64
+	f := Funcs{OnUpdate: func(bin *release.Binary, transferred int64) {
+		got = append(got, transferred)
+	}}
+	bin := &release.Binary{Name: "droot"}
+
+	f.Start(bin, 10)
+	f.Update(bin, 5)
+	f.Update(bin, 10)
+	f.Finish(bin, nil)
+
+	if len(got) != 2 || got[0] != 5 || got[1] != 10 {
+		t.Errorf("got %v, want [5 10]", got)
+	}
+}
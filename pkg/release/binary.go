@@ -4,8 +4,9 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
+	"path"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -14,40 +15,108 @@ const (
 	shortCheckSumLen int = 7
 )
 
-// Binary represents the binary file within release.
+// Binary represents the binary file within release. Name is the slash-separated
+// path relative to the release, such as `droot` or `css/app.css`.
 type Binary struct {
 	Name     string      `yaml:"name"`
 	Checksum string      `yaml:"checksum"`
 	Mode     os.FileMode `yaml:"mode"`
-	Body     io.Reader   `yaml:"-"`
+	Link     string      `yaml:"link,omitempty"`
+	Size     int64       `yaml:"size,omitempty"`
+	ELF      *ELF        `yaml:"elf,omitempty"`
+
+	// Compression is the algorithm with which the body is stored compressed.
+	Compression        string `yaml:"compression,omitempty"`
+	CompressedChecksum string `yaml:"compressed_checksum,omitempty"`
+	CompressedSize     int64  `yaml:"compressed_size,omitempty"`
+	// Encrypted is whether the stored body is encrypted with the data key
+	// of the release or not. The checksums are of the plaintext.
+	Encrypted bool `yaml:"encrypted,omitempty"`
+
+	Body io.Reader `yaml:"-"`
 }
 
 // BuildBinary builds a Binary object. Return error if it is failed
-// to calculate checksum of the body.
+// to calculate checksum of the body. The body is rewound after calculating
+// checksum if it is an io.Seeker.
 func BuildBinary(name string, mode os.FileMode, body io.Reader) (*Binary, error) {
-	sum, err := checksum(body)
+	sum, size, err := checksum(body)
 	if err != nil {
 		return nil, err
 	}
+	if err := rewind(body); err != nil {
+		return nil, errors.Wrapf(err, "failed to rewind %s", name)
+	}
 	return &Binary{
 		Name:     name,
 		Checksum: sum,
 		Mode:     mode,
+		Size:     size,
 		Body:     body,
 	}, nil
 }
 
-func checksum(r io.Reader) (string, error) {
+// rewind seeks r to the start if r is an io.Seeker.
+func rewind(r io.Reader) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(0, io.SeekStart)
+		return err
+	}
+	return nil
+}
+
+// BuildLink builds a Binary object that represents the symbolic link to target.
+// The checksum is calculated from target.
+func BuildLink(name, target string) *Binary {
+	return &Binary{
+		Name:     name,
+		Checksum: fmt.Sprintf("%x", sha256.Sum256([]byte(target))),
+		Mode:     os.ModeSymlink | 0777,
+		Link:     target,
+	}
+}
+
+// IsLink returns whether the binary is a symbolic link or not.
+func (b *Binary) IsLink() bool {
+	return b.Link != ""
+}
+
+// Validate validates that the name and the link target don't point outside
+// of the release.
+func (b *Binary) Validate() error {
+	if path.Clean(b.Name) == "." || path.IsAbs(b.Name) || strings.Contains(b.Name, "\\") {
+		return errors.Errorf("invalid binary name %q: must be a relative path within the release", b.Name)
+	}
+	for _, elem := range strings.Split(b.Name, "/") {
+		if elem == ".." {
+			return errors.Errorf("invalid binary name %q: must not contain '..'", b.Name)
+		}
+	}
+	if b.IsLink() {
+		target := path.Join(path.Dir(b.Name), b.Link)
+		if path.IsAbs(b.Link) || target == ".." || strings.HasPrefix(target, "../") {
+			return errors.Errorf("invalid link %q -> %q: must point within the release", b.Name, b.Link)
+		}
+	}
+	return nil
+}
+
+func checksum(r io.Reader) (string, int64, error) {
 	if r == nil {
-		return "", errors.New("try to read nil")
+		return "", 0, errors.New("try to read nil")
 	}
-	body, err := ioutil.ReadAll(r)
+	h := sha256.New()
+	n, err := io.Copy(h, r)
 	if err != nil {
-		return "", errors.New("failed to read data for checksum")
+		return "", 0, errors.New("failed to read data for checksum")
 	}
-	return fmt.Sprintf("%x", sha256.Sum256(body)), nil
+	return fmt.Sprintf("%x", h.Sum(nil)), n, nil
 }
 
+// ErrChecksumMismatch means that the checksum of the binary doesn't match its
+// metadata. It is matched with InvalidChecksumError by errors.Is.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 // InvalidChecksumError represents an error of the checksum.
 type InvalidChecksumError struct {
 	got  string
@@ -59,10 +128,16 @@ func (e *InvalidChecksumError) Error() string {
 	return fmt.Sprintf("got: %s, want: %s", e.got, e.want)
 }
 
-// IsChecksumError returns that the type of err matches InvalidChecksumError type or not.
+// Is returns whether target is ErrChecksumMismatch.
+func (e *InvalidChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// IsChecksumError returns whether err or any error wrapped by it is
+// InvalidChecksumError.
 func IsChecksumError(err error) bool {
-	_, ok := errors.Cause(err).(*InvalidChecksumError)
-	return ok
+	var cerr *InvalidChecksumError
+	return errors.As(err, &cerr)
 }
 
 // CopyAndValidateChecksum copies src to dst and calculate checksum of src, then check it.
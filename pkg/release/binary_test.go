@@ -74,3 +74,48 @@ func TestBinaryInspect(t *testing.T) {
 		t.Errorf("got: %v, want: %v", w.String(), expected)
 	}
 }
+
+func TestBuildLink(t *testing.T) {
+	b := BuildLink("lib/libfoo.so", "libfoo.so.1")
+
+	if !b.IsLink() {
+		t.Errorf("Binary.IsLink() should be true")
+	}
+	if b.Link != "libfoo.so.1" {
+		t.Errorf("Binary.Link = %q; want %q", b.Link, "libfoo.so.1")
+	}
+	if b.Mode&os.ModeSymlink == 0 {
+		t.Errorf("Binary.Mode = %q; want symlink", b.Mode)
+	}
+	if b.Body != nil {
+		t.Errorf("Binary.Body = %v; want nil", b.Body)
+	}
+}
+
+func TestBinaryValidate(t *testing.T) {
+	tests := []struct {
+		desc  string
+		bin   *Binary
+		isErr bool
+	}{
+		{desc: "ok: file", bin: &Binary{Name: "droot"}, isErr: false},
+		{desc: "ok: nested file", bin: &Binary{Name: "css/app.css"}, isErr: false},
+		{desc: "ok: link within release", bin: &Binary{Name: "js/app.js", Link: "../dist/app.js"}, isErr: false},
+		{desc: "ng: empty", bin: &Binary{Name: ""}, isErr: true},
+		{desc: "ng: absolute path", bin: &Binary{Name: "/etc/passwd"}, isErr: true},
+		{desc: "ng: parent directory", bin: &Binary{Name: "../droot"}, isErr: true},
+		{desc: "ng: nested parent directory", bin: &Binary{Name: "css/../../droot"}, isErr: true},
+		{desc: "ng: backslash", bin: &Binary{Name: "..\\droot"}, isErr: true},
+		{desc: "ng: absolute link", bin: &Binary{Name: "passwd", Link: "/etc/passwd"}, isErr: true},
+		{desc: "ng: link escaping release", bin: &Binary{Name: "js/app.js", Link: "../../app.js"}, isErr: true},
+	}
+	for _, tc := range tests {
+		err := tc.bin.Validate()
+		if tc.isErr && err == nil {
+			t.Errorf("desc: %s, should raise error", tc.desc)
+		}
+		if !tc.isErr && err != nil {
+			t.Errorf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Chain links the release to the previous release of the project, so that
+// the releases form the hash chain in the order of the creation.
+type Chain struct {
+	// Prev is the timestamp of the previous release, which is empty for the
+	// first release.
+	Prev string `yaml:"prev,omitempty"`
+	// PrevHash is the hash of the meta of the previous release.
+	PrevHash string `yaml:"prev_hash,omitempty"`
+}
+
+// Hash returns the SHA-256 of the meta. The yank is excluded because it is
+// changed after the release is created.
+func (m *Meta) Hash() (string, error) {
+	c := *m
+	c.Yanked = nil
+	data, err := yaml.Marshal(&c)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal yaml")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Tombstone represents the release deleted or archived from the live
+// releases, which keeps the link in the chain.
+type Tombstone struct {
+	Timestamp string `yaml:"timestamp"`
+	Hash      string `yaml:"hash"`
+	Chain     *Chain `yaml:"chain,omitempty"`
+	// Deleted is the time when the release is deleted.
+	Deleted string `yaml:"deleted"`
+}
+
+// NewTombstone creates the tombstone of the release with the meta.
+func NewTombstone(timestamp string, meta *Meta) (*Tombstone, error) {
+	hash, err := meta.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return &Tombstone{Timestamp: timestamp, Hash: hash, Chain: meta.Chain, Deleted: Now()}, nil
+}
+
+// Link represents the release or its tombstone in the chain.
+type Link struct {
+	Timestamp string
+	Hash      string
+	// Chain is nil if the release was created before the chain.
+	Chain *Chain
+	// Tombstone is the tombstone of the release if the release was deleted
+	// or archived. The release may be restored after that.
+	Tombstone *Tombstone
+	// Deleted is whether only the tombstone is left or not.
+	Deleted bool
+}
+
+// NewLink creates the link of the release of the timestamp, and of the
+// tombstone if it is not nil.
+func NewLink(timestamp string, meta *Meta, tombstone *Tombstone) (*Link, error) {
+	if meta == nil {
+		return &Link{Timestamp: timestamp, Hash: tombstone.Hash, Chain: tombstone.Chain, Tombstone: tombstone, Deleted: true}, nil
+	}
+	hash, err := meta.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return &Link{Timestamp: timestamp, Hash: hash, Chain: meta.Chain, Tombstone: tombstone}, nil
+}
+
+// NextChain returns the chain of the release created next to the link,
+// which is the first release if the link is nil.
+func (l *Link) NextChain() *Chain {
+	if l == nil {
+		return &Chain{}
+	}
+	return &Chain{Prev: l.Timestamp, PrevHash: l.Hash}
+}
+
+// ChainProblem represents the broken link in the chain.
+type ChainProblem struct {
+	Timestamp string
+	Message   string
+	// Fork is whether the link is chained to the same previous release as
+	// the link before it, which the concurrent pushes leave because the
+	// previous release is read without any lock. It is not regarded as
+	// broken.
+	Fork bool
+}
+
+func (p *ChainProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Timestamp, p.Message)
+}
+
+// VerifyChain verifies the links sorted in ascending order of the
+// timestamps, and returns the problems such as the gaps, the rewritten
+// releases and the releases inserted out of order, and the forks by the
+// concurrent pushes. The releases created before the chain are only allowed
+// before the first chained release.
+func VerifyChain(links []*Link) []*ChainProblem {
+	var problems []*ChainProblem
+	report := func(l *Link, format string, args ...interface{}) {
+		problems = append(problems, &ChainProblem{Timestamp: l.Timestamp, Message: fmt.Sprintf(format, args...)})
+	}
+	byTimestamp := make(map[string]*Link, len(links))
+	for _, l := range links {
+		byTimestamp[l.Timestamp] = l
+	}
+	// last is the last link in the order of the chain.
+	var (
+		last    *Link
+		chained bool
+	)
+	for _, l := range links {
+		if l.Tombstone != nil && !l.Deleted && l.Tombstone.Hash != l.Hash {
+			report(l, "rewritten after it was deleted or archived, the hash %s differs from the tombstone %s", short(l.Hash), short(l.Tombstone.Hash))
+		}
+		if l.Chain == nil {
+			if chained {
+				report(l, "not chained, inserted without binrep or rewritten")
+				continue
+			}
+			last = l
+			continue
+		}
+		chained = true
+		expected := last.NextChain()
+		if l.Chain.Prev == expected.Prev {
+			if l.Chain.PrevHash != expected.PrevHash {
+				report(l, "the previous release %s was rewritten, the hash %s differs from %s", l.Chain.Prev, short(expected.PrevHash), short(l.Chain.PrevHash))
+			}
+			last = l
+			continue
+		}
+		prev, ok := byTimestamp[l.Chain.Prev]
+		switch {
+		case l.Chain.Prev == "":
+			report(l, "chained as the first release after %s", last.Timestamp)
+		case !ok:
+			report(l, "the previous release %s is missing without the tombstone", l.Chain.Prev)
+		case l.Chain.Prev > l.Timestamp:
+			report(l, "inserted out of order after %s", l.Chain.Prev)
+			// The links after it are still chained to last.
+			continue
+		case prev.Hash != l.Chain.PrevHash:
+			report(l, "chained to %s, which was rewritten, instead of %s", l.Chain.Prev, expected.Prev)
+		case last.Chain != nil && l.Chain.Prev == last.Chain.Prev:
+			report(l, "forked from %s by the concurrent pushes, both chained to %s", last.Timestamp, l.Chain.Prev)
+			problems[len(problems)-1].Fork = true
+		default:
+			report(l, "chained to %s instead of %s", l.Chain.Prev, expected.Prev)
+		}
+		last = l
+	}
+	return problems
+}
+
+// BrokenProblems returns the problems except the forks.
+func BrokenProblems(problems []*ChainProblem) []*ChainProblem {
+	var broken []*ChainProblem
+	for _, p := range problems {
+		if !p.Fork {
+			broken = append(broken, p)
+		}
+	}
+	return broken
+}
+
+// ErrBrokenChain means that the chain of the releases is broken. It is
+// matched with BrokenChainError by errors.Is.
+var ErrBrokenChain = errors.New("broken chain")
+
+// BrokenChainError represents the problems of the chain of the project.
+type BrokenChainError struct {
+	Name     string
+	Problems []*ChainProblem
+}
+
+func (e *BrokenChainError) Error() string {
+	return fmt.Sprintf("the chain of %s is broken: %d problems", e.Name, len(e.Problems))
+}
+
+// Is returns whether target is ErrBrokenChain.
+func (e *BrokenChainError) Is(target error) bool {
+	return target == ErrBrokenChain
+}
+
+func short(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
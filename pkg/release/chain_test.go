@@ -0,0 +1,189 @@
+package release
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestMetaHash(t *testing.T) {
+	meta := &Meta{
+		Binaries: []*Binary{{Name: "droot", Checksum: "ec9efb6249e0e4797bde75afbfe962e0db81c530b5bb1cfd2cbe0e2fc2c8cf48"}},
+		Chain:    &Chain{Prev: "20171017152508", PrevHash: "3e30f16f"},
+	}
+	hash, err := meta.Hash()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	var decoded Meta
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	decoded.Yanked = &Yank{Reason: "broken", Timestamp: "20171018000000"}
+	if got, _ := decoded.Hash(); got != hash {
+		t.Errorf("the hash should not be changed by the round trip and the yank, got %s, want %s", got, hash)
+	}
+	if decoded.Yanked == nil {
+		t.Errorf("the hash should not clear the yank of the meta")
+	}
+
+	decoded.Binaries[0].Checksum = "3e30f16f0ec41ab92ceca57a527efff18b6bacabd12a842afda07b8329e32259"
+	if got, _ := decoded.Hash(); got == hash {
+		t.Errorf("the hash should be changed by the binaries, got %s", got)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	link := func(ts, hash, prev, prevHash string) *Link {
+		return &Link{Timestamp: ts, Hash: hash, Chain: &Chain{Prev: prev, PrevHash: prevHash}}
+	}
+	deleted := func(l *Link) *Link {
+		l.Tombstone = &Tombstone{Timestamp: l.Timestamp, Hash: l.Hash, Chain: l.Chain}
+		l.Deleted = true
+		return l
+	}
+	legacy := func(ts, hash string) *Link {
+		return &Link{Timestamp: ts, Hash: hash}
+	}
+
+	tests := []struct {
+		desc     string
+		links    []*Link
+		expected []*ChainProblem
+	}{
+		{
+			desc: "chained",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				link("20171017000002", "bbb", "20171017000001", "aaa"),
+				link("20171017000003", "ccc", "20171017000002", "bbb"),
+			},
+		},
+		{
+			desc: "releases before the chain",
+			links: []*Link{
+				legacy("20171017000001", "aaa"),
+				legacy("20171017000002", "bbb"),
+				link("20171017000003", "ccc", "20171017000002", "bbb"),
+			},
+		},
+		{
+			desc: "gap with the tombstone",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				deleted(link("20171017000002", "bbb", "20171017000001", "aaa")),
+				link("20171017000003", "ccc", "20171017000002", "bbb"),
+			},
+		},
+		{
+			desc: "gap without the tombstone",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				link("20171017000003", "ccc", "20171017000002", "bbb"),
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000003", Message: "the previous release 20171017000002 is missing without the tombstone"},
+			},
+		},
+		{
+			desc: "rewritten previous release",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				link("20171017000002", "xxx", "20171017000001", "aaa"),
+				link("20171017000003", "ccc", "20171017000002", "bbb"),
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000003", Message: "the previous release 20171017000002 was rewritten, the hash xxx differs from bbb"},
+			},
+		},
+		{
+			desc: "rewritten after restored",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				{Timestamp: "20171017000002", Hash: "xxx", Chain: &Chain{Prev: "20171017000001", PrevHash: "aaa"}, Tombstone: &Tombstone{Hash: "bbb"}},
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000002", Message: "rewritten after it was deleted or archived, the hash xxx differs from the tombstone bbb"},
+			},
+		},
+		{
+			desc: "inserted out of order",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				link("20171017000002", "ddd", "20171017000003", "ccc"),
+				link("20171017000003", "ccc", "20171017000001", "aaa"),
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000002", Message: "inserted out of order after 20171017000003"},
+			},
+		},
+		{
+			desc: "forked",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				link("20171017000002", "bbb", "20171017000001", "aaa"),
+				link("20171017000003", "ccc", "20171017000001", "aaa"),
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000003", Message: "forked from 20171017000002 by the concurrent pushes, both chained to 20171017000001", Fork: true},
+			},
+		},
+		{
+			desc: "chained to an older release",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				link("20171017000002", "bbb", "20171017000001", "aaa"),
+				link("20171017000003", "ccc", "20171017000002", "bbb"),
+				link("20171017000004", "ddd", "20171017000001", "aaa"),
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000004", Message: "chained to 20171017000001 instead of 20171017000003"},
+			},
+		},
+		{
+			desc: "unchained after the chain",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				legacy("20171017000002", "bbb"),
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000002", Message: "not chained, inserted without binrep or rewritten"},
+			},
+		},
+		{
+			desc: "chained as the first release",
+			links: []*Link{
+				link("20171017000001", "aaa", "", ""),
+				link("20171017000002", "bbb", "", ""),
+			},
+			expected: []*ChainProblem{
+				{Timestamp: "20171017000002", Message: "chained as the first release after 20171017000001"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		got := VerifyChain(tc.links)
+		if diff := pretty.Compare(got, tc.expected); diff != "" {
+			t.Errorf("desc: %s, diff: (-actual +expected)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestBrokenProblems(t *testing.T) {
+	problems := []*ChainProblem{
+		{Timestamp: "20171017000002", Message: "forked", Fork: true},
+		{Timestamp: "20171017000003", Message: "inserted out of order"},
+	}
+
+	got := BrokenProblems(problems)
+
+	if diff := pretty.Compare(got, problems[1:]); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
@@ -0,0 +1,172 @@
+package release
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CompressionGzip is the gzip compression algorithm.
+	CompressionGzip = "gzip"
+	// CompressionZstd is the zstandard compression algorithm.
+	CompressionZstd = "zstd"
+)
+
+// ValidateCompression returns error if algo is not a supported compression algorithm.
+func ValidateCompression(algo string) error {
+	switch algo {
+	case CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return errors.Errorf("unsupported compression algorithm %q, want %q or %q", algo, CompressionGzip, CompressionZstd)
+}
+
+// Compress compresses the body with algo into a temporary file, and records
+// the checksum and the size of the compressed body. The original checksum
+// and size are kept as they are. The temporary file is removed when the body
+// is closed.
+func (b *Binary) Compress(algo string) error {
+	if err := ValidateCompression(algo); err != nil {
+		return err
+	}
+	if err := rewind(b.Body); err != nil {
+		return errors.Wrapf(err, "failed to rewind %s", b.Name)
+	}
+	tmp, err := ioutil.TempFile("", "binrep-compress-")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file to compress %s", b.Name)
+	}
+	body := &tempFile{File: tmp}
+	if err := b.compressTo(algo, body); err != nil {
+		body.Close()
+		return err
+	}
+	b.Body = body
+	return nil
+}
+
+func (b *Binary) compressTo(algo string, tmp *tempFile) error {
+	h := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(tmp, h)}
+	var w io.WriteCloser
+	switch algo {
+	case CompressionGzip:
+		w = gzip.NewWriter(cw)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(cw)
+		if err != nil {
+			return errors.Wrap(err, "failed to create zstd writer")
+		}
+		w = zw
+	}
+	if _, err := io.Copy(w, b.Body); err != nil {
+		return errors.Wrapf(err, "failed to compress %s", b.Name)
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "failed to compress %s", b.Name)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "failed to rewind the compressed %s", b.Name)
+	}
+	b.Compression = algo
+	b.CompressedChecksum = fmt.Sprintf("%x", h.Sum(nil))
+	b.CompressedSize = cw.n
+	return nil
+}
+
+// tempFile is the temporary file removed on Close.
+type tempFile struct {
+	*os.File
+}
+
+// Close closes and removes the file.
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if rerr := os.Remove(f.Name()); err == nil && rerr != nil && !os.IsNotExist(rerr) {
+		err = rerr
+	}
+	return err
+}
+
+// countWriter counts the bytes written into w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// IsCompressed returns whether the body is stored compressed or not.
+func (b *Binary) IsCompressed() bool {
+	return b.Compression != ""
+}
+
+// NewDecompressReader returns the reader that decompresses src, which is
+// the stored body of the binary. It returns src as it is if the binary is
+// not compressed. The reader validates the compressed checksum at the end of
+// the stream, and returns InvalidChecksumError instead of io.EOF if src
+// doesn't match it.
+func (b *Binary) NewDecompressReader(src io.Reader) (io.ReadCloser, error) {
+	if b.Compression == "" {
+		return ioutil.NopCloser(src), nil
+	}
+	h := sha256.New()
+	src = io.TeeReader(src, h)
+	var dr io.ReadCloser
+	switch b.Compression {
+	case CompressionGzip:
+		r, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read gzip header of %s", b.Name)
+		}
+		dr = r
+	case CompressionZstd:
+		r, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create zstd reader of %s", b.Name)
+		}
+		dr = r.IOReadCloser()
+	default:
+		return nil, errors.Errorf("%s is compressed with unsupported algorithm %q", b.Name, b.Compression)
+	}
+	if b.CompressedChecksum == "" {
+		return dr, nil
+	}
+	return &decompressReader{ReadCloser: dr, src: src, h: h, want: b.CompressedChecksum}, nil
+}
+
+// decompressReader validates the checksum of the compressed stream src
+// hashed into h when the decompressed stream ends.
+type decompressReader struct {
+	io.ReadCloser
+	src  io.Reader
+	h    hash.Hash
+	want string
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	// Hash the trailing bytes which the decompressor doesn't consume.
+	if _, err := io.Copy(ioutil.Discard, r.src); err != nil {
+		return n, err
+	}
+	if sum := fmt.Sprintf("%x", r.h.Sum(nil)); sum != r.want {
+		return n, errors.WithStack(&InvalidChecksumError{got: sum, want: r.want})
+	}
+	return n, io.EOF
+}
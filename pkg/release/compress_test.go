@@ -0,0 +1,119 @@
+package release
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBinaryCompress(t *testing.T) {
+	body := strings.Repeat("droot-body", 1024)
+
+	for _, algo := range []string{CompressionGzip, CompressionZstd} {
+		b, err := BuildBinary("droot", 0755, strings.NewReader(body))
+		if err != nil {
+			panic(err)
+		}
+		checksum := b.Checksum
+
+		if err := b.Compress(algo); err != nil {
+			t.Fatalf("algo: %s, should not raise error: %s", algo, err)
+		}
+
+		if b.Checksum != checksum {
+			t.Errorf("algo: %s, Binary.Checksum = %q; want %q", algo, b.Checksum, checksum)
+		}
+		if b.Size != int64(len(body)) {
+			t.Errorf("algo: %s, Binary.Size = %d; want %d", algo, b.Size, len(body))
+		}
+		if b.Compression != algo {
+			t.Errorf("algo: %s, Binary.Compression = %q; want %q", algo, b.Compression, algo)
+		}
+		if b.CompressedSize >= b.Size {
+			t.Errorf("algo: %s, Binary.CompressedSize = %d; want less than %d", algo, b.CompressedSize, b.Size)
+		}
+
+		stored := new(bytes.Buffer)
+		if _, err := stored.ReadFrom(b.Body); err != nil {
+			panic(err)
+		}
+		if sum := fmt.Sprintf("%x", sha256.Sum256(stored.Bytes())); sum != b.CompressedChecksum {
+			t.Errorf("algo: %s, Binary.CompressedChecksum = %q; want %q", algo, b.CompressedChecksum, sum)
+		}
+
+		src, err := b.NewDecompressReader(stored)
+		if err != nil {
+			t.Fatalf("algo: %s, should not raise error: %s", algo, err)
+		}
+		out := new(bytes.Buffer)
+		if _, err := b.CopyAndValidateChecksum(out, src); err != nil {
+			t.Fatalf("algo: %s, should not raise error: %s", algo, err)
+		}
+		src.Close()
+		if out.String() != body {
+			t.Errorf("algo: %s, decompressed body differs from the original", algo)
+		}
+	}
+}
+
+func TestBinaryCompress_compressedChecksumMismatch(t *testing.T) {
+	b, err := BuildBinary("droot", 0755, strings.NewReader("droot-body"))
+	if err != nil {
+		panic(err)
+	}
+	if err := b.Compress(CompressionGzip); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	tmp := b.Body.(*tempFile).Name()
+	defer func() {
+		b.Body.(io.Closer).Close()
+		if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+			t.Errorf("the temporary file %s should be removed on Close", tmp)
+		}
+	}()
+	b.CompressedChecksum = strings.Repeat("0", 64)
+
+	src, err := b.NewDecompressReader(b.Body)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	_, err = b.CopyAndValidateChecksum(ioutil.Discard, src)
+
+	if !IsChecksumError(err) {
+		t.Errorf("should raise InvalidChecksumError, got %v", err)
+	}
+}
+
+func TestBinaryCompress_errorUnsupported(t *testing.T) {
+	b, err := BuildBinary("droot", 0755, strings.NewReader("droot-body"))
+	if err != nil {
+		panic(err)
+	}
+
+	err = b.Compress("lz4")
+
+	expected := `unsupported compression algorithm "lz4", want "gzip" or "zstd"`
+	if fmt.Sprintf("%s", err) != expected {
+		t.Fatalf("err = %q; want %q", err, expected)
+	}
+}
+
+func TestBinaryNewDecompressReader_uncompressed(t *testing.T) {
+	b := &Binary{Name: "droot"}
+	src := strings.NewReader("droot-body")
+
+	r, err := b.NewDecompressReader(src)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	out := new(bytes.Buffer)
+	out.ReadFrom(r)
+	if out.String() != "droot-body" {
+		t.Errorf("got %q, want %q", out.String(), "droot-body")
+	}
+}
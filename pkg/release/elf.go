@@ -0,0 +1,107 @@
+package release
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ELF represents the linkage information of an ELF binary.
+type ELF struct {
+	Arch        string   `yaml:"arch"`
+	Interpreter string   `yaml:"interpreter,omitempty"`
+	Needed      []string `yaml:"needed,omitempty"`
+	Stripped    bool     `yaml:"stripped"`
+}
+
+var elfArchs = map[elf.Machine]string{
+	elf.EM_386:       "386",
+	elf.EM_X86_64:    "amd64",
+	elf.EM_ARM:       "arm",
+	elf.EM_AARCH64:   "arm64",
+	elf.EM_PPC64:     "ppc64",
+	elf.EM_S390:      "s390x",
+	elf.EM_MIPS:      "mips",
+	elf.EM_RISCV:     "riscv64",
+	elf.EM_LOONGARCH: "loong64",
+}
+
+// InspectELF inspects r as an ELF file. It returns nil without error if r
+// is not an ELF file, such as tarballs and web assets.
+func InspectELF(r io.ReaderAt) (*ELF, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		if _, ok := err.(*elf.FormatError); ok {
+			return nil, nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read elf")
+	}
+	defer f.Close()
+
+	e := &ELF{Arch: elfArch(f)}
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := ioutil.ReadAll(p.Open())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read elf interpreter")
+		}
+		e.Interpreter = strings.TrimRight(string(data), "\x00")
+	}
+	e.Needed, err = f.ImportedLibraries()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read elf needed libraries")
+	}
+	e.Stripped = f.Section(".symtab") == nil
+	return e, nil
+}
+
+// elfArch returns the GOARCH of f. The machines shared by several GOARCHs,
+// such as ppc64 and ppc64le, are distinguished by the class and the byte
+// order of f.
+func elfArch(f *elf.File) string {
+	arch, ok := elfArchs[f.Machine]
+	if !ok {
+		return strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+	}
+	if f.Machine == elf.EM_MIPS && f.Class == elf.ELFCLASS64 {
+		arch = "mips64"
+	}
+	if (f.Machine == elf.EM_PPC64 || f.Machine == elf.EM_MIPS) && f.Data == elf.ELFDATA2LSB {
+		arch += "le"
+	}
+	return arch
+}
+
+// IsStatic returns whether the binary is statically linked or not.
+func (e *ELF) IsStatic() bool {
+	return e.Interpreter == "" && len(e.Needed) == 0
+}
+
+// StaticLinkError represents an error that the binary is dynamically linked.
+type StaticLinkError struct {
+	Name string
+	ELF  *ELF
+}
+
+// Error returns the error message for StaticLinkError.
+func (e *StaticLinkError) Error() string {
+	return fmt.Sprintf("%s is dynamically linked (interpreter: %q, needed: [%s]), but static binaries are required",
+		e.Name, e.ELF.Interpreter, strings.Join(e.ELF.Needed, ", "))
+}
+
+// ValidateStatic returns StaticLinkError if the binary is a dynamically linked ELF.
+func (b *Binary) ValidateStatic() error {
+	if b.ELF == nil || b.ELF.IsStatic() {
+		return nil
+	}
+	return errors.WithStack(&StaticLinkError{Name: b.Name, ELF: b.ELF})
+}
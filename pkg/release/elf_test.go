@@ -0,0 +1,159 @@
+package release
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// buildELF builds a minimal x86-64 ELF executable without sections.
+func buildELF(interp string) []byte {
+	var progs []elf.Prog64
+	if interp != "" {
+		progs = append(progs, elf.Prog64{
+			Type:   uint32(elf.PT_INTERP),
+			Off:    64 + 56,
+			Filesz: uint64(len(interp) + 1),
+			Memsz:  uint64(len(interp) + 1),
+			Align:  1,
+		})
+	}
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     64,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     uint16(len(progs)),
+		Shentsize: 64,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, hdr)
+	for _, p := range progs {
+		binary.Write(buf, binary.LittleEndian, p)
+	}
+	if interp != "" {
+		buf.WriteString(interp + "\x00")
+	}
+	return buf.Bytes()
+}
+
+func TestInspectELF(t *testing.T) {
+	tests := []struct {
+		desc     string
+		body     []byte
+		expected *ELF
+	}{
+		{
+			desc:     "static",
+			body:     buildELF(""),
+			expected: &ELF{Arch: "amd64", Stripped: true},
+		},
+		{
+			desc:     "dynamic",
+			body:     buildELF("/lib64/ld-linux-x86-64.so.2"),
+			expected: &ELF{Arch: "amd64", Interpreter: "/lib64/ld-linux-x86-64.so.2", Stripped: true},
+		},
+		{
+			desc:     "not elf",
+			body:     []byte("body { color: red; }"),
+			expected: nil,
+		},
+		{
+			desc:     "empty",
+			body:     []byte{},
+			expected: nil,
+		},
+	}
+	for _, tc := range tests {
+		got, err := InspectELF(bytes.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		if diff := pretty.Compare(got, tc.expected); diff != "" {
+			t.Errorf("desc: %s, diff: (-actual +expected)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+// buildELFHeader builds an ELF header without programs and sections.
+func buildELFHeader(machine elf.Machine, class elf.Class, data elf.Data) []byte {
+	var order binary.ByteOrder = binary.LittleEndian
+	if data == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	var ident [elf.EI_NIDENT]byte
+	copy(ident[:], elf.ELFMAG)
+	ident[elf.EI_CLASS] = byte(class)
+	ident[elf.EI_DATA] = byte(data)
+	ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	buf := new(bytes.Buffer)
+	if class == elf.ELFCLASS32 {
+		binary.Write(buf, order, elf.Header32{Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(machine),
+			Version: uint32(elf.EV_CURRENT), Ehsize: 52, Phentsize: 32, Shentsize: 40})
+	} else {
+		binary.Write(buf, order, elf.Header64{Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(machine),
+			Version: uint32(elf.EV_CURRENT), Ehsize: 64, Phentsize: 56, Shentsize: 64})
+	}
+	return buf.Bytes()
+}
+
+func TestInspectELF_arch(t *testing.T) {
+	tests := []struct {
+		machine  elf.Machine
+		class    elf.Class
+		data     elf.Data
+		expected string
+	}{
+		{machine: elf.EM_PPC64, class: elf.ELFCLASS64, data: elf.ELFDATA2MSB, expected: "ppc64"},
+		{machine: elf.EM_PPC64, class: elf.ELFCLASS64, data: elf.ELFDATA2LSB, expected: "ppc64le"},
+		{machine: elf.EM_MIPS, class: elf.ELFCLASS32, data: elf.ELFDATA2MSB, expected: "mips"},
+		{machine: elf.EM_MIPS, class: elf.ELFCLASS32, data: elf.ELFDATA2LSB, expected: "mipsle"},
+		{machine: elf.EM_MIPS, class: elf.ELFCLASS64, data: elf.ELFDATA2MSB, expected: "mips64"},
+		{machine: elf.EM_MIPS, class: elf.ELFCLASS64, data: elf.ELFDATA2LSB, expected: "mips64le"},
+		{machine: elf.EM_ARM, class: elf.ELFCLASS32, data: elf.ELFDATA2LSB, expected: "arm"},
+		{machine: elf.EM_S390, class: elf.ELFCLASS64, data: elf.ELFDATA2MSB, expected: "s390x"},
+	}
+	for _, tc := range tests {
+		got, err := InspectELF(bytes.NewReader(buildELFHeader(tc.machine, tc.class, tc.data)))
+		if err != nil {
+			t.Fatalf("machine: %s, should not raise error: %s", tc.expected, err)
+		}
+		if got == nil || got.Arch != tc.expected {
+			t.Errorf("machine: %s, got %+v", tc.expected, got)
+		}
+	}
+}
+
+func TestBinaryValidateStatic(t *testing.T) {
+	tests := []struct {
+		desc  string
+		elf   *ELF
+		isErr bool
+	}{
+		{desc: "not elf", elf: nil, isErr: false},
+		{desc: "static", elf: &ELF{Arch: "amd64"}, isErr: false},
+		{desc: "interpreter", elf: &ELF{Arch: "amd64", Interpreter: "/lib/ld-musl-x86_64.so.1"}, isErr: true},
+		{desc: "needed", elf: &ELF{Arch: "amd64", Needed: []string{"libc.so.6"}}, isErr: true},
+	}
+	for _, tc := range tests {
+		b := &Binary{Name: "droot", ELF: tc.elf}
+		err := b.ValidateStatic()
+		if tc.isErr && err == nil {
+			t.Errorf("desc: %s, should raise error", tc.desc)
+		}
+		if !tc.isErr && err != nil {
+			t.Errorf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+	}
+}
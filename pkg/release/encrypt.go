@@ -0,0 +1,415 @@
+package release
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// EncryptionAlgorithm is the algorithm of the envelope encryption: the
+	// bodies are encrypted by AES-256-GCM with the data key of the release,
+	// and the data key is wrapped for each X25519 recipient.
+	EncryptionAlgorithm = "x25519-aes-256-gcm"
+
+	recipientPrefix = "x25519:"
+	identityPrefix  = "X25519-SECRET-KEY:"
+
+	dataKeySize = 32
+	// encryptChunkSize is the size of the plaintext chunks, each of which is
+	// sealed separately not to buffer the whole body.
+	encryptChunkSize = 64 * 1024
+	// encryptOverhead is the size of the GCM tag appended to each chunk.
+	encryptOverhead = 16
+)
+
+// Encryption represents the data key of the release wrapped for each recipient.
+type Encryption struct {
+	Algorithm  string        `yaml:"algorithm"`
+	Recipients []*WrappedKey `yaml:"recipients"`
+}
+
+// WrappedKey represents the data key wrapped for the recipient.
+type WrappedKey struct {
+	Recipient string `yaml:"recipient"`
+	Ephemeral string `yaml:"ephemeral"`
+	Key       string `yaml:"key"`
+}
+
+// Identity represents the X25519 private key to unwrap the data keys.
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity generates a new identity.
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate x25519 key")
+	}
+	return &Identity{key: key}, nil
+}
+
+// Recipient returns the public key string of the identity.
+func (id *Identity) Recipient() string {
+	return recipientPrefix + base64.StdEncoding.EncodeToString(id.key.PublicKey().Bytes())
+}
+
+// String returns the secret key string of the identity.
+func (id *Identity) String() string {
+	return identityPrefix + base64.StdEncoding.EncodeToString(id.key.Bytes())
+}
+
+// Format formats the identity as the content of an identity file.
+func (id *Identity) Format(now time.Time) string {
+	return fmt.Sprintf("# created: %s\n# recipient: %s\n%s\n", now.Format(time.RFC3339), id.Recipient(), id)
+}
+
+// ParseIdentities parses the identity file content. The empty lines and
+// the lines starting with '#' are ignored.
+func ParseIdentities(r io.Reader) ([]*Identity, error) {
+	var ids []*Identity
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, identityPrefix) {
+			return nil, errors.Errorf("invalid identity: want %q prefix", identityPrefix)
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, identityPrefix))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid identity")
+		}
+		key, err := ecdh.X25519().NewPrivateKey(b)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid identity")
+		}
+		ids = append(ids, &Identity{key: key})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read identities")
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no identities found")
+	}
+	return ids, nil
+}
+
+// ParseRecipient parses the public key string such as 'x25519:<base64>'.
+func ParseRecipient(s string) (*ecdh.PublicKey, error) {
+	if !strings.HasPrefix(s, recipientPrefix) {
+		return nil, errors.Errorf("invalid recipient %q: want %q prefix", s, recipientPrefix)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, recipientPrefix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid recipient %q", s)
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid recipient %q", s)
+	}
+	return key, nil
+}
+
+// NewEncryption generates a new data key, and wraps it for the recipients.
+func NewEncryption(recipients []string) (*Encryption, []byte, error) {
+	if len(recipients) == 0 {
+		return nil, nil, errors.New("no recipients to encrypt to")
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate data key")
+	}
+	enc := &Encryption{Algorithm: EncryptionAlgorithm}
+	for _, r := range recipients {
+		pub, err := ParseRecipient(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to generate x25519 key")
+		}
+		shared, err := eph.ECDH(pub)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to compute x25519 shared secret")
+		}
+		aead, err := wrapAEAD(shared, eph.PublicKey(), pub)
+		if err != nil {
+			return nil, nil, err
+		}
+		enc.Recipients = append(enc.Recipients, &WrappedKey{
+			Recipient: r,
+			Ephemeral: base64.StdEncoding.EncodeToString(eph.PublicKey().Bytes()),
+			Key:       base64.StdEncoding.EncodeToString(aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, nil)),
+		})
+	}
+	return enc, dataKey, nil
+}
+
+// wrapAEAD returns the AEAD to wrap the data key by the shared secret
+// between the ephemeral key and the recipient. The key is unique for each
+// ephemeral key, so that the zero nonce is used.
+func wrapAEAD(shared []byte, eph, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(eph.Bytes(), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, "binrep x25519 wrap", dataKeySize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive wrapping key")
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create aes cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create gcm")
+	}
+	return aead, nil
+}
+
+// NoIdentityError represents an error that no identity matches the
+// recipients of the encrypted release.
+type NoIdentityError struct {
+	Recipients []string
+}
+
+// Error returns the error message for NoIdentityError.
+func (e *NoIdentityError) Error() string {
+	return fmt.Sprintf("the release is encrypted, but no identity matches the recipients [%s]",
+		strings.Join(e.Recipients, ", "))
+}
+
+// Unwrap unwraps the data key with any of the identities.
+func (e *Encryption) Unwrap(ids []*Identity) ([]byte, error) {
+	if e.Algorithm != EncryptionAlgorithm {
+		return nil, errors.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+	}
+	recipients := make([]string, 0, len(e.Recipients))
+	for _, wk := range e.Recipients {
+		recipients = append(recipients, wk.Recipient)
+		for _, id := range ids {
+			if id.Recipient() != wk.Recipient {
+				continue
+			}
+			b, err := base64.StdEncoding.DecodeString(wk.Ephemeral)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid ephemeral key for %s", wk.Recipient)
+			}
+			eph, err := ecdh.X25519().NewPublicKey(b)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid ephemeral key for %s", wk.Recipient)
+			}
+			wrapped, err := base64.StdEncoding.DecodeString(wk.Key)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid wrapped key for %s", wk.Recipient)
+			}
+			shared, err := id.key.ECDH(eph)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to compute x25519 shared secret")
+			}
+			aead, err := wrapAEAD(shared, eph, id.key.PublicKey())
+			if err != nil {
+				return nil, err
+			}
+			dataKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to unwrap data key for %s", wk.Recipient)
+			}
+			return dataKey, nil
+		}
+	}
+	return nil, errors.WithStack(&NoIdentityError{Recipients: recipients})
+}
+
+// IsNoIdentityError returns whether err is NoIdentityError or not.
+func IsNoIdentityError(err error) bool {
+	_, ok := errors.Cause(err).(*NoIdentityError)
+	return ok
+}
+
+// binaryAEAD returns the AEAD for the body of the binary, whose key is
+// derived from the data key by the name not to reuse the nonces among the binaries.
+func (b *Binary) binaryAEAD(dataKey []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, dataKey, nil, "binrep binary "+b.Name, dataKeySize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive binary key")
+	}
+	return newGCM(key)
+}
+
+// Encrypt encrypts the body, which is compressed if the binary is
+// compressed, with the data key on the fly.
+func (b *Binary) Encrypt(dataKey []byte) error {
+	aead, err := b.binaryAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	if err := rewind(b.Body); err != nil {
+		return errors.Wrapf(err, "failed to rewind %s", b.Name)
+	}
+	b.Encrypted = true
+	b.Body = &chunkReader{
+		name:    b.Name,
+		aead:    aead,
+		orig:    b.Body,
+		src:     bufio.NewReaderSize(b.Body, encryptChunkSize),
+		size:    encryptChunkSize,
+		seal:    true,
+		scratch: make([]byte, encryptChunkSize+aead.Overhead()),
+	}
+	return nil
+}
+
+// StoredSize returns the size of the stored body, which is compressed and
+// encrypted if the binary is so.
+func (b *Binary) StoredSize() int64 {
+	size := b.Size
+	if b.IsCompressed() {
+		size = b.CompressedSize
+	}
+	if !b.Encrypted {
+		return size
+	}
+	// The empty body is sealed into an empty last chunk.
+	chunks := (size + encryptChunkSize - 1) / encryptChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*encryptOverhead
+}
+
+// NewDecryptReader returns the reader that decrypts src, which is the stored
+// body of the binary. It returns src as it is if the binary is not encrypted.
+func (b *Binary) NewDecryptReader(src io.Reader, dataKey []byte) (io.Reader, error) {
+	if !b.Encrypted {
+		return src, nil
+	}
+	if dataKey == nil {
+		return nil, errors.Errorf("%s is encrypted, but no data key is given", b.Name)
+	}
+	aead, err := b.binaryAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{
+		name:    b.Name,
+		aead:    aead,
+		src:     bufio.NewReaderSize(src, encryptChunkSize+aead.Overhead()),
+		size:    encryptChunkSize + aead.Overhead(),
+		scratch: make([]byte, encryptChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// chunkReader seals or opens src by the chunks. The nonce of each chunk
+// is the big-endian counter followed by the flag of the last chunk, so that
+// the reordered or truncated chunks are detected.
+type chunkReader struct {
+	name    string
+	aead    cipher.AEAD
+	orig    io.Reader // the source before buffering to rewind it
+	src     *bufio.Reader
+	size    int // the size of the input chunks
+	seal    bool
+	counter uint64
+	scratch []byte
+	buf     bytes.Buffer
+	done    bool
+	read    int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.buf.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// Close closes the source if it is io.Closer.
+func (r *chunkReader) Close() error {
+	if c, ok := r.orig.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Seek supports only telling the current offset and rewinding to the start
+// of the encrypted body, which requires the seekable source, so that the
+// upload of the body can be retried.
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch {
+	case offset == 0 && whence == io.SeekCurrent:
+		return r.read, nil
+	case offset == 0 && whence == io.SeekStart && r.seal:
+		s, ok := r.orig.(io.Seeker)
+		if !ok {
+			return 0, errors.Errorf("failed to rewind %s: the source is not seekable", r.name)
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return 0, errors.Wrapf(err, "failed to rewind %s", r.name)
+		}
+		r.src.Reset(r.orig)
+		r.counter, r.done, r.read = 0, false, 0
+		r.buf.Reset()
+		return 0, nil
+	}
+	return 0, errors.Errorf("failed to seek %s: only rewinding is supported", r.name)
+}
+
+func (r *chunkReader) next() error {
+	in := r.scratch[:r.size]
+	n, err := io.ReadFull(r.src, in)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		r.done = true
+	case err != nil:
+		return errors.Wrapf(err, "failed to read %s", r.name)
+	default:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to read %s", r.name)
+		}
+	}
+	nonce := make([]byte, r.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], r.counter)
+	if r.done {
+		nonce[len(nonce)-1] = 1
+	}
+	r.counter++
+	if r.seal {
+		r.buf.Write(r.aead.Seal(nil, nonce, in[:n], nil))
+		return nil
+	}
+	out, err := r.aead.Open(nil, nonce, in[:n], nil)
+	if err != nil {
+		return errors.Errorf("failed to decrypt %s: the body is corrupted, truncated or encrypted with another key", r.name)
+	}
+	r.buf.Write(out)
+	return nil
+}
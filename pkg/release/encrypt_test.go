@@ -0,0 +1,197 @@
+package release
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBinaryEncrypt(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		panic(err)
+	}
+	enc, dataKey, err := NewEncryption([]string{id.Recipient()})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	sizes := []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3 * encryptChunkSize}
+	for _, size := range sizes {
+		body := strings.Repeat("x", size)
+		b, err := BuildBinary("droot", 0755, strings.NewReader(body))
+		if err != nil {
+			panic(err)
+		}
+
+		if err := b.Encrypt(dataKey); err != nil {
+			t.Fatalf("size: %d, should not raise error: %s", size, err)
+		}
+		if !b.Encrypted {
+			t.Errorf("size: %d, Binary.Encrypted = false; want true", size)
+		}
+		stored := new(bytes.Buffer)
+		if _, err := stored.ReadFrom(b.Body); err != nil {
+			t.Fatalf("size: %d, should not raise error: %s", size, err)
+		}
+		if int64(stored.Len()) != b.StoredSize() {
+			t.Errorf("size: %d, got stored size %d, want %d", size, b.StoredSize(), stored.Len())
+		}
+		// The short plaintext may appear in the random ciphertext by chance.
+		if size >= 16 && bytes.Contains(stored.Bytes(), []byte(body)) {
+			t.Errorf("size: %d, the stored body contains the plaintext", size)
+		}
+
+		key, err := enc.Unwrap([]*Identity{id})
+		if err != nil {
+			t.Fatalf("size: %d, should not raise error: %s", size, err)
+		}
+		src, err := b.NewDecryptReader(bytes.NewReader(stored.Bytes()), key)
+		if err != nil {
+			t.Fatalf("size: %d, should not raise error: %s", size, err)
+		}
+		out := new(bytes.Buffer)
+		if _, err := b.CopyAndValidateChecksum(out, src); err != nil {
+			t.Fatalf("size: %d, should not raise error: %s", size, err)
+		}
+		if out.String() != body {
+			t.Errorf("size: %d, decrypted body differs from the original", size)
+		}
+
+		// Truncating the last chunk should be detected.
+		if size > 0 {
+			truncated := stored.Bytes()[:stored.Len()-1]
+			if size%encryptChunkSize == 0 {
+				truncated = stored.Bytes()[:stored.Len()-encryptChunkSize-16]
+			}
+			src, err := b.NewDecryptReader(bytes.NewReader(truncated), key)
+			if err != nil {
+				t.Fatalf("size: %d, should not raise error: %s", size, err)
+			}
+			if _, err := new(bytes.Buffer).ReadFrom(src); err == nil {
+				t.Errorf("size: %d, truncated body should raise error", size)
+			}
+		}
+	}
+}
+
+func TestBinaryEncrypt_rewind(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		panic(err)
+	}
+	_, dataKey, err := NewEncryption([]string{id.Recipient()})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	b, err := BuildBinary("droot", 0755, strings.NewReader(strings.Repeat("x", 3*encryptChunkSize)))
+	if err != nil {
+		panic(err)
+	}
+	if err := b.Encrypt(dataKey); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	seeker := b.Body.(io.Seeker)
+
+	if _, err := io.CopyN(ioutil.Discard, b.Body, encryptChunkSize+1); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if offset, err := seeker.Seek(0, io.SeekCurrent); err != nil || offset != encryptChunkSize+1 {
+		t.Errorf("got offset %d and error %v, want %d", offset, err, encryptChunkSize+1)
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	rewound := new(bytes.Buffer)
+	if _, err := rewound.ReadFrom(b.Body); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	b, err = BuildBinary("droot", 0755, strings.NewReader(strings.Repeat("x", 3*encryptChunkSize)))
+	if err != nil {
+		panic(err)
+	}
+	if err := b.Encrypt(dataKey); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	want := new(bytes.Buffer)
+	if _, err := want.ReadFrom(b.Body); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if !bytes.Equal(rewound.Bytes(), want.Bytes()) {
+		t.Errorf("the rewound body differs from the body read once")
+	}
+	if _, err := seeker.Seek(1, io.SeekStart); err == nil {
+		t.Errorf("seeking to the middle should raise error")
+	}
+}
+
+func TestEncryptionUnwrap_noIdentity(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		panic(err)
+	}
+	other, err := GenerateIdentity()
+	if err != nil {
+		panic(err)
+	}
+	enc, _, err := NewEncryption([]string{id.Recipient()})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	_, err = enc.Unwrap([]*Identity{other})
+
+	if !IsNoIdentityError(err) {
+		t.Fatalf("should raise NoIdentityError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), id.Recipient()) {
+		t.Errorf("error %q should contain the recipient %q", err, id.Recipient())
+	}
+}
+
+func TestParseIdentities(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		panic(err)
+	}
+
+	ids, err := ParseIdentities(strings.NewReader(id.Format(time.Now())))
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if len(ids) != 1 || ids[0].Recipient() != id.Recipient() {
+		t.Errorf("ParseIdentities() = %v; want the identity of %s", ids, id.Recipient())
+	}
+
+	tests := []string{
+		"",
+		"# no identities\n",
+		"x25519:AAAA\n",
+		"X25519-SECRET-KEY:!!!\n",
+	}
+	for _, tc := range tests {
+		if _, err := ParseIdentities(strings.NewReader(tc)); err == nil {
+			t.Errorf("ParseIdentities(%q) should raise error", tc)
+		}
+	}
+}
+
+func TestParseRecipient(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		panic(err)
+	}
+	if _, err := ParseRecipient(id.Recipient()); err != nil {
+		t.Errorf("ParseRecipient(%q) should not raise error: %s", id.Recipient(), err)
+	}
+	for _, tc := range []string{"", "hoge", "x25519:", "x25519:AAAA", id.String()} {
+		if _, err := ParseRecipient(tc); err == nil {
+			t.Errorf("ParseRecipient(%q) should raise error", tc)
+		}
+	}
+}
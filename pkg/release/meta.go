@@ -8,6 +8,42 @@ const (
 // Meta represents metadata of a release.
 type Meta struct {
 	Binaries []*Binary `yaml:"binaries"`
+	Yanked   *Yank     `yaml:"yanked,omitempty"`
+	// Encryption is the data key wrapped for the recipients if the
+	// binaries are encrypted.
+	Encryption *Encryption `yaml:"encryption,omitempty"`
+	// Chain links the release to the previous release of the project. It
+	// is nil if the release was created before the chain.
+	Chain *Chain `yaml:"chain,omitempty"`
+	// Tags are the names of the release such as the versions. The tagged
+	// releases are never pruned.
+	Tags []string `yaml:"tags,omitempty"`
+	// Channels are the channels such as 'stable' and 'beta' which the
+	// release is pushed to. The latest release of each channel is never
+	// pruned.
+	Channels []string `yaml:"channels,omitempty"`
+}
+
+// Yank represents that the release is yanked, that is, it is skipped when
+// resolving the latest release.
+type Yank struct {
+	Reason    string `yaml:"reason"`
+	Timestamp string `yaml:"timestamp"`
+}
+
+// IsYanked returns whether the release is yanked or not.
+func (m *Meta) IsYanked() bool {
+	return m.Yanked != nil
+}
+
+// HasChannel returns whether the release is pushed to the channel or not.
+func (m *Meta) HasChannel(channel string) bool {
+	for _, c := range m.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
 }
 
 // NewMeta returns a Meta object.
@@ -55,6 +55,16 @@ func (rel *Release) Inspect(w io.Writer) {
 		b.Inspect(w)
 	}
 	fmt.Fprintln(w)
+	if rel.Meta.IsYanked() {
+		fmt.Fprintf(w, "YANKED at %s: %s\n", rel.Meta.Yanked.Timestamp, rel.Meta.Yanked.Reason)
+	}
+	if enc := rel.Meta.Encryption; enc != nil {
+		recipients := make([]string, 0, len(enc.Recipients))
+		for _, wk := range enc.Recipients {
+			recipients = append(recipients, wk.Recipient)
+		}
+		fmt.Fprintf(w, "ENCRYPTED with %s to: %s\n", enc.Algorithm, strings.Join(recipients, ", "))
+	}
 }
 
 // Now returns the current UTC timestamp.
@@ -69,6 +79,11 @@ const (
 	timestampFormat = "20060102150405"
 )
 
+// ParseTimestamp parses the release timestamp as UTC.
+func ParseTimestamp(str string) (time.Time, error) {
+	return time.Parse(timestampFormat, str)
+}
+
 func isTimestamp(str string) bool {
 	if _, err := time.Parse(timestampFormat, str); err != nil {
 		if _, ok := err.(*time.ParseError); ok {
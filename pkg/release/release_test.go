@@ -128,3 +128,29 @@ func TestParseName(t *testing.T) {
 		}
 	}
 }
+
+func TestReleaseInspect_yanked(t *testing.T) {
+	meta := NewMeta([]*Binary{
+		{
+			Name:     "droot",
+			Checksum: "ec9efb6249e0e4797bde75afbfe962e0db81c530b5bb1cfd2cbe0e2fc2c8cf48",
+			Mode:     0755,
+		},
+	})
+	meta.Yanked = &Yank{Reason: "segfault on startup", Timestamp: "20171020000000"}
+
+	u, err := url.Parse("s3://binreptestbucket/github.com/yuuki/droot/20171019204009")
+	if err != nil {
+		panic(err)
+	}
+	rel := New(meta, u)
+
+	out := new(bytes.Buffer)
+
+	rel.Inspect(out)
+
+	expected := "NAME\tTIMESTAMP\tBINNARY1\t\ngithub.com/yuuki/droot\t20171019204009\tdroot/-rwxr-xr-x/ec9efb6\t\nYANKED at 20171020000000: segfault on startup\n"
+	if out.String() != expected {
+		t.Errorf("got: %q, want: %q", out.String(), expected)
+	}
+}
@@ -0,0 +1,85 @@
+package release
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy represents the policy of the releases to keep. A release
+// is kept if any rule of the policy keeps it, and the others are pruned. The
+// tagged releases and the latest release of each channel are always kept.
+type RetentionPolicy struct {
+	// KeepLast keeps the last N releases which are not yanked.
+	KeepLast int
+	// KeepWithin keeps the releases newer than the duration.
+	KeepWithin time.Duration
+	// KeepWeeklyWithin keeps the latest release of each week within the
+	// duration, which is not yanked.
+	KeepWeeklyWithin time.Duration
+}
+
+// Retention represents whether the release of the timestamp is kept or not, and why.
+type Retention struct {
+	Timestamp string
+	Keep      bool
+	Reason    string
+}
+
+// Apply applies the policy to the timestamps sorted in ascending order,
+// and returns the retentions in the same order. metas maps the timestamps
+// to the metas of the releases, and the release without the meta is
+// regarded as neither yanked nor tagged.
+func (p *RetentionPolicy) Apply(timestamps []string, metas map[string]*Meta, now time.Time) []*Retention {
+	rets := make([]*Retention, len(timestamps))
+	weeks, channels := map[string]bool{}, map[string]bool{}
+	last := 0
+	// Walk from the latest to keep the latest release of each week and
+	// of each channel.
+	for i := len(timestamps) - 1; i >= 0; i-- {
+		ts := timestamps[i]
+		meta := metas[ts]
+		if meta == nil {
+			meta = &Meta{}
+		}
+		t, err := ParseTimestamp(ts)
+		year, week := t.ISOWeek()
+		w := fmt.Sprintf("%04d-W%02d", year, week)
+		age := now.Sub(t)
+		var channel string
+		if !meta.IsYanked() {
+			last++
+			for _, c := range meta.Channels {
+				if !channels[c] {
+					channel = c
+				}
+				channels[c] = true
+			}
+		}
+
+		var reason string
+		switch {
+		case !meta.IsYanked() && last <= p.KeepLast:
+			reason = fmt.Sprintf("within the last %d releases", p.KeepLast)
+		case err != nil:
+			reason = "invalid timestamp"
+		case len(meta.Tags) > 0:
+			reason = fmt.Sprintf("tagged %s", strings.Join(meta.Tags, ", "))
+		case channel != "":
+			reason = fmt.Sprintf("the latest release of the channel %s", channel)
+		case p.KeepWithin > 0 && age <= p.KeepWithin:
+			reason = fmt.Sprintf("newer than %s", p.KeepWithin)
+		case p.KeepWeeklyWithin > 0 && age <= p.KeepWeeklyWithin && !weeks[w] && !meta.IsYanked():
+			reason = fmt.Sprintf("the latest release of the week %s within %s", w, p.KeepWeeklyWithin)
+		}
+		if reason == "" {
+			rets[i] = &Retention{Timestamp: ts, Keep: false, Reason: "not kept by the retention policy"}
+			continue
+		}
+		if err == nil && !meta.IsYanked() {
+			weeks[w] = true
+		}
+		rets[i] = &Retention{Timestamp: ts, Keep: true, Reason: reason}
+	}
+	return rets
+}
@@ -0,0 +1,119 @@
+package release
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestRetentionPolicyApply(t *testing.T) {
+	now := time.Date(2017, 12, 20, 0, 0, 0, 0, time.UTC)
+	timestamps := []string{
+		"20170901000000", // 2017-W35
+		"20171002000000", // 2017-W40
+		"20171004000000", // 2017-W40
+		"20171120000000", // 2017-W47
+		"20171122000000", // 2017-W47
+		"20171215000000", // 2017-W50
+		"20171218000000", // 2017-W51
+		"20171219000000", // 2017-W51
+	}
+	tests := []struct {
+		desc     string
+		policy   *RetentionPolicy
+		expected []bool
+	}{
+		{
+			desc:     "keep last",
+			policy:   &RetentionPolicy{KeepLast: 3},
+			expected: []bool{false, false, false, false, false, true, true, true},
+		},
+		{
+			desc:     "keep all",
+			policy:   &RetentionPolicy{KeepLast: 10},
+			expected: []bool{true, true, true, true, true, true, true, true},
+		},
+		{
+			desc:     "keep within",
+			policy:   &RetentionPolicy{KeepLast: 1, KeepWithin: 7 * 24 * time.Hour},
+			expected: []bool{false, false, false, false, false, true, true, true},
+		},
+		{
+			desc:     "keep weekly",
+			policy:   &RetentionPolicy{KeepLast: 1, KeepWeeklyWithin: 91 * 24 * time.Hour},
+			expected: []bool{false, false, true, false, true, true, false, true},
+		},
+		{
+			desc:     "keep nothing",
+			policy:   &RetentionPolicy{},
+			expected: []bool{false, false, false, false, false, false, false, false},
+		},
+	}
+	for _, tc := range tests {
+		rets := tc.policy.Apply(timestamps, nil, now)
+
+		got := make([]bool, 0, len(rets))
+		for i, ret := range rets {
+			if ret.Timestamp != timestamps[i] {
+				t.Errorf("desc: %s, got %q, want %q", tc.desc, ret.Timestamp, timestamps[i])
+			}
+			if ret.Reason == "" {
+				t.Errorf("desc: %s, reason of %q should not be empty", tc.desc, ret.Timestamp)
+			}
+			got = append(got, ret.Keep)
+		}
+		if diff := pretty.Compare(got, tc.expected); diff != "" {
+			t.Errorf("desc: %s, diff: (-actual +expected)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestRetentionPolicyApply_reason(t *testing.T) {
+	now := time.Date(2017, 12, 20, 0, 0, 0, 0, time.UTC)
+	policy := &RetentionPolicy{KeepLast: 1, KeepWithin: 48 * time.Hour, KeepWeeklyWithin: 30 * 24 * time.Hour}
+
+	rets := policy.Apply([]string{"20171001000000", "20171201000000", "20171218120000", "20171219000000", "invalid", "latest"}, nil, now)
+
+	expected := []*Retention{
+		{Timestamp: "20171001000000", Keep: false, Reason: "not kept by the retention policy"},
+		{Timestamp: "20171201000000", Keep: true, Reason: "the latest release of the week 2017-W48 within 720h0m0s"},
+		{Timestamp: "20171218120000", Keep: true, Reason: "newer than 48h0m0s"},
+		{Timestamp: "20171219000000", Keep: true, Reason: "newer than 48h0m0s"},
+		{Timestamp: "invalid", Keep: true, Reason: "invalid timestamp"},
+		{Timestamp: "latest", Keep: true, Reason: "within the last 1 releases"},
+	}
+	if diff := pretty.Compare(rets, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
+
+func TestRetentionPolicyApply_metas(t *testing.T) {
+	now := time.Date(2017, 12, 20, 0, 0, 0, 0, time.UTC)
+	policy := &RetentionPolicy{KeepLast: 1, KeepWeeklyWithin: 30 * 24 * time.Hour}
+	yanked := &Yank{Reason: "broken", Timestamp: "20171219120000"}
+	metas := map[string]*Meta{
+		"20171001000000": {Tags: []string{"v1.0.0"}},
+		"20171002000000": {Channels: []string{"stable"}},
+		"20171003000000": {Channels: []string{"stable"}, Yanked: yanked},
+		"20171004000000": {},
+		"20171218000000": {Channels: []string{"beta"}},
+		"20171219000000": {Yanked: yanked},
+	}
+
+	rets := policy.Apply([]string{
+		"20171001000000", "20171002000000", "20171003000000", "20171004000000", "20171218000000", "20171219000000",
+	}, metas, now)
+
+	expected := []*Retention{
+		{Timestamp: "20171001000000", Keep: true, Reason: "tagged v1.0.0"},
+		{Timestamp: "20171002000000", Keep: true, Reason: "the latest release of the channel stable"},
+		{Timestamp: "20171003000000", Keep: false, Reason: "not kept by the retention policy"},
+		{Timestamp: "20171004000000", Keep: false, Reason: "not kept by the retention policy"},
+		{Timestamp: "20171218000000", Keep: true, Reason: "within the last 1 releases"},
+		{Timestamp: "20171219000000", Keep: false, Reason: "not kept by the retention policy"},
+	}
+	if diff := pretty.Compare(rets, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
@@ -0,0 +1,218 @@
+// Package selfupdate updates the running executable to the binary of the
+// release in the binrep repository.
+package selfupdate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/binrep"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+// Options represents the options of Update.
+type Options struct {
+	// Name is the name(<host>/<user>/<project>) of the project.
+	Name string
+	// Timestamp is the timestamp of the release, which is the latest release
+	// if it is empty.
+	Timestamp string
+	// Channel is the channel such as 'stable' whose latest release is
+	// resolved instead of the latest release of the project.
+	Channel string
+	// Executable is the path of the executable to update, which is the
+	// running executable if it is empty.
+	Executable string
+	// BinaryName is the name of the binary, which is the base name of
+	// os.Args[0] if it is empty.
+	BinaryName string
+	// GOOS and GOARCH are the platform of the binary, which are the running
+	// platform if they are empty.
+	GOOS   string
+	GOARCH string
+	// Identities is the private keys to decrypt the encrypted releases.
+	Identities []*release.Identity
+	// DryRun resolves the binary without replacing the executable.
+	DryRun bool
+}
+
+// Result represents the result of Update.
+type Result struct {
+	// Release is the resolved release.
+	Release *release.Release
+	// Binary is the binary of the release chosen for the executable.
+	Binary *release.Binary
+	// Executable is the path of the executable.
+	Executable string
+	// Checksum is the checksum of the executable before updating.
+	Checksum string
+	// Updated is whether the executable is replaced or not. It is false if
+	// the executable has the same checksum as the binary.
+	Updated bool
+}
+
+// Update resolves the release of opts.Name, chooses the binary for the
+// executable by the name and the platform, and replaces the executable with
+// it atomically after validating the checksum. The releases are not signed,
+// so the binary is trusted as far as the storage is.
+func Update(ctx context.Context, client *binrep.Client, opts *Options) (*Result, error) {
+	if opts.Timestamp != "" && opts.Channel != "" {
+		return nil, errors.New("both the timestamp and the channel are specified")
+	}
+	exe, err := executable(opts.Executable)
+	if err != nil {
+		return nil, err
+	}
+	binName := opts.BinaryName
+	if binName == "" {
+		binName = filepath.Base(os.Args[0])
+	}
+	goos, goarch := opts.GOOS, opts.GOARCH
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+
+	rel, err := resolve(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	bin, err := ChooseBinary(rel, binName, goos, goarch)
+	if err != nil {
+		return nil, err
+	}
+	current, err := checksum(exe)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Release: rel, Binary: bin, Executable: exe, Checksum: current}
+	if current == bin.Checksum || opts.DryRun {
+		return result, nil
+	}
+
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %q", exe)
+	}
+	// Create the temporary file in the same directory to rename it atomically.
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".binrep-*-"+filepath.Base(exe))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create temporary file for %v", exe)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	_, err = client.Download(ctx, opts.Name, bin.Name, tmp, &binrep.PullOptions{
+		Timestamp:  rel.Timestamp(),
+		Identities: opts.Identities,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return nil, errors.Wrapf(err, "failed to chmod %v", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, errors.Wrapf(err, "failed to sync %v", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, errors.Wrapf(err, "failed to close %v", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return nil, errors.Wrapf(err, "failed to replace %v", exe)
+	}
+	result.Updated = true
+	return result, nil
+}
+
+// resolve resolves the release of the timestamp or the channel of opts.
+func resolve(ctx context.Context, client *binrep.Client, opts *Options) (*release.Release, error) {
+	if opts.Channel == "" {
+		return client.Resolve(ctx, opts.Name, opts.Timestamp)
+	}
+	rels, err := client.List(ctx, opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(rels) - 1; i >= 0; i-- {
+		if !rels[i].Meta.IsYanked() && rels[i].Meta.HasChannel(opts.Channel) {
+			return rels[i], nil
+		}
+	}
+	return nil, errors.Wrapf(storage.ErrReleaseNotFound, "no release of the channel %q in %s", opts.Channel, opts.Name)
+}
+
+// executable returns the path of the executable resolving the symbolic links,
+// so that the link is not replaced with the binary.
+func executable(path string) (string, error) {
+	if path == "" {
+		var err error
+		path, err = os.Executable()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get the executable")
+		}
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %q", path)
+	}
+	return resolved, nil
+}
+
+// checksum returns the checksum of the file of path.
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open %v", path)
+	}
+	defer f.Close()
+	bin, err := release.BuildBinary(filepath.Base(path), 0, f)
+	if err != nil {
+		return "", err
+	}
+	return bin.Checksum, nil
+}
+
+// ChooseBinary chooses the binary of the release for the platform by the
+// name. The platform-specific names are preferred in the order:
+//
+//   - <goos>_<goarch>/<name> or <goos>-<goarch>/<name>
+//   - <name>_<goos>_<goarch> or <name>-<goos>-<goarch>
+//   - <name>
+//
+// The ELF binaries for the other architectures are skipped.
+func ChooseBinary(rel *release.Release, name, goos, goarch string) (*release.Binary, error) {
+	ext := ""
+	if goos == "windows" {
+		ext = ".exe"
+		name = strings.TrimSuffix(name, ext)
+	}
+	candidates := []string{
+		goos + "_" + goarch + "/" + name + ext,
+		goos + "-" + goarch + "/" + name + ext,
+		name + "_" + goos + "_" + goarch + ext,
+		name + "-" + goos + "-" + goarch + ext,
+		name + ext,
+	}
+	for _, candidate := range candidates {
+		for _, bin := range rel.Meta.Binaries {
+			if bin.Name != candidate || bin.IsLink() {
+				continue
+			}
+			if bin.ELF != nil && (goos == "darwin" || goos == "windows" || bin.ELF.Arch != goarch) {
+				continue
+			}
+			return bin, nil
+		}
+	}
+	return nil, errors.Errorf("no binary %q for %s/%s in %s", name+ext, goos, goarch, rel.URL)
+}
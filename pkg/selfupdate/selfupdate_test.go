@@ -0,0 +1,201 @@
+package selfupdate
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yuuki/binrep/pkg/binrep"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+func TestChooseBinary(t *testing.T) {
+	u, _ := url.Parse("mem://binrep-testing/github.com/yuuki/droot/20171017152508")
+	rel := release.New(&release.Meta{Binaries: []*release.Binary{
+		{Name: "droot", ELF: &release.ELF{Arch: "amd64"}},
+		{Name: "droot_linux_arm64", ELF: &release.ELF{Arch: "arm64"}},
+		{Name: "darwin_amd64/droot"},
+		{Name: "droot-windows-amd64.exe"},
+		{Name: "link", Link: "droot"},
+		{Name: "mips64/droot", ELF: &release.ELF{Arch: "mips"}},
+		{Name: "droot_linux_ppc64le", ELF: &release.ELF{Arch: "ppc64le"}},
+	}}, u)
+
+	tests := []struct {
+		name     string
+		goos     string
+		goarch   string
+		expected string
+		isErr    bool
+	}{
+		{name: "droot", goos: "linux", goarch: "amd64", expected: "droot"},
+		{name: "droot", goos: "linux", goarch: "arm64", expected: "droot_linux_arm64"},
+		{name: "droot", goos: "darwin", goarch: "amd64", expected: "darwin_amd64/droot"},
+		{name: "droot.exe", goos: "windows", goarch: "amd64", expected: "droot-windows-amd64.exe"},
+		{name: "droot", goos: "darwin", goarch: "arm64", isErr: true},
+		{name: "droot", goos: "linux", goarch: "ppc64le", expected: "droot_linux_ppc64le"},
+		{name: "droot", goos: "linux", goarch: "386", isErr: true},
+		{name: "link", goos: "linux", goarch: "amd64", isErr: true},
+	}
+	for _, tc := range tests {
+		bin, err := ChooseBinary(rel, tc.name, tc.goos, tc.goarch)
+		if tc.isErr {
+			if err == nil {
+				t.Errorf("name: %s, platform: %s/%s, should raise error", tc.name, tc.goos, tc.goarch)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("name: %s, platform: %s/%s, should not raise error: %s", tc.name, tc.goos, tc.goarch, err)
+			continue
+		}
+		if bin.Name != tc.expected {
+			t.Errorf("name: %s, platform: %s/%s, got %q, want %q", tc.name, tc.goos, tc.goarch, bin.Name, tc.expected)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binrep-selfupdate")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, body := range map[string]string{"tool_linux_amd64": "new-body", "tool_linux_arm64": "arm-body"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0755); err != nil {
+			panic(err)
+		}
+	}
+	ctx := context.Background()
+	client := binrep.New(storage.NewMemory())
+	_, err = client.Push(ctx, "github.com/yuuki/tool", []string{filepath.Join(dir, "tool_linux_amd64"), filepath.Join(dir, "tool_linux_arm64")}, nil)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	exe := filepath.Join(dir, "bin", "tool")
+	if err := os.Mkdir(filepath.Dir(exe), 0755); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(exe, []byte("old-body"), 0700); err != nil {
+		panic(err)
+	}
+	link := filepath.Join(dir, "tool-link")
+	if err := os.Symlink(exe, link); err != nil {
+		panic(err)
+	}
+	opts := &Options{Name: "github.com/yuuki/tool", Executable: link, BinaryName: "tool", GOOS: "linux", GOARCH: "amd64"}
+
+	tests := []struct {
+		desc     string
+		dryRun   bool
+		updated  bool
+		expected string
+	}{
+		{desc: "dry run", dryRun: true, updated: false, expected: "old-body"},
+		{desc: "update", updated: true, expected: "new-body"},
+		{desc: "up to date", updated: false, expected: "new-body"},
+	}
+	for _, tc := range tests {
+		opts.DryRun = tc.dryRun
+
+		result, err := Update(ctx, client, opts)
+
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		if result.Updated != tc.updated || result.Binary.Name != "tool_linux_amd64" || result.Executable != exe {
+			t.Errorf("desc: %s, got %+v", tc.desc, result)
+		}
+		body, err := ioutil.ReadFile(exe)
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		if string(body) != tc.expected {
+			t.Errorf("desc: %s, got %q, want %q", tc.desc, body, tc.expected)
+		}
+		fi, err := os.Stat(exe)
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		if fi.Mode().Perm() != 0700 {
+			t.Errorf("desc: %s, the mode should be kept, got %s", tc.desc, fi.Mode())
+		}
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("the link to the executable should be kept")
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(exe))
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("the temporary file should be removed, got %d files", len(files))
+	}
+}
+
+func TestUpdate_channel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binrep-selfupdate")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	client := binrep.New(storage.NewMemory())
+	for _, r := range []struct {
+		timestamp, body string
+		channels        []string
+	}{
+		{timestamp: "20171017152508", body: "stable-body", channels: []string{"stable"}},
+		{timestamp: "20171018152508", body: "yanked-body", channels: []string{"stable"}},
+		{timestamp: "20171019152508", body: "beta-body", channels: []string{"beta"}},
+	} {
+		path := filepath.Join(dir, "tool")
+		if err := ioutil.WriteFile(path, []byte(r.body), 0755); err != nil {
+			panic(err)
+		}
+		_, err := client.Push(ctx, "github.com/yuuki/tool", []string{path}, &binrep.PushOptions{
+			Timestamp: r.timestamp, Channels: r.channels,
+		})
+		if err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+	}
+	if err := client.Storage.YankRelease(ctx, "github.com/yuuki/tool", "20171018152508", "broken"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	tests := []struct {
+		desc      string
+		channel   string
+		timestamp string
+		expected  string
+		isErr     bool
+	}{
+		{desc: "stable", channel: "stable", expected: "20171017152508"},
+		{desc: "beta", channel: "beta", expected: "20171019152508"},
+		{desc: "missing channel", channel: "nightly", isErr: true},
+		{desc: "both timestamp and channel", channel: "stable", timestamp: "20171017152508", isErr: true},
+	}
+	for _, tc := range tests {
+		result, err := Update(ctx, client, &Options{
+			Name: "github.com/yuuki/tool", Timestamp: tc.timestamp, Channel: tc.channel,
+			Executable: filepath.Join(dir, "tool"), BinaryName: "tool", GOOS: "linux", GOARCH: "amd64", DryRun: true,
+		})
+		if tc.isErr {
+			if err == nil {
+				t.Errorf("desc: %s, should raise error", tc.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		if result.Release.Timestamp() != tc.expected {
+			t.Errorf("desc: %s, got %s, want %s", tc.desc, result.Release.Timestamp(), tc.expected)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+// Package server serves the repository read-only over HTTP, so that the
+// hosts without the credentials of the storage pull the releases with the
+// plain HTTP clients such as curl.
+//
+// The paths are below, where <name> is <host>/<user>/<project> and
+// <timestamp> may be `latest` to be redirected to the latest release.
+//
+//	GET /projects                                   the names of the projects
+//	GET /projects/<name>                            the releases of the project
+//	GET /projects/<name>/<timestamp>                the meta of the release
+//	GET /projects/<name>/<timestamp>/<binary name>  the body of the binary
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/binrep"
+	"github.com/yuuki/binrep/pkg/logging"
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+const (
+	projectsPath = "/projects"
+	latest       = "latest"
+
+	// DefaultCacheSize is the default upper bound of the bytes of the cache.
+	DefaultCacheSize = 1 << 30
+)
+
+// Server is the http.Handler of the repository. The binaries are downloaded
+// and validated by their checksums into the cache before being served, so
+// that the range requests are served from the cache.
+type Server struct {
+	Client *binrep.Client
+	// CacheDir is the directory of the cached binaries named by their
+	// checksums.
+	CacheDir string
+	// CacheSize is the upper bound of the bytes of the cache, over which the
+	// least recently served binaries are removed.
+	CacheSize int64
+	// Identities is the private keys to decrypt the encrypted releases,
+	// which are forbidden without them.
+	Identities []*release.Identity
+	// Logger receives the access logs. It is nil not to log anything.
+	Logger *slog.Logger
+
+	cacheMu sync.Mutex
+	// downloads is the downloads in flight by the checksums, which the
+	// concurrent requests of the same binary wait for.
+	downloadMu sync.Mutex
+	downloads  map[string]*download
+}
+
+// download represents the download of a binary into the cache.
+type download struct {
+	done chan struct{}
+	// err is the error of the download, which is set before done is closed.
+	err error
+}
+
+// New creates the server of st caching the binaries into cacheDir.
+func New(st storage.API, cacheDir string) *Server {
+	return &Server{Client: binrep.New(st), CacheDir: cacheDir, CacheSize: DefaultCacheSize}
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return logging.Discard()
+	}
+	return s.Logger
+}
+
+// statusWriter records the status of the response for the access logs.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ServeHTTP serves the request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	s.serve(sw, r)
+	s.logger().Info("Served the request", "method", r.Method, "path", r.URL.Path, "status", sw.status,
+		"remote_addr", r.RemoteAddr, logging.KeyDuration, time.Since(start))
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	if p == projectsPath {
+		s.serveProjects(w, r)
+		return
+	}
+	if !strings.HasPrefix(p, projectsPath+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	name, timestamp, binName := parsePath(strings.TrimPrefix(p, projectsPath+"/"))
+	switch {
+	case name == "":
+		http.NotFound(w, r)
+	case timestamp == "":
+		s.serveReleases(w, r, name)
+	case timestamp == latest:
+		s.redirectLatest(w, r, name, binName)
+	case binName == "":
+		s.serveMeta(w, r, name, timestamp)
+	default:
+		s.serveBinary(w, r, name, timestamp, binName)
+	}
+}
+
+// parsePath parses the path such as
+// `github.com/yuuki/droot/20171017152508/bin/droot` into the name, the
+// timestamp or `latest`, and the binary name. The name is the segments
+// before the first timestamp, and is empty if it is too short.
+func parsePath(p string) (name, timestamp, binName string) {
+	segs := strings.Split(p, "/")
+	i := 0
+	for i < len(segs) && segs[i] != latest {
+		if _, err := release.ParseTimestamp(segs[i]); err == nil {
+			break
+		}
+		i++
+	}
+	if i < 3 {
+		return "", "", ""
+	}
+	name = strings.Join(segs[:i], "/")
+	if i < len(segs) {
+		timestamp = segs[i]
+		binName = strings.Join(segs[i+1:], "/")
+	}
+	return name, timestamp, binName
+}
+
+// releasePath returns the path of the release, or of its binary if binName
+// is not empty.
+func releasePath(name, timestamp, binName string) string {
+	p := path.Join(projectsPath, name, timestamp)
+	if binName != "" {
+		p = path.Join(p, binName)
+	}
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// writeError writes the status of err.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrProjectNotFound), errors.Is(err, storage.ErrReleaseNotFound):
+		status = http.StatusNotFound
+	case release.IsNoIdentityError(err):
+		status = http.StatusForbidden
+	case errors.Is(err, release.ErrChecksumMismatch):
+		status = http.StatusBadGateway
+	case errors.Is(err, storage.ErrTransient):
+		status = http.StatusServiceUnavailable
+	case r.Context().Err() != nil:
+		// The client has gone away.
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		s.logger().Error("Failed to serve the request", "path", r.URL.Path, "error", err)
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// writeJSON writes v as JSON with the ETag of its hash, so that the
+// request with If-None-Match of the unchanged JSON gets 304.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data = append(data, '\n')
+	sum := sha256.Sum256(data)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// releaseJSON is the JSON of the release.
+type releaseJSON struct {
+	Project   string        `json:"project"`
+	Timestamp string        `json:"timestamp"`
+	URL       string        `json:"url"`
+	Yanked    *yankJSON     `json:"yanked,omitempty"`
+	Encrypted bool          `json:"encrypted,omitempty"`
+	Binaries  []*binaryJSON `json:"binaries"`
+}
+
+type yankJSON struct {
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp"`
+}
+
+type binaryJSON struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum,omitempty"`
+	Mode     string `json:"mode"`
+	Size     int64  `json:"size,omitempty"`
+	Link     string `json:"link,omitempty"`
+	URL      string `json:"url"`
+}
+
+func newReleaseJSON(rel *release.Release) *releaseJSON {
+	v := &releaseJSON{
+		Project:   rel.Name(),
+		Timestamp: rel.Timestamp(),
+		URL:       releasePath(rel.Name(), rel.Timestamp(), ""),
+		Encrypted: rel.Meta.Encryption != nil,
+		Binaries:  make([]*binaryJSON, 0, len(rel.Meta.Binaries)),
+	}
+	if y := rel.Meta.Yanked; y != nil {
+		v.Yanked = &yankJSON{Reason: y.Reason, Timestamp: y.Timestamp}
+	}
+	for _, bin := range rel.Meta.Binaries {
+		v.Binaries = append(v.Binaries, &binaryJSON{
+			Name:     bin.Name,
+			Checksum: bin.Checksum,
+			Mode:     fmt.Sprintf("%04o", bin.Mode.Perm()),
+			Size:     bin.Size,
+			Link:     bin.Link,
+			URL:      releasePath(rel.Name(), rel.Timestamp(), bin.Name),
+		})
+	}
+	return v
+}
+
+// serveProjects serves the names of the projects by listing the storage
+// without reading the releases.
+func (s *Server) serveProjects(w http.ResponseWriter, r *http.Request) {
+	names, err := s.Client.Storage.ProjectNames(r.Context())
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	sort.Strings(names)
+	writeJSON(w, r, names)
+}
+
+// serveReleases serves the releases of the project by their metas without
+// opening the bodies of the binaries.
+func (s *Server) serveReleases(w http.ResponseWriter, r *http.Request, name string) {
+	rels, err := s.Client.List(r.Context(), name)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if len(rels) == 0 {
+		http.Error(w, fmt.Sprintf("no such projects %s", name), http.StatusNotFound)
+		return
+	}
+	vs := make([]*releaseJSON, 0, len(rels))
+	for _, rel := range rels {
+		vs = append(vs, newReleaseJSON(rel))
+	}
+	writeJSON(w, r, vs)
+}
+
+// redirectLatest redirects to the latest release which is not yanked, or to
+// the binary of it.
+func (s *Server) redirectLatest(w http.ResponseWriter, r *http.Request, name, binName string) {
+	rel, err := s.Client.Resolve(r.Context(), name, "")
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, releasePath(name, rel.Timestamp(), binName), http.StatusFound)
+}
+
+func (s *Server) serveMeta(w http.ResponseWriter, r *http.Request, name, timestamp string) {
+	rel, err := s.Client.Resolve(r.Context(), name, timestamp)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, newReleaseJSON(rel))
+}
+
+// serveBinary serves the plaintext of the binary from the cache with the
+// ETag of its checksum. The release is resolved by its meta, and the body is
+// opened only on the cache miss. The links are redirected to their targets.
+func (s *Server) serveBinary(w http.ResponseWriter, r *http.Request, name, timestamp, binName string) {
+	ctx := r.Context()
+	rel, err := s.Client.Resolve(ctx, name, timestamp)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	var bin *release.Binary
+	for _, b := range rel.Meta.Binaries {
+		if b.Name == binName {
+			bin = b
+		}
+	}
+	if bin == nil {
+		http.Error(w, fmt.Sprintf("no such binary %q in %s", binName, rel.URL), http.StatusNotFound)
+		return
+	}
+	if err := bin.Validate(); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if bin.IsLink() {
+		http.Redirect(w, r, releasePath(name, timestamp, path.Join(path.Dir(bin.Name), bin.Link)), http.StatusFound)
+		return
+	}
+
+	f, err := s.openCache(ctx, rel, bin)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	defer f.Close()
+	modTime, _ := release.ParseTimestamp(timestamp)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+bin.Checksum+`"`)
+	// The body of the timestamp never changes.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(bin.Name)))
+	http.ServeContent(w, r, "", modTime, f)
+}
+
+// openCache opens the cached plaintext of the binary, downloading it into
+// the cache if it is missing. The concurrent requests of the same checksum
+// wait for the download of the first one instead of downloading it again.
+func (s *Server) openCache(ctx context.Context, rel *release.Release, bin *release.Binary) (*os.File, error) {
+	// The checksum is the file name within the cache.
+	if sum, err := hex.DecodeString(bin.Checksum); err != nil || len(sum) != sha256.Size {
+		return nil, errors.Errorf("invalid checksum %q of %s", bin.Checksum, bin.Name)
+	}
+	cached := filepath.Join(s.CacheDir, bin.Checksum)
+	for {
+		if f, err := os.Open(cached); err == nil {
+			// Mark as recently served for the eviction.
+			now := time.Now()
+			os.Chtimes(cached, now, now)
+			return f, nil
+		}
+
+		d, first := s.startDownload(bin.Checksum)
+		if first {
+			d.err = s.download(ctx, rel, bin, cached)
+			s.finishDownload(bin.Checksum, d)
+			if d.err != nil {
+				return nil, d.err
+			}
+			break
+		}
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// Download it again if the client of the first request has gone away.
+		if errors.Is(d.err, context.Canceled) || errors.Is(d.err, context.DeadlineExceeded) {
+			continue
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		break
+	}
+	f, err := os.Open(cached)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open the cache of %s", bin.Name)
+	}
+	return f, nil
+}
+
+// startDownload returns the download in flight of the checksum, or starts
+// the new one to which first is true.
+func (s *Server) startDownload(checksum string) (d *download, first bool) {
+	s.downloadMu.Lock()
+	defer s.downloadMu.Unlock()
+	if d, ok := s.downloads[checksum]; ok {
+		return d, false
+	}
+	if s.downloads == nil {
+		s.downloads = map[string]*download{}
+	}
+	d = &download{done: make(chan struct{})}
+	s.downloads[checksum] = d
+	return d, true
+}
+
+// finishDownload notifies the waiters of the download of the checksum.
+func (s *Server) finishDownload(checksum string, d *download) {
+	s.downloadMu.Lock()
+	delete(s.downloads, checksum)
+	s.downloadMu.Unlock()
+	close(d.done)
+}
+
+// download downloads the plaintext of the binary into the cache.
+func (s *Server) download(ctx context.Context, rel *release.Release, bin *release.Binary, cached string) error {
+	if err := os.MkdirAll(s.CacheDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create the cache directory %s", s.CacheDir)
+	}
+	tmp, err := ioutil.TempFile(s.CacheDir, ".download-*")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create the temporary file in %s", s.CacheDir)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	opts := &binrep.PullOptions{Timestamp: rel.Timestamp(), Identities: s.Identities}
+	if _, err := s.Client.Download(ctx, rel.Name(), bin.Name, tmp, opts); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return errors.Wrapf(err, "failed to cache %s", bin.Name)
+	}
+	s.evict(cached)
+	return nil
+}
+
+// evict removes the least recently served binaries other than keep until
+// the cache is within CacheSize. The opened binaries are still served after
+// they are removed.
+func (s *Server) evict(keep string) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	fis, err := ioutil.ReadDir(s.CacheDir)
+	if err != nil {
+		s.logger().Warn("Failed to read the cache directory", "error", err)
+		return
+	}
+	var (
+		cached []os.FileInfo
+		total  int64
+	)
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), ".") {
+			cached = append(cached, fi)
+			total += fi.Size()
+		}
+	}
+	sort.Slice(cached, func(i, j int) bool { return cached[i].ModTime().Before(cached[j].ModTime()) })
+	for _, fi := range cached {
+		if total <= s.CacheSize {
+			return
+		}
+		p := filepath.Join(s.CacheDir, fi.Name())
+		if p == keep {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			s.logger().Warn("Failed to remove the cache", "path", p, "error", err)
+			continue
+		}
+		total -= fi.Size()
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuuki/binrep/pkg/release"
+	"github.com/yuuki/binrep/pkg/storage"
+)
+
+func createRelease(t *testing.T, st storage.API, name, timestamp string, bins ...*release.Binary) {
+	if _, err := st.CreateRelease(context.Background(), name, timestamp, release.NewMeta(bins)); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+}
+
+func buildBinary(t *testing.T, name, body string) *release.Binary {
+	bin, err := release.BuildBinary(name, 0755, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	return bin
+}
+
+// setupServer starts the server of the releases of github.com/yuuki/droot,
+// whose latest release 20171018000000 is yanked.
+func setupServer(t *testing.T) (*httptest.Server, *Server, func()) {
+	dir, err := ioutil.TempDir("", "binrep-serve")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	m := storage.NewMemory()
+	name := "github.com/yuuki/droot"
+	for _, ts := range []string{"20171017152508", "20171017152626", "20171018000000"} {
+		createRelease(t, m, name, ts, buildBinary(t, "droot", "droot-"+ts), &release.Binary{Name: "bin/droot", Link: "../droot"})
+	}
+	createRelease(t, m, "github.com/yuuki/grabeni", "20171017152508", buildBinary(t, "grabeni", "grabeni-body"))
+	if err := m.YankRelease(context.Background(), name, "20171018000000", "broken"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	s := New(m, dir)
+	srv := httptest.NewServer(s)
+	return srv, s, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// get requests the path without following the redirects.
+func get(t *testing.T, method, url string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	return resp, string(body)
+}
+
+func TestServer(t *testing.T) {
+	srv, _, teardown := setupServer(t)
+	defer teardown()
+	checksum := buildBinary(t, "droot", "droot-20171017152508").Checksum
+
+	tests := []struct {
+		desc             string
+		method           string
+		path             string
+		header           map[string]string
+		expectedStatus   int
+		expectedSubBody  string
+		expectedLocation string
+	}{
+		{desc: "projects", path: "/projects", expectedStatus: 200, expectedSubBody: `"github.com/yuuki/grabeni"`},
+		{desc: "releases", path: "/projects/github.com/yuuki/droot/", expectedStatus: 200, expectedSubBody: `"timestamp": "20171017152626"`},
+		{desc: "meta", path: "/projects/github.com/yuuki/droot/20171018000000", expectedStatus: 200, expectedSubBody: `"reason": "broken"`},
+		{desc: "binary", path: "/projects/github.com/yuuki/droot/20171017152508/droot", expectedStatus: 200, expectedSubBody: "droot-20171017152508"},
+		{
+			desc:            "range",
+			path:            "/projects/github.com/yuuki/droot/20171017152508/droot",
+			header:          map[string]string{"Range": "bytes=6-"},
+			expectedStatus:  206,
+			expectedSubBody: "20171017152508",
+		},
+		{
+			desc:           "not modified",
+			path:           "/projects/github.com/yuuki/droot/20171017152508/droot",
+			header:         map[string]string{"If-None-Match": `"` + checksum + `"`},
+			expectedStatus: 304,
+		},
+		{
+			desc:             "latest without the yanked release",
+			path:             "/projects/github.com/yuuki/droot/latest",
+			expectedStatus:   302,
+			expectedLocation: "/projects/github.com/yuuki/droot/20171017152626",
+		},
+		{
+			desc:             "latest binary",
+			path:             "/projects/github.com/yuuki/droot/latest/bin/droot",
+			expectedStatus:   302,
+			expectedLocation: "/projects/github.com/yuuki/droot/20171017152626/bin/droot",
+		},
+		{
+			desc:             "link",
+			path:             "/projects/github.com/yuuki/droot/20171017152626/bin/droot",
+			expectedStatus:   302,
+			expectedLocation: "/projects/github.com/yuuki/droot/20171017152626/droot",
+		},
+		{desc: "project not found", path: "/projects/github.com/yuuki/ghq/latest", expectedStatus: 404},
+		{desc: "releases not found", path: "/projects/github.com/yuuki/ghq", expectedStatus: 404},
+		{desc: "release not found", path: "/projects/github.com/yuuki/droot/20171017000000", expectedStatus: 404},
+		{desc: "binary not found", path: "/projects/github.com/yuuki/droot/20171017152508/grabeni", expectedStatus: 404},
+		{desc: "too short name", path: "/projects/github.com/20171017152508/droot", expectedStatus: 404},
+		{desc: "unknown path", path: "/", expectedStatus: 404},
+		{desc: "read-only", method: http.MethodDelete, path: "/projects/github.com/yuuki/droot/20171017152508", expectedStatus: 405},
+	}
+	for _, tc := range tests {
+		method := tc.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		resp, body := get(t, method, srv.URL+tc.path, tc.header)
+		if resp.StatusCode != tc.expectedStatus {
+			t.Errorf("desc: %s, got status %d, want %d: %s", tc.desc, resp.StatusCode, tc.expectedStatus, body)
+		}
+		if !strings.Contains(body, tc.expectedSubBody) {
+			t.Errorf("desc: %s, got %q, want to contain %q", tc.desc, body, tc.expectedSubBody)
+		}
+		if loc := resp.Header.Get("Location"); loc != tc.expectedLocation {
+			t.Errorf("desc: %s, got location %q, want %q", tc.desc, loc, tc.expectedLocation)
+		}
+	}
+}
+
+func TestServer_jsonETag(t *testing.T) {
+	srv, _, teardown := setupServer(t)
+	defer teardown()
+	url := srv.URL + "/projects/github.com/yuuki/droot/20171017152508"
+
+	resp, _ := get(t, http.MethodGet, url, nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("ETag should be set")
+	}
+	resp, _ = get(t, http.MethodGet, url, map[string]string{"If-None-Match": etag})
+
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotModified)
+	}
+}
+
+func TestServer_evict(t *testing.T) {
+	srv, s, teardown := setupServer(t)
+	defer teardown()
+	// Only one binary fits into the cache.
+	s.CacheSize = int64(len("droot-20171017152508"))
+
+	for _, ts := range []string{"20171017152508", "20171017152626"} {
+		resp, body := get(t, http.MethodGet, srv.URL+"/projects/github.com/yuuki/droot/"+ts+"/droot", nil)
+		if resp.StatusCode != http.StatusOK || body != "droot-"+ts {
+			t.Fatalf("timestamp: %s, got %d %q, want 200 %q", ts, resp.StatusCode, body, "droot-"+ts)
+		}
+	}
+
+	fis, err := ioutil.ReadDir(s.CacheDir)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expected := buildBinary(t, "droot", "droot-20171017152626").Checksum
+	if len(fis) != 1 || fis[0].Name() != expected {
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		t.Errorf("got %v, want only %s", names, expected)
+	}
+}
+
+// gatedStorage counts the releases found with their bodies, whose reads
+// block until gate is closed, and the releases resolved by their metas.
+type gatedStorage struct {
+	storage.API
+	gate chan struct{}
+
+	mu       sync.Mutex
+	finds    int
+	resolves int
+}
+
+type gatedReader struct {
+	io.Reader
+	gate chan struct{}
+}
+
+func (r *gatedReader) Read(p []byte) (int, error) {
+	<-r.gate
+	return r.Reader.Read(p)
+}
+
+func (s *gatedStorage) FindReleaseByTimestamp(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	rel, err := s.API.FindReleaseByTimestamp(ctx, name, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.finds++
+	s.mu.Unlock()
+	for _, bin := range rel.Meta.Binaries {
+		if bin.Body != nil {
+			bin.Body = &gatedReader{Reader: bin.Body, gate: s.gate}
+		}
+	}
+	return rel, nil
+}
+
+func (s *gatedStorage) FindReleaseMeta(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	s.mu.Lock()
+	s.resolves++
+	s.mu.Unlock()
+	return s.API.FindReleaseMeta(ctx, name, timestamp)
+}
+
+func (s *gatedStorage) counts() (finds, resolves int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finds, s.resolves
+}
+
+func TestServer_concurrentDownloads(t *testing.T) {
+	srv, s, teardown := setupServer(t)
+	defer teardown()
+	st := &gatedStorage{API: s.Client.Storage, gate: make(chan struct{})}
+	s.Client.Storage = st
+
+	const n = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, bodies[i] = get(t, http.MethodGet, srv.URL+"/projects/github.com/yuuki/droot/20171017152508/droot", nil)
+		}(i)
+	}
+	// Each request resolves the release, and only the first one downloads it.
+	deadline := time.Now().Add(5 * time.Second)
+	for finds, resolves := st.counts(); (finds < 1 || resolves < n) && time.Now().Before(deadline); finds, resolves = st.counts() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(st.gate)
+	wg.Wait()
+
+	if finds, resolves := st.counts(); finds != 1 || resolves != n {
+		t.Errorf("got %d finds and %d resolves, want 1 and %d", finds, resolves, n)
+	}
+	for i, body := range bodies {
+		if body != "droot-20171017152508" {
+			t.Errorf("request: %d, got %q", i, body)
+		}
+	}
+}
+
+func TestServer_cacheHit(t *testing.T) {
+	srv, s, teardown := setupServer(t)
+	defer teardown()
+	st := &gatedStorage{API: s.Client.Storage, gate: make(chan struct{})}
+	close(st.gate)
+	s.Client.Storage = st
+
+	for i := 0; i < 3; i++ {
+		resp, body := get(t, http.MethodGet, srv.URL+"/projects/github.com/yuuki/droot/20171017152508/droot", nil)
+		if resp.StatusCode != http.StatusOK || body != "droot-20171017152508" {
+			t.Fatalf("request: %d, got %d %q", i, resp.StatusCode, body)
+		}
+	}
+	get(t, http.MethodGet, srv.URL+"/projects/github.com/yuuki/droot/20171017152508", nil)
+	get(t, http.MethodGet, srv.URL+"/projects/github.com/yuuki/droot/latest/droot", nil)
+
+	// Only the cache miss opens the bodies.
+	if finds, resolves := st.counts(); finds != 1 || resolves != 5 {
+		t.Errorf("got %d finds and %d resolves, want 1 and 5", finds, resolves)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path                                      string
+		expectedName, expectedTS, expectedBinName string
+	}{
+		{"github.com/yuuki/droot", "github.com/yuuki/droot", "", ""},
+		{"github.com/yuuki/droot/latest", "github.com/yuuki/droot", "latest", ""},
+		{"github.com/yuuki/droot/20171017152508/bin/droot", "github.com/yuuki/droot", "20171017152508", "bin/droot"},
+		{"gitlab.example.com/group/subgroup/project/20171018000000", "gitlab.example.com/group/subgroup/project", "20171018000000", ""},
+		{"github.com/latest", "", "", ""},
+	}
+	for _, tc := range tests {
+		name, ts, binName := parsePath(tc.path)
+		if name != tc.expectedName || ts != tc.expectedTS || binName != tc.expectedBinName {
+			t.Errorf("path: %s, got (%q, %q, %q), want (%q, %q, %q)", tc.path, name, ts, binName, tc.expectedName, tc.expectedTS, tc.expectedBinName)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/user"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+// auditPrefix is the key prefix under which the audit records are stored,
+// which is not a valid name of the projects.
+const auditPrefix = "_audit/"
+
+// The actions of the audit records.
+const (
+	AuditCreate  = "create"
+	AuditDelete  = "delete"
+	AuditArchive = "archive"
+	AuditRestore = "restore"
+	AuditYank    = "yank"
+	AuditUnyank  = "unyank"
+	AuditPrune   = "prune"
+)
+
+// AuditRecord represents a mutation of the repository.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Action is one of the Audit* actions.
+	Action string `json:"action"`
+	// Actor is who mutated the repository, such as the IAM ARN.
+	Actor string `json:"actor"`
+	Host  string `json:"host"`
+	// Command is the command line such as `push github.com/yuuki/droot ./droot`.
+	Command   string `json:"command,omitempty"`
+	Project   string `json:"project"`
+	Timestamp string `json:"timestamp"`
+	// Checksums is the checksums of the binaries of the created release.
+	// The releases deleted later are identified by their timestamps.
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// Reason is the reason of the yank or of the prune.
+	Reason string `json:"reason,omitempty"`
+	// StorageClass is the storage class of the archived releases.
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+// AuditLog is implemented by the storages keeping the audit records. The
+// records are only appended, and never updated or deleted.
+type AuditLog interface {
+	AppendAudit(ctx context.Context, rec *AuditRecord) error
+	// WalkAudit walks the records at or after since in the order of the time.
+	WalkAudit(ctx context.Context, since time.Time, fn func(*AuditRecord) error) error
+}
+
+// CallerIdentifier is implemented by the storages which know the identity of
+// the caller, such as the IAM ARN of the credentials. It returns the empty
+// string if the identity is unknown.
+type CallerIdentifier interface {
+	CallerIdentity(ctx context.Context) (string, error)
+}
+
+// auditKey returns the key of the record such as
+// `_audit/2017/10/17/20171017T152508.000000000Z-1a2b3c4d.json`, which sorts
+// the records in the order of the time. The random suffix keeps the records
+// at the same time from overwriting each other.
+func auditKey(rec *AuditRecord) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errors.Wrap(err, "failed to generate the key of the audit record")
+	}
+	t := rec.Time.UTC()
+	return auditDayPrefix(t) + t.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".json", nil
+}
+
+// auditDayPrefix returns the prefix of the records of the day such as
+// `_audit/2017/10/17/`.
+func auditDayPrefix(t time.Time) string {
+	return auditPrefix + t.UTC().Format("2006/01/02") + "/"
+}
+
+func encodeAuditRecord(rec *AuditRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the audit record")
+	}
+	return data, nil
+}
+
+func decodeAuditRecord(key string, data []byte) (*AuditRecord, error) {
+	var rec AuditRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, errors.Wrapf(err, "failed to read the audit record %s", path.Base(key))
+	}
+	return &rec, nil
+}
+
+// Auditor represents who mutates the repository in the audit records.
+type Auditor struct {
+	// Actor is the caller identity of the storage, or the local user if it
+	// is empty.
+	Actor   string
+	Host    string
+	Command string
+}
+
+// NewAuditor creates the auditor of the command on this host.
+func NewAuditor(command string) *Auditor {
+	host, _ := os.Hostname()
+	return &Auditor{Host: host, Command: command}
+}
+
+type auditStorage struct {
+	API
+	log     AuditLog
+	auditor *Auditor
+
+	once   sync.Once
+	actor  string
+	logger *slog.Logger
+}
+
+var _ AuditLog = (*auditStorage)(nil)
+
+// WithAudit returns the storage appending the record of each mutation of st
+// into log, which is also the AuditLog of the storage. The actor is resolved
+// at the first mutation from log if it implements CallerIdentifier. The
+// failure to append the record is only logged as a warning, because the
+// mutation itself is done and must not be retried.
+func WithAudit(st API, log AuditLog, auditor *Auditor) API {
+	return &auditStorage{API: st, log: log, auditor: auditor}
+}
+
+// SetLogger sets the logger of the audit records failing to be appended and
+// of the wrapped storage.
+func (s *auditStorage) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+	SetLogger(s.API, logger)
+}
+
+func (s *auditStorage) resolveActor(ctx context.Context) string {
+	s.once.Do(func() {
+		s.actor = s.auditor.Actor
+		if s.actor != "" {
+			return
+		}
+		if ci, ok := s.log.(CallerIdentifier); ok {
+			actor, err := ci.CallerIdentity(ctx)
+			if err != nil {
+				loggerOf(s.logger).Warn("Failed to get the caller identity, use the local user instead", "error", err)
+			} else if actor != "" {
+				s.actor = actor
+				return
+			}
+		}
+		s.actor = localActor()
+	})
+	return s.actor
+}
+
+// localActor returns the name of the local user.
+func localActor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// record appends the record of the done mutation, and logs the failure as a
+// warning instead of failing the mutation.
+func (s *auditStorage) record(ctx context.Context, rec *AuditRecord) {
+	rec.Time = time.Now()
+	rec.Actor = s.resolveActor(ctx)
+	rec.Host = s.auditor.Host
+	rec.Command = s.auditor.Command
+	if err := s.log.AppendAudit(ctx, rec); err != nil {
+		loggerOf(s.logger).Warn("Failed to append the audit record", "action", rec.Action,
+			"project", rec.Project, "timestamp", rec.Timestamp, "error", err)
+	}
+}
+
+// CreateRelease creates the release, and records the checksums of the binaries.
+func (s *auditStorage) CreateRelease(ctx context.Context, name string, timestamp string, meta *release.Meta) (*release.Release, error) {
+	rel, err := s.API.CreateRelease(ctx, name, timestamp, meta)
+	if err != nil {
+		return nil, err
+	}
+	checksums := map[string]string{}
+	for _, bin := range meta.Binaries {
+		if !bin.IsLink() {
+			checksums[bin.Name] = bin.Checksum
+		}
+	}
+	s.record(ctx, &AuditRecord{Action: AuditCreate, Project: name, Timestamp: timestamp, Checksums: checksums})
+	return rel, nil
+}
+
+// DeleteRelease deletes the release.
+func (s *auditStorage) DeleteRelease(ctx context.Context, name, timestamp string) error {
+	if err := s.API.DeleteRelease(ctx, name, timestamp); err != nil {
+		return err
+	}
+	s.record(ctx, &AuditRecord{Action: AuditDelete, Project: name, Timestamp: timestamp})
+	return nil
+}
+
+// ArchiveRelease archives the release with the storage class.
+func (s *auditStorage) ArchiveRelease(ctx context.Context, name, timestamp, storageClass string) error {
+	if err := s.API.ArchiveRelease(ctx, name, timestamp, storageClass); err != nil {
+		return err
+	}
+	s.record(ctx, &AuditRecord{Action: AuditArchive, Project: name, Timestamp: timestamp, StorageClass: storageClass})
+	return nil
+}
+
+// RestoreRelease restores the archived release. Only the restoration which
+// brings the release back is recorded.
+func (s *auditStorage) RestoreRelease(ctx context.Context, name, timestamp string) (bool, error) {
+	ok, err := s.API.RestoreRelease(ctx, name, timestamp)
+	if err != nil || !ok {
+		return ok, err
+	}
+	s.record(ctx, &AuditRecord{Action: AuditRestore, Project: name, Timestamp: timestamp})
+	return true, nil
+}
+
+// YankRelease yanks the release with the reason.
+func (s *auditStorage) YankRelease(ctx context.Context, name, timestamp, reason string) error {
+	if err := s.API.YankRelease(ctx, name, timestamp, reason); err != nil {
+		return err
+	}
+	s.record(ctx, &AuditRecord{Action: AuditYank, Project: name, Timestamp: timestamp, Reason: reason})
+	return nil
+}
+
+// UnyankRelease restores the yanked release.
+func (s *auditStorage) UnyankRelease(ctx context.Context, name, timestamp string) error {
+	if err := s.API.UnyankRelease(ctx, name, timestamp); err != nil {
+		return err
+	}
+	s.record(ctx, &AuditRecord{Action: AuditUnyank, Project: name, Timestamp: timestamp})
+	return nil
+}
+
+// PruneReleases prunes the releases one by one, and records each pruned
+// release with the reason, so that the releases pruned before a failure are
+// also recorded.
+func (s *auditStorage) PruneReleases(ctx context.Context, name string, opts *PruneOptions) ([]*release.Retention, error) {
+	dryRun := *opts
+	dryRun.DryRun = true
+	rets, err := s.API.PruneReleases(ctx, name, &dryRun)
+	if err != nil || opts.DryRun {
+		return rets, err
+	}
+	err = deleteRetentions(ctx, s.API, name, rets, opts, func(ret *release.Retention) error {
+		s.record(ctx, &AuditRecord{
+			Action:       AuditPrune,
+			Project:      name,
+			Timestamp:    ret.Timestamp,
+			Reason:       ret.Reason,
+			StorageClass: opts.ArchiveStorageClass,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rets, nil
+}
+
+// AppendAudit appends the record into the audit log.
+func (s *auditStorage) AppendAudit(ctx context.Context, rec *AuditRecord) error {
+	return s.log.AppendAudit(ctx, rec)
+}
+
+// WalkAudit walks the records of the audit log.
+func (s *auditStorage) WalkAudit(ctx context.Context, since time.Time, fn func(*AuditRecord) error) error {
+	return s.log.WalkAudit(ctx, since, fn)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+func TestWithAudit(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	st := WithAudit(m, m, &Auditor{Actor: "arn:aws:iam::123456789012:user/yuuki", Host: "ci01", Command: "push"})
+	name := "github.com/yuuki/droot"
+	for _, ts := range []string{"20171017152508", "20171017152626", "20171018000000"} {
+		createConformanceRelease(t, st, name, ts, "droot-"+ts)
+	}
+	if err := st.YankRelease(ctx, name, "20171018000000", "broken"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if _, err := st.PruneReleases(ctx, name, &PruneOptions{Policy: &release.RetentionPolicy{KeepLast: 2}, DryRun: true}); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if _, err := st.PruneReleases(ctx, name, &PruneOptions{Policy: &release.RetentionPolicy{KeepLast: 2}}); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if err := st.DeleteRelease(ctx, name, "20171019000000"); err == nil {
+		t.Fatalf("should raise error")
+	}
+
+	var got []string
+	err := st.(AuditLog).WalkAudit(ctx, time.Time{}, func(rec *AuditRecord) error {
+		got = append(got, rec.Action+" "+rec.Timestamp)
+		if rec.Actor != "arn:aws:iam::123456789012:user/yuuki" || rec.Host != "ci01" || rec.Command != "push" || rec.Project != name {
+			t.Errorf("got %+v", rec)
+		}
+		switch rec.Action {
+		case AuditCreate:
+			if len(rec.Checksums) != 1 || rec.Checksums["bin0"] == "" {
+				t.Errorf("got checksums %v", rec.Checksums)
+			}
+		case AuditYank:
+			if rec.Reason != "broken" {
+				t.Errorf("got reason %q, want %q", rec.Reason, "broken")
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expected := []string{
+		"create 20171017152508",
+		"create 20171017152626",
+		"create 20171018000000",
+		"yank 20171018000000",
+		// The yanked release doesn't count toward the last 2 releases.
+		"prune 20171018000000",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+// failingAuditLog fails to append the records.
+type failingAuditLog struct {
+	AuditLog
+}
+
+func (failingAuditLog) AppendAudit(ctx context.Context, rec *AuditRecord) error {
+	return errors.New("access denied")
+}
+
+func TestWithAudit_appendError(t *testing.T) {
+	m := NewMemory()
+	st := WithAudit(m, failingAuditLog{m}, NewAuditor("push"))
+
+	out := new(bytes.Buffer)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(out, nil)))
+
+	_, err := st.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", conformanceMeta(t, "droot-body"))
+
+	if err != nil {
+		t.Fatalf("the created release should not be reported as failed: %s", err)
+	}
+	if _, err := m.FindReleaseByTimestamp(context.Background(), "github.com/yuuki/droot", "20171017152508"); err != nil {
+		t.Errorf("should not raise error: %s", err)
+	}
+	if !strings.Contains(out.String(), "Failed to append the audit record") || !strings.Contains(out.String(), "access denied") {
+		t.Errorf("should warn the error of the audit log, got %q", out.String())
+	}
+}
+
+func TestWithAudit_localActor(t *testing.T) {
+	m := NewMemory()
+	st := WithAudit(m, m, NewAuditor("yank"))
+	createConformanceRelease(t, st, "github.com/yuuki/droot", "20171017152508", "droot-body")
+
+	err := m.WalkAudit(context.Background(), time.Time{}, func(rec *AuditRecord) error {
+		if rec.Actor != localActor() || rec.Actor == "" {
+			t.Errorf("got actor %q, want %q", rec.Actor, localActor())
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+}
@@ -0,0 +1,543 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+// TestConformance runs the test suite which any API implementation must
+// pass. newStorage is called for each subtest, and must return the API
+// of an empty storage.
+func TestConformance(t *testing.T, newStorage func(t *testing.T) API) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st API)
+	}{
+		{"CreateAndFind", testConformanceCreateAndFind},
+		{"LatestOrdering", testConformanceLatestOrdering},
+		{"MissingRelease", testConformanceMissingRelease},
+		{"SequentialDuplicateTimestamp", testConformanceSequentialDuplicateTimestamp},
+		{"PruneOrdering", testConformancePruneOrdering},
+		{"HaveSameChecksums", testConformanceHaveSameChecksums},
+		{"WalkNestedNames", testConformanceWalkNestedNames},
+		{"ProjectNames", testConformanceProjectNames},
+		{"ListReleases", testConformanceListReleases},
+		{"FindReleaseMeta", testConformanceFindReleaseMeta},
+		{"WalkConcurrently", testConformanceWalkConcurrently},
+		{"WalkError", testConformanceWalkError},
+		{"Canceled", testConformanceCanceled},
+		{"AuditLog", testConformanceAuditLog},
+		{"Chain", testConformanceChain},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newStorage(t))
+		})
+	}
+}
+
+func conformanceMeta(t *testing.T, bodies ...string) *release.Meta {
+	bins := make([]*release.Binary, 0, len(bodies))
+	for i, body := range bodies {
+		bin, err := release.BuildBinary(fmt.Sprintf("bin%d", i), 0755, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to build binary: %s", err)
+		}
+		bins = append(bins, bin)
+	}
+	return release.NewMeta(bins)
+}
+
+func createConformanceRelease(t *testing.T, st API, name, timestamp string, bodies ...string) {
+	ctx := context.Background()
+	if _, err := st.CreateRelease(ctx, name, timestamp, conformanceMeta(t, bodies...)); err != nil {
+		t.Fatalf("CreateRelease(%q, %q) should not raise error: %s", name, timestamp, err)
+	}
+}
+
+func testConformanceCreateAndFind(t *testing.T, st API) {
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+	if ok, err := st.ExistRelease(ctx, name); err != nil || ok {
+		t.Errorf("ExistRelease(%q) = %v, %v; want false, nil", name, ok, err)
+	}
+
+	createConformanceRelease(t, st, name, "20171017152508", "droot-body", "grabeni-body")
+
+	if ok, err := st.ExistRelease(ctx, name); err != nil || !ok {
+		t.Errorf("ExistRelease(%q) = %v, %v; want true, nil", name, ok, err)
+	}
+	rel, err := st.FindReleaseByTimestamp(ctx, name, "20171017152508")
+	if err != nil {
+		t.Fatalf("FindReleaseByTimestamp() should not raise error: %s", err)
+	}
+	if rel.Name() != name || rel.Timestamp() != "20171017152508" {
+		t.Errorf("got %s/%s, want %s/%s", rel.Name(), rel.Timestamp(), name, "20171017152508")
+	}
+	expected := conformanceMeta(t, "droot-body", "grabeni-body")
+	if len(rel.Meta.Binaries) != len(expected.Binaries) {
+		t.Fatalf("got %d binaries, want %d", len(rel.Meta.Binaries), len(expected.Binaries))
+	}
+	for i, bin := range rel.Meta.Binaries {
+		e := expected.Binaries[i]
+		if bin.Name != e.Name || bin.Checksum != e.Checksum || bin.Mode != e.Mode {
+			t.Errorf("got %s/%s/%s, want %s/%s/%s", bin.Name, bin.Mode, bin.Checksum, e.Name, e.Mode, e.Checksum)
+		}
+	}
+
+	latest, err := st.FindLatestRelease(ctx, name)
+	if err != nil {
+		t.Fatalf("FindLatestRelease() should not raise error: %s", err)
+	}
+	if latest.Timestamp() != "20171017152508" {
+		t.Errorf("got %q, want %q", latest.Timestamp(), "20171017152508")
+	}
+	for i, bin := range latest.Meta.Binaries {
+		if bin.Body == nil {
+			t.Fatalf("the body of %s should be opened", bin.Name)
+		}
+		body, err := ioutil.ReadAll(bin.Body)
+		if err != nil {
+			t.Fatalf("failed to read the body of %s: %s", bin.Name, err)
+		}
+		if want := []string{"droot-body", "grabeni-body"}[i]; string(body) != want {
+			t.Errorf("got %q, want %q", body, want)
+		}
+	}
+}
+
+func testConformanceLatestOrdering(t *testing.T, st API) {
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+	for _, ts := range []string{"20171017152626", "20171019000000", "20171017152508", "20171018000000"} {
+		createConformanceRelease(t, st, name, ts, "droot-"+ts)
+	}
+
+	rel, err := st.FindLatestRelease(ctx, name)
+
+	if err != nil {
+		t.Fatalf("FindLatestRelease() should not raise error: %s", err)
+	}
+	if rel.Timestamp() != "20171019000000" {
+		t.Errorf("got %q, want %q", rel.Timestamp(), "20171019000000")
+	}
+}
+
+func testConformanceMissingRelease(t *testing.T, st API) {
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+	if _, err := st.FindLatestRelease(ctx, name); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("FindLatestRelease() of the missing project should raise ErrProjectNotFound, got %v", err)
+	}
+	createConformanceRelease(t, st, name, "20171017152508", "droot-body")
+	if _, err := st.FindReleaseByTimestamp(ctx, name, "20171017152626"); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("FindReleaseByTimestamp() of the missing release should raise ErrReleaseNotFound, got %v", err)
+	}
+	if err := st.DeleteRelease(ctx, name, "20171017152626"); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("DeleteRelease() of the missing release should raise ErrReleaseNotFound, got %v", err)
+	}
+	var nferr *NotFoundError
+	if _, err := st.FindReleaseByTimestamp(ctx, name, "20171017152626"); !errors.As(err, &nferr) || nferr.Name != name || nferr.Timestamp != "20171017152626" {
+		t.Errorf("FindReleaseByTimestamp() of the missing release should raise NotFoundError of %s/%s, got %v", name, "20171017152626", err)
+	}
+}
+
+// testConformanceSequentialDuplicateTimestamp tests that the duplicate
+// timestamp is rejected after the release is created. The concurrent
+// creations are not guaranteed to be rejected.
+func testConformanceSequentialDuplicateTimestamp(t *testing.T, st API) {
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+	createConformanceRelease(t, st, name, "20171017152508", "droot-body")
+
+	if _, err := st.CreateRelease(ctx, name, "20171017152508", conformanceMeta(t, "another-body")); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateRelease() of the duplicate timestamp should raise ErrConflict, got %v", err)
+	}
+	rel, err := st.FindReleaseByTimestamp(ctx, name, "20171017152508")
+	if err != nil {
+		t.Fatalf("FindReleaseByTimestamp() should not raise error: %s", err)
+	}
+	if e := conformanceMeta(t, "droot-body").Binaries[0]; rel.Meta.Binaries[0].Checksum != e.Checksum {
+		t.Errorf("the existing release should be kept: got %s, want %s", rel.Meta.Binaries[0].Checksum, e.Checksum)
+	}
+}
+
+func testConformancePruneOrdering(t *testing.T, st API) {
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+	timestamps := []string{"20171018000000", "20171017152508", "20171019000000", "20171017152626"}
+	for _, ts := range timestamps {
+		createConformanceRelease(t, st, name, ts, "droot-"+ts)
+	}
+
+	rets, err := st.PruneReleases(ctx, name, &PruneOptions{Policy: &release.RetentionPolicy{KeepLast: 2}})
+
+	if err != nil {
+		t.Fatalf("PruneReleases() should not raise error: %s", err)
+	}
+	var got []string
+	for _, ret := range rets {
+		got = append(got, fmt.Sprintf("%s:%v", ret.Timestamp, ret.Keep))
+	}
+	expected := []string{"20171017152508:false", "20171017152626:false", "20171018000000:true", "20171019000000:true"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+	for _, ts := range []string{"20171017152508", "20171017152626"} {
+		if _, err := st.FindReleaseByTimestamp(ctx, name, ts); err == nil {
+			t.Errorf("%s should be pruned", ts)
+		}
+	}
+	rel, err := st.FindLatestRelease(ctx, name)
+	if err != nil {
+		t.Fatalf("FindLatestRelease() should not raise error: %s", err)
+	}
+	if rel.Timestamp() != "20171019000000" {
+		t.Errorf("got %q, want %q", rel.Timestamp(), "20171019000000")
+	}
+}
+
+func testConformanceHaveSameChecksums(t *testing.T, st API) {
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+	createConformanceRelease(t, st, name, "20171017152508", "droot-old", "grabeni-old")
+	createConformanceRelease(t, st, name, "20171017152626", "droot-body", "grabeni-body")
+
+	tests := []struct {
+		desc     string
+		bodies   []string
+		expected bool
+	}{
+		{"same", []string{"droot-body", "grabeni-body"}, true},
+		{"changed", []string{"droot-body", "grabeni-new"}, false},
+		{"old", []string{"droot-old", "grabeni-old"}, false},
+	}
+	for _, tc := range tests {
+		ok, err := st.HaveSameChecksums(ctx, name, conformanceMeta(t, tc.bodies...).Binaries)
+		if err != nil {
+			t.Fatalf("desc: %s, HaveSameChecksums() should not raise error: %s", tc.desc, err)
+		}
+		if ok != tc.expected {
+			t.Errorf("desc: %s, HaveSameChecksums() = %v; want %v", tc.desc, ok, tc.expected)
+		}
+	}
+}
+
+var conformanceReleases = []string{
+	"github.com/motemen/ghq/20171013140424",
+	"github.com/yuuki/droot/20171017152508",
+	"github.com/yuuki/droot/20171017152626",
+	"github.com/yuuki/grabeni/20171017152626",
+	"gitlab.example.com/group/subgroup/project/20171018000000",
+}
+
+func createConformanceReleases(t *testing.T, st API) {
+	for _, prefix := range conformanceReleases {
+		_, name := release.ParseName(prefix)
+		createConformanceRelease(t, st, name, prefix[len(name)+1:], prefix)
+	}
+}
+
+func testConformanceWalkNestedNames(t *testing.T, st API) {
+	ctx := context.Background()
+	createConformanceReleases(t, st)
+
+	var got []string
+	err := st.WalkReleases(ctx, 1, func(rel *release.Release) error {
+		got = append(got, rel.Prefix())
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("WalkReleases() should not raise error: %s", err)
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(conformanceReleases, ",") {
+		t.Errorf("got %v, want %v", got, conformanceReleases)
+	}
+}
+
+func testConformanceProjectNames(t *testing.T, st API) {
+	createConformanceReleases(t, st)
+	// The deleted project is not listed.
+	createConformanceRelease(t, st, "github.com/yuuki/deleted", "20171017152508", "deleted-body")
+	if err := st.DeleteRelease(context.Background(), "github.com/yuuki/deleted", "20171017152508"); err != nil {
+		t.Fatalf("DeleteRelease() should not raise error: %s", err)
+	}
+
+	names, err := st.ProjectNames(context.Background())
+
+	if err != nil {
+		t.Fatalf("ProjectNames() should not raise error: %s", err)
+	}
+	expected := []string{
+		"github.com/motemen/ghq",
+		"github.com/yuuki/droot",
+		"github.com/yuuki/grabeni",
+		"gitlab.example.com/group/subgroup/project",
+	}
+	if diff := pretty.Compare(names, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
+
+func testConformanceListReleases(t *testing.T, st API) {
+	ctx := context.Background()
+	createConformanceReleases(t, st)
+	name := "github.com/yuuki/droot"
+	if err := st.YankRelease(ctx, name, "20171017152626", "broken"); err != nil {
+		t.Fatalf("YankRelease() should not raise error: %s", err)
+	}
+
+	rels, err := st.ListReleases(ctx, name)
+
+	if err != nil {
+		t.Fatalf("ListReleases() should not raise error: %s", err)
+	}
+	var got []string
+	for _, rel := range rels {
+		got = append(got, fmt.Sprintf("%s yanked=%v", rel.Prefix(), rel.Meta.IsYanked()))
+		for _, bin := range rel.Meta.Binaries {
+			if bin.Body != nil {
+				t.Errorf("ListReleases() should not open the body of %s/%s", rel.Prefix(), bin.Name)
+			}
+		}
+	}
+	expected := []string{
+		"github.com/yuuki/droot/20171017152508 yanked=false",
+		"github.com/yuuki/droot/20171017152626 yanked=true",
+	}
+	if diff := pretty.Compare(got, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+	if _, err := st.ListReleases(ctx, "github.com/yuuki/ghq"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("ListReleases() of the missing project should raise ErrProjectNotFound, got %v", err)
+	}
+}
+
+func testConformanceFindReleaseMeta(t *testing.T, st API) {
+	ctx := context.Background()
+	createConformanceReleases(t, st)
+	name := "github.com/yuuki/droot"
+	if err := st.YankRelease(ctx, name, "20171017152626", "broken"); err != nil {
+		t.Fatalf("YankRelease() should not raise error: %s", err)
+	}
+
+	for _, tc := range []struct {
+		timestamp string
+		expected  string
+	}{
+		{timestamp: "", expected: "github.com/yuuki/droot/20171017152508"},
+		{timestamp: "20171017152626", expected: "github.com/yuuki/droot/20171017152626"},
+	} {
+		rel, err := st.FindReleaseMeta(ctx, name, tc.timestamp)
+		if err != nil {
+			t.Fatalf("timestamp: %q, FindReleaseMeta() should not raise error: %s", tc.timestamp, err)
+		}
+		if rel.Prefix() != tc.expected {
+			t.Errorf("timestamp: %q, got %s, want %s", tc.timestamp, rel.Prefix(), tc.expected)
+		}
+		for _, bin := range rel.Meta.Binaries {
+			if bin.Body != nil {
+				t.Errorf("timestamp: %q, FindReleaseMeta() should not open the body of %s", tc.timestamp, bin.Name)
+			}
+		}
+	}
+	if _, err := st.FindReleaseMeta(ctx, name, "20171019000000"); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("FindReleaseMeta() of the missing release should raise ErrReleaseNotFound, got %v", err)
+	}
+	if _, err := st.FindReleaseMeta(ctx, "github.com/yuuki/ghq", ""); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("FindReleaseMeta() of the missing project should raise ErrProjectNotFound, got %v", err)
+	}
+}
+
+func testConformanceWalkConcurrently(t *testing.T, st API) {
+	ctx := context.Background()
+	createConformanceReleases(t, st)
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	err := st.WalkReleases(ctx, 4, func(rel *release.Release) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, rel.Prefix())
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("WalkReleases() should not raise error: %s", err)
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(conformanceReleases, ",") {
+		t.Errorf("got %v, want %v", got, conformanceReleases)
+	}
+}
+
+func testConformanceWalkError(t *testing.T, st API) {
+	ctx := context.Background()
+	createConformanceReleases(t, st)
+
+	err := st.WalkReleases(ctx, 4, func(rel *release.Release) error {
+		if rel.Name() == "github.com/yuuki/droot" {
+			return errors.Errorf("walk error %s", rel.Prefix())
+		}
+		return nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "walk error github.com/yuuki/droot/") {
+		t.Errorf("WalkReleases() should return the error of walkfn, got %v", err)
+	}
+}
+
+func testConformanceCanceled(t *testing.T, st API) {
+	createConformanceReleases(t, st)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	name := "github.com/yuuki/droot"
+	if _, err := st.CreateRelease(ctx, name, "20171019000000", conformanceMeta(t, "droot-body")); err == nil {
+		t.Errorf("CreateRelease() with the canceled context should raise error")
+	}
+	if _, err := st.FindReleaseByTimestamp(context.Background(), name, "20171019000000"); err == nil {
+		t.Errorf("the canceled release should not be created")
+	}
+	walked := 0
+	err := st.WalkReleases(ctx, 1, func(rel *release.Release) error {
+		walked++
+		return nil
+	})
+	if err == nil {
+		t.Errorf("WalkReleases() with the canceled context should raise error")
+	}
+	if walked > 0 {
+		t.Errorf("WalkReleases() with the canceled context should not walk, got %d releases", walked)
+	}
+}
+
+func testConformanceAuditLog(t *testing.T, st API) {
+	log, ok := st.(AuditLog)
+	if !ok {
+		t.Skip("the storage has no audit log")
+	}
+	ctx := context.Background()
+	createConformanceReleases(t, st)
+	day := time.Date(2017, 10, 17, 0, 0, 0, 0, time.UTC)
+	// Append the records out of the order of the time.
+	for _, rec := range []*AuditRecord{
+		{Time: day.Add(33 * time.Hour), Action: AuditYank, Project: "github.com/yuuki/droot", Timestamp: "20171017152626"},
+		{Time: day.Add(10 * time.Hour), Action: AuditCreate, Project: "github.com/yuuki/droot", Timestamp: "20171017152508",
+			Checksums: map[string]string{"droot": "a1b2"}},
+		{Time: day.Add(34 * time.Hour), Action: AuditUnyank, Project: "github.com/yuuki/droot", Timestamp: "20171017152626"},
+		{Time: day.Add(25 * time.Hour), Action: AuditDelete, Project: "github.com/yuuki/grabeni", Timestamp: "20171017152626"},
+	} {
+		if err := log.AppendAudit(ctx, rec); err != nil {
+			t.Fatalf("AppendAudit() should not raise error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		since    time.Time
+		expected []string
+	}{
+		{since: time.Time{}, expected: []string{"create", "delete", "yank", "unyank"}},
+		{since: day.Add(25 * time.Hour), expected: []string{"delete", "yank", "unyank"}},
+		{since: day.Add(26 * time.Hour), expected: []string{"yank", "unyank"}},
+		{since: day.Add(48 * time.Hour), expected: nil},
+	}
+	for _, tc := range tests {
+		var got []string
+		err := log.WalkAudit(ctx, tc.since, func(rec *AuditRecord) error {
+			got = append(got, rec.Action)
+			if rec.Action == AuditCreate && rec.Checksums["droot"] != "a1b2" {
+				t.Errorf("got checksums %v, want droot:a1b2", rec.Checksums)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("since: %s, WalkAudit() should not raise error: %s", tc.since, err)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("since: %s, got %v, want %v", tc.since, got, tc.expected)
+		}
+	}
+
+	// The audit records are not walked as the releases.
+	var prefixes []string
+	err := st.WalkReleases(ctx, 1, func(rel *release.Release) error {
+		prefixes = append(prefixes, rel.Prefix())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkReleases() should not raise error: %s", err)
+	}
+	sort.Strings(prefixes)
+	if strings.Join(prefixes, ",") != strings.Join(conformanceReleases, ",") {
+		t.Errorf("got %v, want %v", prefixes, conformanceReleases)
+	}
+}
+
+func testConformanceChain(t *testing.T, st API) {
+	ctx := context.Background()
+	name := "github.com/yuuki/droot"
+	for _, ts := range []string{"20171017152508", "20171017152626", "20171018000000"} {
+		createConformanceRelease(t, st, name, ts, "droot-"+ts)
+	}
+	if err := st.DeleteRelease(ctx, name, "20171017152626"); err != nil {
+		t.Fatalf("DeleteRelease() should not raise error: %s", err)
+	}
+	// The release next to the deleted latest release is chained to its tombstone.
+	if err := st.DeleteRelease(ctx, name, "20171018000000"); err != nil {
+		t.Fatalf("DeleteRelease() should not raise error: %s", err)
+	}
+	createConformanceRelease(t, st, name, "20171019000000", "droot-20171019000000")
+
+	links, err := st.ChainLinks(ctx, name)
+
+	if err != nil {
+		t.Fatalf("ChainLinks() should not raise error: %s", err)
+	}
+	var got []string
+	for _, l := range links {
+		got = append(got, fmt.Sprintf("%s:%v", l.Timestamp, l.Deleted))
+	}
+	expected := []string{"20171017152508:false", "20171017152626:true", "20171018000000:true", "20171019000000:false"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("got %v, want %v", got, expected)
+	}
+	if l := links[3]; l.Chain == nil || l.Chain.Prev != "20171018000000" || l.Chain.PrevHash != links[2].Hash {
+		t.Errorf("the release should be chained to the tombstone of 20171018000000, got %+v", l.Chain)
+	}
+	if problems := release.VerifyChain(links); len(problems) > 0 {
+		t.Errorf("the chain should not be broken, got %v", problems)
+	}
+
+	// The tombstones are not the releases.
+	var walked []string
+	err = st.WalkReleases(ctx, 1, func(rel *release.Release) error {
+		walked = append(walked, rel.Prefix())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkReleases() should not raise error: %s", err)
+	}
+	if len(walked) != 2 {
+		t.Errorf("WalkReleases() should walk only the live releases, got %v", walked)
+	}
+	if _, err := st.ChainLinks(ctx, "github.com/yuuki/grabeni"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("ChainLinks() of the missing project should raise ErrProjectNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/yuuki/binrep/pkg/storage"
+	"github.com/yuuki/binrep/pkg/storage/storagetest"
+)
+
+func TestS3Conformance(t *testing.T) {
+	storage.TestConformance(t, func(t *testing.T) storage.API {
+		srv := storagetest.NewServer("binrep-testing")
+		// Page every response to test the composition of the list calls.
+		srv.MaxKeys = 1
+		t.Cleanup(srv.Close)
+		sess := session.Must(session.NewSession(&aws.Config{
+			Region:           aws.String(storagetest.Region),
+			Endpoint:         aws.String(srv.URL),
+			S3ForcePathStyle: aws.Bool(true),
+			Credentials:      credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		}))
+		return storage.New(sess, "binrep-testing")
+	})
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/config"
+)
+
+// Endpoint represents the backend endpoint such as
+// `s3://bucket?endpoint=http://minio:9000&region=us-east-1&path_style=true`,
+// or the in-memory storage such as `mem://name`.
+type Endpoint struct {
+	// Scheme is "s3" or "mem".
+	Scheme string
+	// Bucket is the bucket name, or the name of the in-memory storage.
+	Bucket string
+	// URL is the URL of the S3-compatible server such as MinIO. The default
+	// AWS endpoint is used if it is empty.
+	URL string
+	// Region is the region of the bucket. The region of the AWS config is
+	// used if it is empty.
+	Region string
+	// PathStyle is whether to use the path-style addressing
+	// (`http://host/bucket/key`) instead of the virtual-hosted style.
+	PathStyle bool
+}
+
+// ParseEndpoint parses the backend endpoint. The endpoint without a scheme
+// such as `bucket` is the S3 bucket as ever.
+func ParseEndpoint(s string) (*Endpoint, error) {
+	raw := s
+	if s != "" && !strings.Contains(s, "://") {
+		raw = "s3://" + s
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid endpoint %q", s)
+	}
+	if u.Scheme != "s3" && u.Scheme != "mem" {
+		return nil, errors.Errorf("invalid endpoint %q: want s3://<bucket> or mem://<name>", s)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid endpoint %q: no bucket", s)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, errors.Errorf("invalid endpoint %q: want %s://<bucket> without path", s, u.Scheme)
+	}
+	if u.Scheme == "mem" {
+		if u.RawQuery != "" {
+			return nil, errors.Errorf("invalid endpoint %q: mem://<name> takes no parameters", s)
+		}
+		return &Endpoint{Scheme: u.Scheme, Bucket: u.Host}, nil
+	}
+	q := u.Query()
+	ep := &Endpoint{
+		Scheme: u.Scheme,
+		Bucket: u.Host,
+		URL:    q.Get("endpoint"),
+		Region: q.Get("region"),
+	}
+	if ep.URL != "" {
+		eu, err := url.Parse(ep.URL)
+		if err != nil || (eu.Scheme != "http" && eu.Scheme != "https") || eu.Host == "" {
+			return nil, errors.Errorf("invalid endpoint %q: want endpoint=http(s)://<host>", s)
+		}
+	}
+	if v := q.Get("path_style"); v != "" {
+		ep.PathStyle, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.Errorf("invalid endpoint %q: path_style must be true or false", s)
+		}
+	}
+	return ep, nil
+}
+
+// NewSession creates the AWS session for the endpoint with the region and
+// the profile. The region overrides the region of the endpoint. The requests
+// are not retried by the SDK, so that RetryPolicy is the only retry layer.
+func (ep *Endpoint) NewSession(region, profile string) (*session.Session, error) {
+	cfg := aws.Config{MaxRetries: aws.Int(0)}
+	if region == "" {
+		region = ep.Region
+	}
+	if region != "" {
+		cfg.Region = aws.String(region)
+	}
+	if ep.URL != "" {
+		cfg.Endpoint = aws.String(ep.URL)
+	}
+	if ep.PathStyle {
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            cfg,
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create aws session")
+	}
+	return sess, nil
+}
+
+// Open opens the storage of the endpoint of the config, which retries the
+// operations failing temporarily, and records the mutations in the audit log
+// of the storage. The objects are put with the object options of the config.
+func Open() (API, error) {
+	return OpenWithOptions(ObjectOptionsFromConfig())
+}
+
+// OpenWithOptions opens the storage like Open, which puts the objects with
+// opts instead of the object options of the config.
+func OpenWithOptions(opts *ObjectOptions) (API, error) {
+	ep, err := ParseEndpoint(config.Config.BackendEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	policy := NewRetryPolicy(config.Config.RetryMaxAttempts)
+	auditor := NewAuditor(config.Config.Command)
+	if ep.Scheme == "mem" {
+		m := OpenMemory(ep.Bucket)
+		return WithAudit(WithRetry(m, policy), m, auditor), nil
+	}
+	sess, err := ep.NewSession(config.Config.Region, config.Config.Profile)
+	if err != nil {
+		return nil, err
+	}
+	s := newS3(sess, ep.Bucket, opts)
+	return WithAudit(WithRetry(s, policy), s, auditor), nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/pkg/errors"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		desc     string
+		in       string
+		expected *Endpoint
+	}{
+		{
+			desc:     "bucket",
+			in:       "s3://binrep-testing",
+			expected: &Endpoint{Scheme: "s3", Bucket: "binrep-testing"},
+		},
+		{
+			desc: "s3-compatible server",
+			in:   "s3://binrep-testing?endpoint=http://minio:9000&region=us-east-1&path_style=true",
+			expected: &Endpoint{
+				Scheme:    "s3",
+				Bucket:    "binrep-testing",
+				URL:       "http://minio:9000",
+				Region:    "us-east-1",
+				PathStyle: true,
+			},
+		},
+		{
+			desc:     "bucket without scheme",
+			in:       "binrep-testing",
+			expected: &Endpoint{Scheme: "s3", Bucket: "binrep-testing"},
+		},
+		{
+			desc:     "in-memory storage",
+			in:       "mem://binrep-testing",
+			expected: &Endpoint{Scheme: "mem", Bucket: "binrep-testing"},
+		},
+	}
+	for _, tc := range tests {
+		ep, err := ParseEndpoint(tc.in)
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		if diff := pretty.Compare(ep, tc.expected); diff != "" {
+			t.Errorf("desc: %s, diff: (-actual +expected)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestParseEndpoint_error(t *testing.T) {
+	tests := []string{
+		"",
+		"binrep-testing/prefix",
+		"gs://binrep-testing",
+		"s3://",
+		"s3://binrep-testing/prefix",
+		"s3://binrep-testing?endpoint=minio:9000",
+		"s3://binrep-testing?path_style=yes",
+		"mem://",
+		"mem://binrep-testing/prefix",
+		"mem://binrep-testing?region=us-east-1",
+	}
+	for _, tc := range tests {
+		if _, err := ParseEndpoint(tc); err == nil {
+			t.Errorf("ParseEndpoint(%q) should raise error", tc)
+		}
+	}
+}
+
+func TestEndpointNewSession(t *testing.T) {
+	ep := &Endpoint{
+		Bucket:    "binrep-testing",
+		URL:       "http://minio:9000",
+		Region:    "us-east-1",
+		PathStyle: true,
+	}
+
+	sess, err := ep.NewSession("ap-northeast-1", "")
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if got := aws.StringValue(sess.Config.Region); got != "ap-northeast-1" {
+		t.Errorf("got %q, want %q", got, "ap-northeast-1")
+	}
+	if got := aws.StringValue(sess.Config.Endpoint); got != "http://minio:9000" {
+		t.Errorf("got %q, want %q", got, "http://minio:9000")
+	}
+	if !aws.BoolValue(sess.Config.S3ForcePathStyle) {
+		t.Errorf("S3ForcePathStyle should be true")
+	}
+}
+
+func TestEndpointNewSession_noSDKRetry(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	ep := &Endpoint{Bucket: "binrep-testing", URL: srv.URL, Region: "us-east-1", PathStyle: true}
+	sess, err := ep.NewSession("", "")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	st := WithRetry(New(sess, ep.Bucket), &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	_, err = st.ExistRelease(context.Background(), "github.com/yuuki/droot")
+
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("should raise ErrTransient, got %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("got %d requests, want 2 by the retry policy only", got)
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+// The classes of the storage errors, which are matched by errors.Is.
+var (
+	// ErrProjectNotFound means that the project has no releases.
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrReleaseNotFound means that the release, or any object of it, is not
+	// found, or that all releases of the project are yanked.
+	ErrReleaseNotFound = errors.New("release not found")
+	// ErrConflict means that the release already exists. It is detected on
+	// a best-effort basis, not for the concurrent creations.
+	ErrConflict = errors.New("release already exists")
+	// ErrTransient means that the backend is temporarily unavailable, such
+	// as the network errors, the throttling and the 5xx responses, so that
+	// the operation may succeed if retried.
+	ErrTransient = errors.New("transient error")
+)
+
+// NotFoundError represents that the project or the release is not found.
+type NotFoundError struct {
+	// Name is the name of the project.
+	Name string
+	// Timestamp is the timestamp of the release, which is empty if the
+	// project is not found.
+	Timestamp string
+
+	class error
+	msg   string
+}
+
+func projectNotFound(name string) error {
+	return errors.WithStack(&NotFoundError{
+		Name:  name,
+		class: ErrProjectNotFound,
+		msg:   fmt.Sprintf("no such projects %v", name),
+	})
+}
+
+func releaseNotFound(name, timestamp, format string, args ...interface{}) error {
+	return errors.WithStack(&NotFoundError{
+		Name:      name,
+		Timestamp: timestamp,
+		class:     ErrReleaseNotFound,
+		msg:       fmt.Sprintf(format, args...),
+	})
+}
+
+// Error returns the error message for NotFoundError.
+func (e *NotFoundError) Error() string {
+	return e.msg
+}
+
+// Is returns whether target is ErrProjectNotFound or ErrReleaseNotFound
+// according to what is not found.
+func (e *NotFoundError) Is(target error) bool {
+	return target == e.class
+}
+
+// ConflictError represents that the release already exists.
+type ConflictError struct {
+	Name      string
+	Timestamp string
+	// URL is the URL of the existing release.
+	URL string
+}
+
+func conflict(name, timestamp, url string) error {
+	return errors.WithStack(&ConflictError{Name: name, Timestamp: timestamp, URL: url})
+}
+
+// Error returns the error message for ConflictError.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("release already exists %s", e.URL)
+}
+
+// Is returns whether target is ErrConflict.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// TransientError represents the temporary failure of the backend.
+type TransientError struct {
+	Err error
+}
+
+// Error returns the error message of the underlying error.
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether target is ErrTransient.
+func (e *TransientError) Is(target error) bool {
+	return target == ErrTransient
+}
+
+// isTransientS3Error returns whether err of the AWS SDK is temporary, that is,
+// the network errors, the timeouts, the throttling or the 5xx responses.
+func isTransientS3Error(err error) bool {
+	if request.IsErrorRetryable(err) || request.IsErrorThrottle(err) {
+		return true
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		return rerr.StatusCode() >= http.StatusInternalServerError ||
+			rerr.StatusCode() == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// wrapS3Error wraps err of the AWS SDK with the message, classifying it as
+// TransientError if it is temporary.
+func wrapS3Error(err error, format string, args ...interface{}) error {
+	if isTransientS3Error(err) {
+		err = &TransientError{Err: err}
+	}
+	return errors.Wrapf(err, format, args...)
+}
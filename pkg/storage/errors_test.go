@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+func TestWrapS3Error(t *testing.T) {
+	tests := []struct {
+		desc      string
+		err       error
+		transient bool
+	}{
+		{
+			desc:      "network error",
+			err:       awserr.New("RequestError", "send request failed", errors.New("connection reset by peer")),
+			transient: true,
+		},
+		{
+			desc:      "throttling",
+			err:       awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), http.StatusServiceUnavailable, "id"),
+			transient: true,
+		},
+		{
+			desc:      "internal error",
+			err:       awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), http.StatusInternalServerError, "id"),
+			transient: true,
+		},
+		{
+			desc:      "access denied",
+			err:       awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "id"),
+			transient: false,
+		},
+		{
+			desc:      "no such key",
+			err:       awserr.New(s3.ErrCodeNoSuchKey, "not found", nil),
+			transient: false,
+		},
+		{
+			desc:      "canceled",
+			err:       awserr.New("RequestCanceled", "request context canceled", nil),
+			transient: false,
+		},
+	}
+	for _, tc := range tests {
+		err := wrapS3Error(tc.err, "failed to get object from s3 %s", "s3://binrep-testing/github.com/yuuki/droot")
+
+		if got := errors.Is(err, ErrTransient); got != tc.transient {
+			t.Errorf("desc: %s, errors.Is(err, ErrTransient) = %v; want %v", tc.desc, got, tc.transient)
+		}
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != tc.err.(awserr.Error).Code() {
+			t.Errorf("desc: %s, the AWS error should be unwrapped, got %v", tc.desc, err)
+		}
+	}
+}
+
+func TestNotFoundError(t *testing.T) {
+	err := errors.Wrap(projectNotFound("github.com/yuuki/droot"), "failed to pull")
+
+	if !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("should be ErrProjectNotFound, got %v", err)
+	}
+	if errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("should not be ErrReleaseNotFound, got %v", err)
+	}
+	if err.Error() != "failed to pull: no such projects github.com/yuuki/droot" {
+		t.Errorf("got %q, want %q", err.Error(), "failed to pull: no such projects github.com/yuuki/droot")
+	}
+}
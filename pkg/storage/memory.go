@@ -0,0 +1,720 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ivpusic/grpool"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+// ErrInjectedFault is the default error of the faults injected into Memory,
+// which is a TransientError.
+var ErrInjectedFault error = &TransientError{Err: errors.New("injected fault")}
+
+// Faults represents the faults injected into Memory for testing.
+type Faults struct {
+	// FailUploadAt fails the Nth upload of the binary bodies counted from 1
+	// since the faults are set. It is disabled if 0.
+	FailUploadAt int
+	// FailReadAt fails the Nth read of the binary bodies counted from 1
+	// since the faults are set. It is disabled if 0.
+	FailReadAt int
+	// Latency delays every operation.
+	Latency time.Duration
+	// Err is returned by the failures. ErrInjectedFault is returned if nil.
+	Err error
+}
+
+type memRelease struct {
+	meta   []byte // meta.yml
+	bodies map[string][]byte
+}
+
+// Memory is the concurrency-safe storage keeping the releases in memory. The
+// metadata is stored as meta.yml as the S3 storage does, so that the fields
+// which are not serialized are not kept.
+type Memory struct {
+	name string
+
+	mu       sync.RWMutex
+	releases map[string]map[string]*memRelease // name -> timestamp -> release
+	archived map[string]map[string]*memRelease
+	// tombstones is the tombstones of the deleted or archived releases.
+	tombstones map[string]map[string]*release.Tombstone
+	audit      map[string][]byte // key -> record
+	faults     Faults
+	uploads    int
+	reads      int
+}
+
+var (
+	memoriesMu sync.Mutex
+	memories   = map[string]*Memory{}
+)
+
+// NewMemory creates an empty in-memory storage.
+func NewMemory() *Memory {
+	return newMemory("memory")
+}
+
+// OpenMemory returns the in-memory storage of the name shared within the
+// process, such as the storage of the endpoint `mem://<name>`.
+func OpenMemory(name string) *Memory {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	m, ok := memories[name]
+	if !ok {
+		m = newMemory(name)
+		memories[name] = m
+	}
+	return m
+}
+
+func newMemory(name string) *Memory {
+	return &Memory{
+		name:       name,
+		releases:   map[string]map[string]*memRelease{},
+		archived:   map[string]map[string]*memRelease{},
+		tombstones: map[string]map[string]*release.Tombstone{},
+		audit:      map[string][]byte{},
+	}
+}
+
+// SetFaults sets the faults injected into the following operations.
+func (m *Memory) SetFaults(f Faults) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faults = f
+	m.uploads = 0
+	m.reads = 0
+}
+
+// delay sleeps for the latency of the faults, and returns the error of the
+// context if it is done.
+func (m *Memory) delay(ctx context.Context) error {
+	m.mu.RLock()
+	latency := m.faults.Latency
+	m.mu.RUnlock()
+	if latency <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(latency)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// upload counts the upload, and returns the injected error if it is the Nth.
+// It must be called with the lock.
+func (m *Memory) upload() error {
+	m.uploads++
+	if m.faults.FailUploadAt > 0 && m.uploads == m.faults.FailUploadAt {
+		return m.faultErr()
+	}
+	return nil
+}
+
+// read counts the read of the body, and returns the injected error if it is the Nth.
+func (m *Memory) read() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads++
+	if m.faults.FailReadAt > 0 && m.reads == m.faults.FailReadAt {
+		return m.faultErr()
+	}
+	return nil
+}
+
+func (m *Memory) faultErr() error {
+	if m.faults.Err != nil {
+		return m.faults.Err
+	}
+	return ErrInjectedFault
+}
+
+// memBody is the body of the binary into which the read faults are injected.
+type memBody struct {
+	m *Memory
+	r *bytes.Reader
+}
+
+func (b *memBody) Read(p []byte) (int, error) {
+	if err := b.m.read(); err != nil {
+		return 0, err
+	}
+	return b.r.Read(p)
+}
+
+// Close does nothing.
+func (b *memBody) Close() error {
+	return nil
+}
+
+func (m *Memory) buildReleaseURL(name, timestamp string) *url.URL {
+	return &url.URL{Scheme: "mem", Host: m.name, Path: "/" + name + "/" + timestamp}
+}
+
+// ascTimestamps returns the timestamps of the name sorted in ascending order.
+// It must be called with the lock.
+func (m *Memory) ascTimestamps(name string) ([]string, error) {
+	rels := m.releases[name]
+	if len(rels) < 1 {
+		return nil, projectNotFound(name)
+	}
+	timestamps := make([]string, 0, len(rels))
+	for ts := range rels {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Strings(timestamps)
+	return timestamps, nil
+}
+
+func (r *memRelease) decodeMeta() (*release.Meta, error) {
+	var meta release.Meta
+	if err := yaml.Unmarshal(r.meta, &meta); err != nil {
+		return nil, errors.Wrap(err, "failed to read meta.yml")
+	}
+	return &meta, nil
+}
+
+// open returns the release with the bodies opened.
+func (m *Memory) open(name, timestamp string, r *memRelease) (*release.Release, error) {
+	meta, err := r.decodeMeta()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range meta.Binaries {
+		if b.IsLink() {
+			continue
+		}
+		body, ok := r.bodies[b.Name]
+		if !ok {
+			return nil, releaseNotFound(name, timestamp, "not found %v/%v/%v", name, timestamp, b.Name)
+		}
+		b.Body = &memBody{m: m, r: bytes.NewReader(body)}
+	}
+	return release.New(meta, m.buildReleaseURL(name, timestamp)), nil
+}
+
+// findLatestAvailable finds the latest release which is not yanked. It
+// returns nil if all releases are yanked. It must be called with the lock.
+func (m *Memory) findLatestAvailable(name string) (string, *memRelease, *release.Meta, error) {
+	timestamps, err := m.ascTimestamps(name)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	for i := len(timestamps) - 1; i >= 0; i-- {
+		r := m.releases[name][timestamps[i]]
+		meta, err := r.decodeMeta()
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if meta.IsYanked() {
+			continue
+		}
+		return timestamps[i], r, meta, nil
+	}
+	return "", nil, nil, nil
+}
+
+// ExistRelease returns whether the name exists or not.
+func (m *Memory) ExistRelease(ctx context.Context, name string) (bool, error) {
+	if err := m.delay(ctx); err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.releases[name]) > 0, nil
+}
+
+// HaveSameChecksums returns whether each checksum of given binaries is
+// the same or not with each checksum of binaries of the latest release.
+func (m *Memory) HaveSameChecksums(ctx context.Context, name string, bins []*release.Binary) (bool, error) {
+	if err := m.delay(ctx); err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, _, latestMeta, err := m.findLatestAvailable(name)
+	if err != nil {
+		return false, err
+	}
+	if latestMeta == nil {
+		// all releases are yanked
+		return false, nil
+	}
+	for _, lbin := range latestMeta.Binaries {
+		for _, bin := range bins {
+			if bin.Name == lbin.Name && bin.Checksum != lbin.Checksum {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// FindLatestRelease finds the release including the latest timestamp,
+// skipping the yanked releases.
+func (m *Memory) FindLatestRelease(ctx context.Context, name string) (*release.Release, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ts, r, _, err := m.findLatestAvailable(name)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, releaseNotFound(name, "", "no available releases of %v: all releases are yanked", name)
+	}
+	return m.open(name, ts, r)
+}
+
+// FindReleaseByTimestamp finds the release including the `timestamp`.
+func (m *Memory) FindReleaseByTimestamp(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.releases[name][timestamp]
+	if !ok {
+		return nil, releaseNotFound(name, timestamp, "meta.yml not found %s", m.buildReleaseURL(name, timestamp))
+	}
+	return m.open(name, timestamp, r)
+}
+
+// FindReleaseMeta finds the release of the timestamp, or the latest release
+// which is not yanked if timestamp is empty, without the bodies.
+func (m *Memory) FindReleaseMeta(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if timestamp == "" {
+		ts, _, meta, err := m.findLatestAvailable(name)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, releaseNotFound(name, "", "no available releases of %v: all releases are yanked", name)
+		}
+		return release.New(meta, m.buildReleaseURL(name, ts)), nil
+	}
+	r, ok := m.releases[name][timestamp]
+	if !ok {
+		return nil, releaseNotFound(name, timestamp, "meta.yml not found %s", m.buildReleaseURL(name, timestamp))
+	}
+	meta, err := r.decodeMeta()
+	if err != nil {
+		return nil, err
+	}
+	return release.New(meta, m.buildReleaseURL(name, timestamp)), nil
+}
+
+// CreateRelease creates the release of the meta. Nothing is stored if it
+// fails to read any body.
+func (m *Memory) CreateRelease(ctx context.Context, name string, timestamp string, meta *release.Meta) (*release.Release, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	u := m.buildReleaseURL(name, timestamp)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.releases[name][timestamp]; ok {
+		return nil, conflict(name, timestamp, u.String())
+	}
+	links, err := m.chainLinks(name)
+	if err != nil {
+		return nil, err
+	}
+	var last *release.Link
+	if len(links) > 0 {
+		last = links[len(links)-1]
+	}
+	meta.Chain = last.NextChain()
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal yaml")
+	}
+	r := &memRelease{meta: data, bodies: map[string][]byte{}}
+	for _, bin := range meta.Binaries {
+		if bin.IsLink() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := m.upload(); err != nil {
+			return nil, errors.Wrapf(err, "failed to upload file to %s", u)
+		}
+		body, err := ioutil.ReadAll(bin.Body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to upload file to %s", u)
+		}
+		r.bodies[bin.Name] = body
+	}
+	if m.releases[name] == nil {
+		m.releases[name] = map[string]*memRelease{}
+	}
+	m.releases[name][timestamp] = r
+	return release.New(meta, u), nil
+}
+
+// OpenBinary opens the body of the binary from the offset.
+func (m *Memory) OpenBinary(ctx context.Context, name, timestamp, binName string, offset int64) (io.ReadCloser, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.releases[name][timestamp]
+	if !ok {
+		return nil, releaseNotFound(name, timestamp, "meta.yml not found %s", m.buildReleaseURL(name, timestamp))
+	}
+	body, ok := r.bodies[binName]
+	if !ok {
+		return nil, releaseNotFound(name, timestamp, "not found %v/%v/%v", name, timestamp, binName)
+	}
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+	return &memBody{m: m, r: bytes.NewReader(body[offset:])}, nil
+}
+
+// DeleteRelease deletes the release with the `timestamp`.
+func (m *Memory) DeleteRelease(ctx context.Context, name, timestamp string) error {
+	if err := m.delay(ctx); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.releases[name][timestamp]; !ok {
+		return releaseNotFound(name, timestamp, "meta.yml not found %s", m.buildReleaseURL(name, timestamp))
+	}
+	if err := m.bury(name, timestamp); err != nil {
+		return err
+	}
+	move(m.releases, nil, name, timestamp)
+	return nil
+}
+
+// bury leaves the tombstone of the live release. It must be called with the lock.
+func (m *Memory) bury(name, timestamp string) error {
+	meta, err := m.releases[name][timestamp].decodeMeta()
+	if err != nil {
+		return err
+	}
+	t, err := release.NewTombstone(timestamp, meta)
+	if err != nil {
+		return err
+	}
+	if m.tombstones[name] == nil {
+		m.tombstones[name] = map[string]*release.Tombstone{}
+	}
+	m.tombstones[name][timestamp] = t
+	return nil
+}
+
+// move moves the release from src into dst, or deletes it if dst is nil.
+func move(src, dst map[string]map[string]*memRelease, name, timestamp string) {
+	r := src[name][timestamp]
+	delete(src[name], timestamp)
+	if len(src[name]) == 0 {
+		delete(src, name)
+	}
+	if dst == nil {
+		return
+	}
+	if dst[name] == nil {
+		dst[name] = map[string]*memRelease{}
+	}
+	dst[name][timestamp] = r
+}
+
+// ArchiveRelease moves the release with the `timestamp` into the archive.
+// The storage class is ignored.
+func (m *Memory) ArchiveRelease(ctx context.Context, name, timestamp, storageClass string) error {
+	if err := m.delay(ctx); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.releases[name][timestamp]; !ok {
+		return releaseNotFound(name, timestamp, "no such release %v/%v", name, timestamp)
+	}
+	if err := m.bury(name, timestamp); err != nil {
+		return err
+	}
+	move(m.releases, m.archived, name, timestamp)
+	return nil
+}
+
+// RestoreRelease moves the archived release with the `timestamp` back into
+// the live releases. The restoration completes immediately.
+func (m *Memory) RestoreRelease(ctx context.Context, name, timestamp string) (bool, error) {
+	if err := m.delay(ctx); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.archived[name][timestamp]; !ok {
+		return false, releaseNotFound(name, timestamp, "no such archived release %v/%v", name, timestamp)
+	}
+	if _, ok := m.releases[name][timestamp]; ok {
+		return false, conflict(name, timestamp, m.buildReleaseURL(name, timestamp).String())
+	}
+	move(m.archived, m.releases, name, timestamp)
+	return true, nil
+}
+
+// YankRelease marks the release with the `timestamp` as yanked with the reason.
+func (m *Memory) YankRelease(ctx context.Context, name, timestamp, reason string) error {
+	return m.updateMeta(ctx, name, timestamp, func(meta *release.Meta) {
+		meta.Yanked = &release.Yank{Reason: reason, Timestamp: release.Now()}
+	})
+}
+
+// UnyankRelease restores the yanked release with the `timestamp`.
+func (m *Memory) UnyankRelease(ctx context.Context, name, timestamp string) error {
+	return m.updateMeta(ctx, name, timestamp, func(meta *release.Meta) {
+		meta.Yanked = nil
+	})
+}
+
+func (m *Memory) updateMeta(ctx context.Context, name, timestamp string, fn func(*release.Meta)) error {
+	if err := m.delay(ctx); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.releases[name][timestamp]
+	if !ok {
+		return releaseNotFound(name, timestamp, "meta.yml not found %s", m.buildReleaseURL(name, timestamp))
+	}
+	meta, err := r.decodeMeta()
+	if err != nil {
+		return err
+	}
+	fn(meta)
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal yaml")
+	}
+	r.meta = data
+	return nil
+}
+
+// PruneReleases prunes the old releases which the retention policy doesn't keep,
+// and returns the retentions of all releases in ascending order of the timestamps.
+func (m *Memory) PruneReleases(ctx context.Context, name string, opts *PruneOptions) ([]*release.Retention, error) {
+	m.mu.RLock()
+	timestamps, err := m.ascTimestamps(name)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return pruneReleases(ctx, m, name, timestamps, func(ts string) (*release.Meta, error) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		r, ok := m.releases[name][ts]
+		if !ok {
+			return nil, nil
+		}
+		return r.decodeMeta()
+	}, opts)
+}
+
+// ListReleases returns the releases of the name without the bodies.
+func (m *Memory) ListReleases(ctx context.Context, name string) ([]*release.Release, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	timestamps, err := m.ascTimestamps(name)
+	if err != nil {
+		return nil, err
+	}
+	rels := make([]*release.Release, 0, len(timestamps))
+	for _, ts := range timestamps {
+		meta, err := m.releases[name][ts].decodeMeta()
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, release.New(meta, m.buildReleaseURL(name, ts)))
+	}
+	return rels, nil
+}
+
+// ProjectNames returns the sorted names of all projects.
+func (m *Memory) ProjectNames(ctx context.Context) ([]string, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.releases))
+	for name, byTimestamp := range m.releases {
+		if len(byTimestamp) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// WalkReleases walks the releases in the order of the prefixes.
+func (m *Memory) WalkReleases(ctx context.Context, concurrency int, walkfn func(*release.Release) error) error {
+	if err := m.delay(ctx); err != nil {
+		return err
+	}
+	m.mu.RLock()
+	var rels []*release.Release
+	for name, byTimestamp := range m.releases {
+		for ts, r := range byTimestamp {
+			rel, err := m.open(name, ts, r)
+			if err != nil {
+				m.mu.RUnlock()
+				return err
+			}
+			rels = append(rels, rel)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(rels, func(i, j int) bool { return rels[i].Prefix() < rels[j].Prefix() })
+
+	pool := grpool.NewPool(concurrency, jobQueueLen)
+	defer pool.Release()
+	var (
+		mu       sync.Mutex
+		foundErr error
+	)
+	for _, rel := range rels {
+		rel := rel
+		if ctx.Err() != nil {
+			break
+		}
+		pool.WaitCount(1)
+		pool.JobQueue <- func() {
+			defer pool.JobDone()
+			if ctx.Err() != nil {
+				return
+			}
+			if err := walkfn(rel); err != nil {
+				mu.Lock()
+				foundErr = errors.Wrapf(err, "failed to walk %s", rel.Prefix())
+				mu.Unlock()
+			}
+		}
+	}
+	pool.WaitAll()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return foundErr
+}
+
+// ChainLinks returns the links of the releases and the tombstones of the
+// project in ascending order of the timestamps.
+func (m *Memory) ChainLinks(ctx context.Context, name string) ([]*release.Link, error) {
+	if err := m.delay(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	links, err := m.chainLinks(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, projectNotFound(name)
+	}
+	return links, nil
+}
+
+// chainLinks returns the links of the name. It must be called with the lock.
+func (m *Memory) chainLinks(name string) ([]*release.Link, error) {
+	timestamps := make([]string, 0, len(m.releases[name]))
+	for ts := range m.releases[name] {
+		timestamps = append(timestamps, ts)
+	}
+	return mergeLinks(timestamps, m.tombstones[name], func(ts string) (*release.Meta, error) {
+		return m.releases[name][ts].decodeMeta()
+	})
+}
+
+// AppendAudit appends the record into the audit log.
+func (m *Memory) AppendAudit(ctx context.Context, rec *AuditRecord) error {
+	if err := m.delay(ctx); err != nil {
+		return err
+	}
+	key, err := auditKey(rec)
+	if err != nil {
+		return err
+	}
+	data, err := encodeAuditRecord(rec)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit[key] = data
+	return nil
+}
+
+// WalkAudit walks the records at or after since in the order of the time.
+func (m *Memory) WalkAudit(ctx context.Context, since time.Time, fn func(*AuditRecord) error) error {
+	if err := m.delay(ctx); err != nil {
+		return err
+	}
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.audit))
+	data := make(map[string][]byte, len(m.audit))
+	for key, d := range m.audit {
+		keys = append(keys, key)
+		data[key] = d
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, err := decodeAuditRecord(key, data[key])
+		if err != nil {
+			return err
+		}
+		if rec.Time.Before(since) {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns the endpoint of the storage.
+func (m *Memory) String() string {
+	return fmt.Sprintf("mem://%s", m.name)
+}
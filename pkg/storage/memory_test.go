@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/config"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	TestConformance(t, func(t *testing.T) API {
+		return NewMemory()
+	})
+}
+
+func TestMemory_failUploadAt(t *testing.T) {
+	st := NewMemory()
+	st.SetFaults(Faults{FailUploadAt: 2})
+
+	_, err := st.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", conformanceMeta(t, "droot-body", "grabeni-body"))
+
+	if errors.Cause(err) != ErrInjectedFault {
+		t.Fatalf("should raise the injected fault, got %v", err)
+	}
+	if ok, _ := st.ExistRelease(context.Background(), "github.com/yuuki/droot"); ok {
+		t.Errorf("the release should not be created on the failure")
+	}
+
+	// The fault is injected only into the Nth upload.
+	createConformanceRelease(t, st, "github.com/yuuki/droot", "20171017152508", "droot-body", "grabeni-body")
+}
+
+func TestMemory_faultErr(t *testing.T) {
+	st := NewMemory()
+	expected := errors.New("connection reset")
+	st.SetFaults(Faults{FailUploadAt: 1, Err: expected})
+
+	_, err := st.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", conformanceMeta(t, "droot-body"))
+
+	if errors.Cause(err) != expected {
+		t.Errorf("should raise %v, got %v", expected, err)
+	}
+}
+
+func TestMemory_latency(t *testing.T) {
+	st := NewMemory()
+	st.SetFaults(Faults{Latency: 20 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := st.ExistRelease(context.Background(), "github.com/yuuki/droot"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("got %s, want the latency of 20ms at least", elapsed)
+	}
+}
+
+func TestMemory_archiveAndRestore(t *testing.T) {
+	st := NewMemory()
+	name := "github.com/yuuki/droot"
+	createConformanceRelease(t, st, name, "20171017152508", "droot-body")
+
+	if err := st.ArchiveRelease(context.Background(), name, "20171017152508", "GLACIER"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if _, err := st.FindReleaseByTimestamp(context.Background(), name, "20171017152508"); err == nil {
+		t.Errorf("the archived release should not be found")
+	}
+	restored, err := st.RestoreRelease(context.Background(), name, "20171017152508")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if !restored {
+		t.Errorf("the restoration should complete immediately")
+	}
+	if _, err := st.FindReleaseByTimestamp(context.Background(), name, "20171017152508"); err != nil {
+		t.Errorf("should not raise error: %s", err)
+	}
+}
+
+func TestMemory_yank(t *testing.T) {
+	st := NewMemory()
+	name := "github.com/yuuki/droot"
+	createConformanceRelease(t, st, name, "20171017152508", "droot-old")
+	createConformanceRelease(t, st, name, "20171017152626", "droot-new")
+
+	if err := st.YankRelease(context.Background(), name, "20171017152626", "segfault on startup"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	rel, err := st.FindLatestRelease(context.Background(), name)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if rel.Timestamp() != "20171017152508" {
+		t.Errorf("got %q, want %q", rel.Timestamp(), "20171017152508")
+	}
+
+	if err := st.YankRelease(context.Background(), name, "20171017152508", "segfault on startup"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if _, err := st.FindLatestRelease(context.Background(), name); err == nil || !strings.Contains(err.Error(), "all releases are yanked") {
+		t.Errorf("should raise the error of the yanked releases, got %v", err)
+	}
+}
+
+func TestOpen_memory(t *testing.T) {
+	saved := config.Config.BackendEndpoint
+	defer func() { config.Config.BackendEndpoint = saved }()
+	config.Config.BackendEndpoint = "mem://binrep-open-testing"
+
+	st, err := Open()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	createConformanceRelease(t, st, "github.com/yuuki/droot", "20171017152508", "droot-body")
+
+	st, err = Open()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if ok, err := st.ExistRelease(context.Background(), "github.com/yuuki/droot"); err != nil || !ok {
+		t.Errorf("ExistRelease() = %v, %v; want the release shared within the process", ok, err)
+	}
+}
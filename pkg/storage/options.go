@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/config"
+)
+
+// ObjectOptions represents the settings of the objects put on S3.
+type ObjectOptions struct {
+	// SSE is the server-side encryption algorithm ('AES256' or 'aws:kms').
+	SSE string
+	// SSEKMSKeyID is the KMS key ID for the 'aws:kms' encryption.
+	SSEKMSKeyID string
+	// StorageClass is the storage class such as STANDARD_IA.
+	StorageClass string
+	Tags         map[string]string
+	Metadata     map[string]string
+}
+
+// ObjectOptionsFromConfig returns the object options of the config, which
+// are the defaults of the repository.
+func ObjectOptionsFromConfig() *ObjectOptions {
+	return &ObjectOptions{
+		SSE:          config.Config.SSE,
+		SSEKMSKeyID:  config.Config.SSEKMSKeyID,
+		StorageClass: config.Config.StorageClass,
+		Tags:         config.Config.ObjectTags,
+		Metadata:     config.Config.ObjectMetadata,
+	}
+}
+
+// Validate validates the options.
+func (o *ObjectOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	switch o.SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		return errors.Errorf("unsupported server-side encryption %q, want 'AES256' or 'aws:kms'", o.SSE)
+	}
+	if o.SSEKMSKeyID != "" && o.SSE != "aws:kms" {
+		return errors.New("--sse-kms-key-id requires --sse aws:kms")
+	}
+	switch o.StorageClass {
+	case "GLACIER", "DEEP_ARCHIVE":
+		// The objects must be readable by pull without restoring them.
+		return errors.Errorf("storage class %q is only available for archiving, use --archive-storage-class", o.StorageClass)
+	}
+	return nil
+}
+
+func (o *ObjectOptions) sse() (*string, *string) {
+	if o == nil || o.SSE == "" {
+		return nil, nil
+	}
+	if o.SSEKMSKeyID == "" {
+		return aws.String(o.SSE), nil
+	}
+	return aws.String(o.SSE), aws.String(o.SSEKMSKeyID)
+}
+
+func (o *ObjectOptions) storageClass() *string {
+	if o == nil || o.StorageClass == "" {
+		return nil
+	}
+	return aws.String(o.StorageClass)
+}
+
+// tagging encodes the tags as the URL query parameters for the x-amz-tagging header.
+func (o *ObjectOptions) tagging() *string {
+	if o == nil || len(o.Tags) == 0 {
+		return nil
+	}
+	v := url.Values{}
+	for key, val := range o.Tags {
+		v.Set(key, val)
+	}
+	return aws.String(v.Encode())
+}
+
+func (o *ObjectOptions) metadata() map[string]*string {
+	if o == nil || len(o.Metadata) == 0 {
+		return nil
+	}
+	return aws.StringMap(o.Metadata)
+}
+
+func (o *ObjectOptions) applyUpload(input *s3manager.UploadInput) {
+	input.ServerSideEncryption, input.SSEKMSKeyId = o.sse()
+	input.StorageClass = o.storageClass()
+	input.Tagging = o.tagging()
+	input.Metadata = o.metadata()
+}
+
+func (o *ObjectOptions) applyPut(input *s3.PutObjectInput) {
+	input.ServerSideEncryption, input.SSEKMSKeyId = o.sse()
+	input.StorageClass = o.storageClass()
+	input.Tagging = o.tagging()
+	input.Metadata = o.metadata()
+}
+
+// applyCopy applies the encryption, which is not inherited from the source
+// object unlike the tags and the metadata.
+func (o *ObjectOptions) applyCopy(input *s3.CopyObjectInput) {
+	input.ServerSideEncryption, input.SSEKMSKeyId = o.sse()
+	if input.StorageClass == nil {
+		input.StorageClass = o.storageClass()
+	}
+}
@@ -0,0 +1,499 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of the attempts of an operation.
+	DefaultMaxAttempts = 4
+	// DefaultBaseDelay is the default delay before the first retry.
+	DefaultBaseDelay = 200 * time.Millisecond
+	// DefaultMaxDelay is the default upper bound of the delay between the retries.
+	DefaultMaxDelay = 10 * time.Second
+)
+
+// RetryPolicy represents how the operations failing with ErrTransient are
+// retried. The delay before the nth retry is chosen at random between 0 and
+// min(MaxDelay, BaseDelay * 2^(n-1)), that is, the exponential backoff with
+// the full jitter.
+type RetryPolicy struct {
+	// MaxAttempts is the number of the attempts including the first one.
+	// The operations are not retried if it is 1 or less.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Logger receives the retries. It is nil to log them to the default
+	// logger.
+	Logger *slog.Logger
+}
+
+// NewRetryPolicy creates the policy of the attempts with the default delays.
+// DefaultMaxAttempts is used if maxAttempts is 0.
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	return &RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+		MaxDelay:    DefaultMaxDelay,
+	}
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// backoff returns the jittered delay before the nth retry.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	d := p.MaxDelay
+	if n < 32 && p.BaseDelay<<uint(n-1) < d {
+		d = p.BaseDelay << uint(n-1)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// wait waits for the delay before the nth retry, and returns the error of
+// the context if it is done.
+func (p *RetryPolicy) wait(ctx context.Context, n int, op string, err error) error {
+	d := p.backoff(n)
+	loggerOf(p.Logger).Warn("Retrying "+op, "delay", d, "attempt", n+1, "max_attempts", p.maxAttempts(), "error", err)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Do calls fn with the attempt number from 1 until it succeeds, it fails
+// with the error other than ErrTransient, or the attempts run out. The last
+// error is returned.
+func (p *RetryPolicy) Do(ctx context.Context, op string, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !errors.Is(err, ErrTransient) || attempt >= p.maxAttempts() {
+			return err
+		}
+		if ctx.Err() != nil || p.wait(ctx, attempt, op, err) != nil {
+			return err
+		}
+	}
+}
+
+// BinaryOpener is implemented by the storages which can open the body of the
+// binary from the offset, so that the interrupted download is resumed
+// without downloading the body from the beginning.
+type BinaryOpener interface {
+	OpenBinary(ctx context.Context, name, timestamp, binName string, offset int64) (io.ReadCloser, error)
+}
+
+type retryStorage struct {
+	st     API
+	policy *RetryPolicy
+}
+
+// WithRetry returns the storage retrying the operations of st failing with
+// ErrTransient by the policy. Each operation is retried only if it is safe
+// to do so:
+//
+//   - CreateRelease is retried only if all bodies are io.Seeker to read them
+//     again, and the conflict with the release created by the previous
+//     attempt is regarded as the success.
+//   - DeleteRelease, ArchiveRelease and RestoreRelease regard the missing
+//     release on the retry as done by the previous attempt.
+//   - WalkReleases is not retried because walkfn may not be idempotent.
+//
+// The bodies of the found releases are resumed from where they are
+// interrupted, with BinaryOpener if st implements it, or by skipping the
+// bytes already read from the body opened again otherwise.
+func WithRetry(st API, policy *RetryPolicy) API {
+	return &retryStorage{st: st, policy: policy}
+}
+
+// SetLogger sets the logger of the retries and of the wrapped storage.
+func (s *retryStorage) SetLogger(logger *slog.Logger) {
+	s.policy.Logger = logger
+	SetLogger(s.st, logger)
+}
+
+// ExistRelease returns whether the name exists or not.
+func (s *retryStorage) ExistRelease(ctx context.Context, name string) (bool, error) {
+	var ok bool
+	err := s.policy.Do(ctx, "checking "+name, func(int) error {
+		var err error
+		ok, err = s.st.ExistRelease(ctx, name)
+		return err
+	})
+	return ok, err
+}
+
+// HaveSameChecksums returns whether each checksum of given binaries is
+// the same or not with each checksum of binaries of the latest release.
+func (s *retryStorage) HaveSameChecksums(ctx context.Context, name string, bins []*release.Binary) (bool, error) {
+	var ok bool
+	err := s.policy.Do(ctx, "comparing checksums of "+name, func(int) error {
+		var err error
+		ok, err = s.st.HaveSameChecksums(ctx, name, bins)
+		return err
+	})
+	return ok, err
+}
+
+// FindLatestRelease finds the release including the latest timestamp.
+func (s *retryStorage) FindLatestRelease(ctx context.Context, name string) (*release.Release, error) {
+	var rel *release.Release
+	err := s.policy.Do(ctx, "finding the latest release of "+name, func(int) error {
+		var err error
+		rel, err = s.st.FindLatestRelease(ctx, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.resumeBodies(ctx, rel)
+	return rel, nil
+}
+
+// FindReleaseByTimestamp finds the release including the `timestamp`.
+func (s *retryStorage) FindReleaseByTimestamp(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	var rel *release.Release
+	err := s.policy.Do(ctx, "finding "+name+"/"+timestamp, func(int) error {
+		var err error
+		rel, err = s.st.FindReleaseByTimestamp(ctx, name, timestamp)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.resumeBodies(ctx, rel)
+	return rel, nil
+}
+
+// FindReleaseMeta finds the release of the timestamp or the latest release
+// without the bodies.
+func (s *retryStorage) FindReleaseMeta(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	op := "finding the latest release of " + name
+	if timestamp != "" {
+		op = "finding " + name + "/" + timestamp
+	}
+	var rel *release.Release
+	err := s.policy.Do(ctx, op, func(int) error {
+		var err error
+		rel, err = s.st.FindReleaseMeta(ctx, name, timestamp)
+		return err
+	})
+	return rel, err
+}
+
+// CreateRelease creates the release of the meta.
+func (s *retryStorage) CreateRelease(ctx context.Context, name string, timestamp string, meta *release.Meta) (*release.Release, error) {
+	offsets, ok := bodyOffsets(meta)
+	if !ok {
+		// The bodies can't be read again.
+		return s.st.CreateRelease(ctx, name, timestamp, meta)
+	}
+	var rel *release.Release
+	err := s.policy.Do(ctx, "creating "+name+"/"+timestamp, func(attempt int) error {
+		if attempt > 1 {
+			if err := seekBodies(meta, offsets); err != nil {
+				return err
+			}
+		}
+		var err error
+		rel, err = s.st.CreateRelease(ctx, name, timestamp, meta)
+		if attempt > 1 && errors.Is(err, ErrConflict) {
+			// The previous attempt may have created it before failing.
+			if existing := s.createdBy(ctx, name, timestamp, meta); existing != nil {
+				rel, err = release.New(meta, existing.URL), nil
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// createdBy returns the existing release of the timestamp if it has the
+// same binaries as meta, or nil.
+func (s *retryStorage) createdBy(ctx context.Context, name, timestamp string, meta *release.Meta) *release.Release {
+	existing, err := s.st.FindReleaseByTimestamp(ctx, name, timestamp)
+	if err != nil {
+		return nil
+	}
+	closeBodies(existing)
+	if len(existing.Meta.Binaries) != len(meta.Binaries) {
+		return nil
+	}
+	for i, bin := range existing.Meta.Binaries {
+		if bin.Name != meta.Binaries[i].Name || bin.Checksum != meta.Binaries[i].Checksum {
+			return nil
+		}
+	}
+	return existing
+}
+
+// DeleteRelease deletes the release with the `timestamp`.
+func (s *retryStorage) DeleteRelease(ctx context.Context, name, timestamp string) error {
+	return s.policy.Do(ctx, "deleting "+name+"/"+timestamp, func(attempt int) error {
+		err := s.st.DeleteRelease(ctx, name, timestamp)
+		if attempt > 1 && errors.Is(err, ErrReleaseNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// ArchiveRelease moves the release with the `timestamp` into the archive.
+func (s *retryStorage) ArchiveRelease(ctx context.Context, name, timestamp, storageClass string) error {
+	return s.policy.Do(ctx, "archiving "+name+"/"+timestamp, func(attempt int) error {
+		err := s.st.ArchiveRelease(ctx, name, timestamp, storageClass)
+		if attempt > 1 && errors.Is(err, ErrReleaseNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// RestoreRelease moves the archived release with the `timestamp` back.
+func (s *retryStorage) RestoreRelease(ctx context.Context, name, timestamp string) (bool, error) {
+	var ok bool
+	err := s.policy.Do(ctx, "restoring "+name+"/"+timestamp, func(attempt int) error {
+		var err error
+		ok, err = s.st.RestoreRelease(ctx, name, timestamp)
+		if attempt > 1 && errors.Is(err, ErrReleaseNotFound) {
+			ok, err = true, nil
+		}
+		return err
+	})
+	return ok, err
+}
+
+// YankRelease marks the release with the `timestamp` as yanked with the reason.
+func (s *retryStorage) YankRelease(ctx context.Context, name, timestamp, reason string) error {
+	return s.policy.Do(ctx, "yanking "+name+"/"+timestamp, func(int) error {
+		return s.st.YankRelease(ctx, name, timestamp, reason)
+	})
+}
+
+// UnyankRelease restores the yanked release with the `timestamp`.
+func (s *retryStorage) UnyankRelease(ctx context.Context, name, timestamp string) error {
+	return s.policy.Do(ctx, "unyanking "+name+"/"+timestamp, func(int) error {
+		return s.st.UnyankRelease(ctx, name, timestamp)
+	})
+}
+
+// PruneReleases prunes the old releases. The retry applies the policy to the
+// releases left by the previous attempt, so that the retentions of the
+// releases pruned by it are not returned.
+func (s *retryStorage) PruneReleases(ctx context.Context, name string, opts *PruneOptions) ([]*release.Retention, error) {
+	var rets []*release.Retention
+	err := s.policy.Do(ctx, "pruning "+name, func(int) error {
+		var err error
+		rets, err = s.st.PruneReleases(ctx, name, opts)
+		return err
+	})
+	return rets, err
+}
+
+// WalkReleases walks the releases without retrying.
+func (s *retryStorage) WalkReleases(ctx context.Context, concurrency int, walkfn func(*release.Release) error) error {
+	return s.st.WalkReleases(ctx, concurrency, walkfn)
+}
+
+// ListReleases returns the releases of the project.
+func (s *retryStorage) ListReleases(ctx context.Context, name string) ([]*release.Release, error) {
+	var rels []*release.Release
+	err := s.policy.Do(ctx, "listing the releases of "+name, func(int) error {
+		var err error
+		rels, err = s.st.ListReleases(ctx, name)
+		return err
+	})
+	return rels, err
+}
+
+// ProjectNames returns the names of all projects.
+func (s *retryStorage) ProjectNames(ctx context.Context) ([]string, error) {
+	var names []string
+	err := s.policy.Do(ctx, "listing the projects", func(int) error {
+		var err error
+		names, err = s.st.ProjectNames(ctx)
+		return err
+	})
+	return names, err
+}
+
+// ChainLinks returns the links of the releases and the tombstones of the project.
+func (s *retryStorage) ChainLinks(ctx context.Context, name string) ([]*release.Link, error) {
+	var links []*release.Link
+	err := s.policy.Do(ctx, "listing the chain of "+name, func(int) error {
+		var err error
+		links, err = s.st.ChainLinks(ctx, name)
+		return err
+	})
+	return links, err
+}
+
+// resumeBodies replaces the bodies of rel with the readers resuming them.
+func (s *retryStorage) resumeBodies(ctx context.Context, rel *release.Release) {
+	name, timestamp := rel.Name(), rel.Timestamp()
+	for _, bin := range rel.Meta.Binaries {
+		if bin.IsLink() || bin.Body == nil {
+			continue
+		}
+		binName := bin.Name
+		bin.Body = &resumableReader{
+			ctx:    ctx,
+			policy: s.policy,
+			op:     "downloading " + name + "/" + timestamp + "/" + binName,
+			body:   bin.Body,
+			open: func(offset int64) (io.Reader, error) {
+				return s.openBinary(ctx, name, timestamp, binName, offset)
+			},
+		}
+	}
+}
+
+// openBinary opens the body of the binary from the offset.
+func (s *retryStorage) openBinary(ctx context.Context, name, timestamp, binName string, offset int64) (io.Reader, error) {
+	if o, ok := s.st.(BinaryOpener); ok {
+		return o.OpenBinary(ctx, name, timestamp, binName, offset)
+	}
+	rel, err := s.st.FindReleaseByTimestamp(ctx, name, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	for _, bin := range rel.Meta.Binaries {
+		if bin.Name == binName {
+			body = bin.Body
+			continue
+		}
+		if c, ok := bin.Body.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	if body == nil {
+		return nil, releaseNotFound(name, timestamp, "not found %v/%v/%v", name, timestamp, binName)
+	}
+	if _, err := io.CopyN(ioutil.Discard, body, offset); err != nil {
+		return nil, errors.Wrapf(err, "failed to skip %d bytes of %v/%v/%v", offset, name, timestamp, binName)
+	}
+	return body, nil
+}
+
+// resumableReader reads the body, and opens it again from the offset read
+// so far if it fails with ErrTransient, so that no byte is read twice.
+type resumableReader struct {
+	ctx     context.Context
+	policy  *RetryPolicy
+	op      string
+	body    io.Reader
+	open    func(offset int64) (io.Reader, error)
+	offset  int64
+	retries int
+}
+
+// Read reads the body, resuming it on the transient failures.
+func (r *resumableReader) Read(p []byte) (int, error) {
+	for {
+		var (
+			n   int
+			err error
+		)
+		if r.body == nil {
+			r.body, err = r.open(r.offset)
+		}
+		if r.body != nil {
+			n, err = r.body.Read(p)
+			r.offset += int64(n)
+		}
+		if err == nil || err == io.EOF || !errors.Is(err, ErrTransient) || r.ctx.Err() != nil {
+			return n, err
+		}
+		r.Close()
+		r.body = nil
+		r.retries++
+		if r.retries >= r.policy.maxAttempts() {
+			return n, err
+		}
+		if n > 0 {
+			// Open the body again on the next Read.
+			return n, nil
+		}
+		if werr := r.policy.wait(r.ctx, r.retries, r.op, err); werr != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close closes the body if it is io.Closer.
+func (r *resumableReader) Close() error {
+	if c, ok := r.body.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// bodyOffsets returns the current offsets of the bodies of meta, and whether
+// all bodies are io.Seeker or not.
+func bodyOffsets(meta *release.Meta) ([]int64, bool) {
+	offsets := make([]int64, len(meta.Binaries))
+	for i, bin := range meta.Binaries {
+		if bin.IsLink() {
+			continue
+		}
+		seeker, ok := bin.Body.(io.Seeker)
+		if !ok {
+			return nil, false
+		}
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, false
+		}
+		offsets[i] = offset
+	}
+	return offsets, true
+}
+
+// seekBodies seeks the bodies of meta back to the offsets.
+func seekBodies(meta *release.Meta, offsets []int64) error {
+	for i, bin := range meta.Binaries {
+		if bin.IsLink() {
+			continue
+		}
+		if _, err := bin.Body.(io.Seeker).Seek(offsets[i], io.SeekStart); err != nil {
+			return errors.Wrapf(err, "failed to rewind %s", bin.Name)
+		}
+	}
+	return nil
+}
+
+// closeBodies closes the bodies of rel which are io.Closer.
+func closeBodies(rel *release.Release) {
+	for _, bin := range rel.Meta.Binaries {
+		if c, ok := bin.Body.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yuuki/binrep/pkg/release"
+)
+
+var testRetryPolicy = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+func TestRetryConformance(t *testing.T) {
+	TestConformance(t, func(t *testing.T) API {
+		return WithRetry(NewMemory(), testRetryPolicy)
+	})
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		n   int
+		max time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+	for _, tc := range tests {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(tc.n); d < 0 || d > tc.max {
+				t.Fatalf("n: %d, got %s, want between 0 and %s", tc.n, d, tc.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	transient := &TransientError{Err: errors.New("connection reset")}
+	tests := []struct {
+		desc     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"transient", []error{transient, transient, nil}, 3, nil},
+		{"exhausted", []error{transient, transient, transient, nil}, 3, transient},
+		{"permanent", []error{errors.New("access denied"), nil}, 1, errors.New("access denied")},
+	}
+	for _, tc := range tests {
+		attempts := 0
+		err := testRetryPolicy.Do(context.Background(), "testing", func(attempt int) error {
+			attempts++
+			if attempt != attempts {
+				t.Errorf("desc: %s, got attempt %d, want %d", tc.desc, attempt, attempts)
+			}
+			return tc.errs[attempt-1]
+		})
+		if attempts != tc.attempts {
+			t.Errorf("desc: %s, got %d attempts, want %d", tc.desc, attempts, tc.attempts)
+		}
+		if (err == nil) != (tc.err == nil) || (err != nil && err.Error() != tc.err.Error()) {
+			t.Errorf("desc: %s, got %v, want %v", tc.desc, err, tc.err)
+		}
+	}
+}
+
+func TestRetryPolicy_Do_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	attempts := 0
+
+	err := p.Do(ctx, "testing", func(int) error {
+		attempts++
+		cancel()
+		return ErrInjectedFault
+	})
+
+	if err != ErrInjectedFault || attempts != 1 {
+		t.Errorf("got %v after %d attempts, want %v after 1 attempt", err, attempts, ErrInjectedFault)
+	}
+}
+
+func TestWithRetry_setLogger(t *testing.T) {
+	var buf bytes.Buffer
+	p := &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	SetLogger(WithRetry(NewMemory(), p), slog.New(slog.NewTextHandler(&buf, nil)))
+
+	err := p.Do(context.Background(), "testing", func(attempt int) error {
+		if attempt == 1 {
+			return &TransientError{Err: errors.New("connection reset")}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if !strings.Contains(buf.String(), "Retrying testing") {
+		t.Errorf("got %q, want the retry logged to the logger", buf.String())
+	}
+}
+
+func TestWithRetry_createRelease(t *testing.T) {
+	m := NewMemory()
+	m.SetFaults(Faults{FailUploadAt: 2})
+	st := WithRetry(m, testRetryPolicy)
+
+	createConformanceRelease(t, st, "github.com/yuuki/droot", "20171017152508", "droot-body", "grabeni-body")
+
+	rel, err := st.FindReleaseByTimestamp(context.Background(), "github.com/yuuki/droot", "20171017152508")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	for i, bin := range rel.Meta.Binaries {
+		body, err := ioutil.ReadAll(bin.Body)
+		if err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+		// The bodies are read again from the start on the retry.
+		if want := []string{"droot-body", "grabeni-body"}[i]; string(body) != want {
+			t.Errorf("got %q, want %q", body, want)
+		}
+	}
+}
+
+func TestWithRetry_createRelease_notSeekable(t *testing.T) {
+	m := NewMemory()
+	m.SetFaults(Faults{FailUploadAt: 1})
+	st := WithRetry(m, testRetryPolicy)
+	meta := conformanceMeta(t, "droot-body")
+	meta.Binaries[0].Body = ioutil.NopCloser(strings.NewReader("droot-body"))
+
+	_, err := st.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", meta)
+
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("the body which can't be read again should not be retried, got %v", err)
+	}
+}
+
+// lostResponseStorage creates the release, but fails with the transient error
+// as if the response were lost.
+type lostResponseStorage struct {
+	API
+	lost bool
+}
+
+func (s *lostResponseStorage) CreateRelease(ctx context.Context, name string, timestamp string, meta *release.Meta) (*release.Release, error) {
+	rel, err := s.API.CreateRelease(ctx, name, timestamp, meta)
+	if err == nil && !s.lost {
+		s.lost = true
+		return nil, &TransientError{Err: errors.New("connection reset")}
+	}
+	return rel, err
+}
+
+func TestWithRetry_createRelease_lostResponse(t *testing.T) {
+	st := WithRetry(&lostResponseStorage{API: NewMemory()}, testRetryPolicy)
+
+	rel, err := st.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", conformanceMeta(t, "droot-body"))
+
+	if err != nil {
+		t.Fatalf("the release created by the previous attempt should be regarded as success: %s", err)
+	}
+	if rel.Prefix() != "github.com/yuuki/droot/20171017152508" {
+		t.Errorf("got %q, want %q", rel.Prefix(), "github.com/yuuki/droot/20171017152508")
+	}
+
+	_, err = st.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", conformanceMeta(t, "another-body"))
+
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("should raise ErrConflict, got %v", err)
+	}
+}
+
+// noOpenerStorage hides BinaryOpener of the storage.
+type noOpenerStorage struct {
+	API
+}
+
+func TestWithRetry_resumeBody(t *testing.T) {
+	body := strings.Repeat("droot-body", 1000)
+	tests := []struct {
+		desc string
+		st   func(m *Memory) API
+	}{
+		{"range", func(m *Memory) API { return m }},
+		{"skip", func(m *Memory) API { return &noOpenerStorage{m} }},
+	}
+	for _, tc := range tests {
+		m := NewMemory()
+		st := WithRetry(tc.st(m), testRetryPolicy)
+		createConformanceRelease(t, st, "github.com/yuuki/droot", "20171017152508", body)
+		rel, err := st.FindLatestRelease(context.Background(), "github.com/yuuki/droot")
+		if err != nil {
+			t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+		// Fail in the middle of the body.
+		m.SetFaults(Faults{FailReadAt: 3})
+
+		var got []byte
+		buf := make([]byte, 1024)
+		for {
+			n, err := rel.Meta.Binaries[0].Body.Read(buf)
+			got = append(got, buf[:n]...)
+			if err != nil {
+				if err.Error() != "EOF" {
+					t.Fatalf("desc: %s, should not raise error: %s", tc.desc, err)
+				}
+				break
+			}
+		}
+
+		if string(got) != body {
+			t.Errorf("desc: %s, got %d bytes, want %d bytes without the bytes read twice", tc.desc, len(got), len(body))
+		}
+	}
+}
+
+func TestWithRetry_resumeBody_exhausted(t *testing.T) {
+	m := NewMemory()
+	st := WithRetry(m, testRetryPolicy)
+	createConformanceRelease(t, st, "github.com/yuuki/droot", "20171017152508", "droot-body")
+	rel, err := st.FindLatestRelease(context.Background(), "github.com/yuuki/droot")
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	m.SetFaults(Faults{FailReadAt: 1})
+	bin := rel.Meta.Binaries[0]
+	bin.Body.(*resumableReader).retries = testRetryPolicy.MaxAttempts - 1
+
+	_, err = ioutil.ReadAll(bin.Body)
+
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("should raise the transient error after the attempts run out, got %v", err)
+	}
+}
+
+func TestWithRetry_deleteRelease(t *testing.T) {
+	m := NewMemory()
+	st := WithRetry(m, testRetryPolicy)
+	createConformanceRelease(t, st, "github.com/yuuki/droot", "20171017152508", "droot-body")
+
+	if err := st.DeleteRelease(context.Background(), "github.com/yuuki/droot", "20171017152508"); err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if err := st.DeleteRelease(context.Background(), "github.com/yuuki/droot", "20171017152508"); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("the missing release on the first attempt should raise ErrReleaseNotFound, got %v", err)
+	}
+}
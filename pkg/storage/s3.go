@@ -2,56 +2,103 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/url"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/ivpusic/grpool"
 	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v2"
 
-	"github.com/yuuki/binrep/pkg/config"
 	"github.com/yuuki/binrep/pkg/release"
 )
 
 const (
 	jobQueueLen = 100
+
+	// archivePrefix is the key prefix under which the archived releases are stored.
+	archivePrefix = "archive/"
+	// tombstonePrefix is the key prefix under which the tombstones of the
+	// deleted or archived releases are stored as <name>/<timestamp>.yml.
+	// The tombstones are never pruned to keep the chain.
+	tombstonePrefix = "_tombstones/"
+	// restoreDays is the number of days for which the objects restored from
+	// GLACIER or DEEP_ARCHIVE are available until they are copied back.
+	restoreDays int64 = 1
+	// cleanupTimeout is the timeout of cleaning up the partial release.
+	cleanupTimeout = 30 * time.Second
 )
 
 type s3API interface {
-	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
-	ListObjectsV2(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
-	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
-	DeleteObject(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+	ListObjectsV2WithContext(aws.Context, *s3.ListObjectsV2Input, ...request.Option) (*s3.ListObjectsV2Output, error)
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+	DeleteObjectWithContext(aws.Context, *s3.DeleteObjectInput, ...request.Option) (*s3.DeleteObjectOutput, error)
+	CopyObjectWithContext(aws.Context, *s3.CopyObjectInput, ...request.Option) (*s3.CopyObjectOutput, error)
+	HeadObjectWithContext(aws.Context, *s3.HeadObjectInput, ...request.Option) (*s3.HeadObjectOutput, error)
+	RestoreObjectWithContext(aws.Context, *s3.RestoreObjectInput, ...request.Option) (*s3.RestoreObjectOutput, error)
+}
+
+type stsAPI interface {
+	GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error)
 }
 
 type s3UploaderAPI interface {
-	Upload(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+	UploadWithContext(aws.Context, *s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
 }
 
 type _s3 struct {
 	bucket   string
 	svc      s3API
 	uploader s3UploaderAPI
+	sts      stsAPI // nil if the endpoint has no STS
+	opts     *ObjectOptions
+	logger   *slog.Logger
+}
+
+// New creates a StorageAPI client object of the bucket.
+func New(sess *session.Session, bucket string) API {
+	return newS3(sess, bucket, nil)
+}
+
+// NewWithOptions creates a StorageAPI client object of the bucket, which
+// puts the objects with opts.
+func NewWithOptions(sess *session.Session, bucket string, opts *ObjectOptions) API {
+	return newS3(sess, bucket, opts)
 }
 
-// New creates a StorageAPI client object.
-func New(sess *session.Session) API {
-	return &_s3{
-		bucket:   strings.TrimPrefix(config.Config.BackendEndpoint, "s3://"),
+func newS3(sess *session.Session, bucket string, opts *ObjectOptions) *_s3 {
+	s := &_s3{
+		bucket:   bucket,
 		svc:      s3.New(sess),
 		uploader: s3manager.NewUploader(sess),
+		opts:     opts,
+	}
+	// The S3-compatible servers such as MinIO have no STS at the endpoint.
+	if aws.StringValue(sess.Config.Endpoint) == "" {
+		s.sts = sts.New(sess)
 	}
+	return s
+}
+
+// SetLogger sets the logger of the cleanups and of the walks.
+func (s *_s3) SetLogger(logger *slog.Logger) {
+	s.logger = logger
 }
 
 // BuildReleaseURL builds the binary file url for S3.
@@ -65,14 +112,14 @@ func (s *_s3) buildReleaseURL(name, timestamp string) (*url.URL, error) {
 }
 
 // ExistRelease returns whether the name exists or not.
-func (s *_s3) ExistRelease(name string) (bool, error) {
-	resp, err := s.svc.ListObjectsV2(&s3.ListObjectsV2Input{
+func (s *_s3) ExistRelease(ctx context.Context, name string) (bool, error) {
+	resp, err := s.svc.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket:    aws.String(s.bucket),
 		Prefix:    aws.String(name + "/"),
 		Delimiter: aws.String("/"),
 	})
 	if err != nil {
-		return false, errors.Wrapf(err, "failed to list objects (bucket: %v, path: %v/)", s.bucket, name)
+		return false, wrapS3Error(err, "failed to list objects (bucket: %v, path: %v/)", s.bucket, name)
 	}
 	if len(resp.CommonPrefixes) < 1 {
 		// not found name
@@ -83,12 +130,16 @@ func (s *_s3) ExistRelease(name string) (bool, error) {
 
 // HaveSameChecksums returns whether each checksum of given binaries is
 // the same or not with each checksum of binaries on the S3.
-func (s *_s3) HaveSameChecksums(name string, bins []*release.Binary) (bool, error) {
-	latestRel, err := s.FindLatestRelease(name)
+func (s *_s3) HaveSameChecksums(ctx context.Context, name string, bins []*release.Binary) (bool, error) {
+	_, latestMeta, err := s.findLatestAvailableMeta(ctx, name)
 	if err != nil {
 		return false, err
 	}
-	for _, lbin := range latestRel.Meta.Binaries {
+	if latestMeta == nil {
+		// all releases are yanked
+		return false, nil
+	}
+	for _, lbin := range latestMeta.Binaries {
 		for _, bin := range bins {
 			if bin.Name == lbin.Name {
 				if bin.Checksum != lbin.Checksum {
@@ -100,107 +151,188 @@ func (s *_s3) HaveSameChecksums(name string, bins []*release.Binary) (bool, erro
 	return true, nil
 }
 
-// FindLatestRelease finds the release including the latest timestamp.
-func (s *_s3) FindLatestRelease(name string) (*release.Release, error) {
-	latest, err := s.latestTimestamp(name)
+// FindLatestRelease finds the release including the latest timestamp,
+// skipping the yanked releases.
+func (s *_s3) FindLatestRelease(ctx context.Context, name string) (*release.Release, error) {
+	u, meta, err := s.findLatestAvailableMeta(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	u, err := s.buildReleaseURL(name, latest)
+	if meta == nil {
+		return nil, releaseNotFound(name, "", "no available releases of %v: all releases are yanked", name)
+	}
+	if err := s.openBinaryBodies(ctx, u, meta); err != nil {
+		return nil, err
+	}
+	return release.New(meta, u), nil
+}
+
+// findLatestAvailableMeta finds the metadata of the latest release which
+// is not yanked without opening the binary bodies. It returns nil if all
+// releases are yanked.
+func (s *_s3) findLatestAvailableMeta(ctx context.Context, name string) (*url.URL, *release.Meta, error) {
+	timestamps, err := s.ascTimestamps(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := len(timestamps) - 1; i >= 0; i-- {
+		u, err := s.buildReleaseURL(name, timestamps[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		meta, err := s.getMeta(ctx, u)
+		if err != nil {
+			return nil, nil, err
+		}
+		if meta == nil {
+			return nil, nil, releaseNotFound(name, timestamps[i], "meta.yml not found %s", u)
+		}
+		if meta.IsYanked() {
+			continue
+		}
+		return u, meta, nil
+	}
+	return nil, nil, nil
+}
+
+// FindReleaseByTimestamp finds the release including the `timestamp`.
+func (s *_s3) FindReleaseByTimestamp(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	u, err := s.buildReleaseURL(name, timestamp)
 	if err != nil {
 		return nil, err
 	}
-	meta, err := s.FindMeta(u)
+	meta, err := s.FindMeta(ctx, u)
 	if err != nil {
 		return nil, err
 	}
 	if meta == nil {
-		return nil, errors.Errorf("meta.yml not found %s", u)
+		return nil, releaseNotFound(name, timestamp, "meta.yml not found %s", u)
 	}
 	return release.New(meta, u), nil
 }
 
-// FindReleaseByTimestamp finds the release including the `timestamp`.
-func (s *_s3) FindReleaseByTimestamp(name, timestamp string) (*release.Release, error) {
+// FindReleaseMeta finds the release of the timestamp, or the latest release
+// which is not yanked if timestamp is empty, without opening the binary
+// bodies.
+func (s *_s3) FindReleaseMeta(ctx context.Context, name, timestamp string) (*release.Release, error) {
+	if timestamp == "" {
+		u, meta, err := s.findLatestAvailableMeta(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, releaseNotFound(name, "", "no available releases of %v: all releases are yanked", name)
+		}
+		return release.New(meta, u), nil
+	}
 	u, err := s.buildReleaseURL(name, timestamp)
 	if err != nil {
 		return nil, err
 	}
-	meta, err := s.FindMeta(u)
+	meta, err := s.getMeta(ctx, u)
 	if err != nil {
 		return nil, err
 	}
 	if meta == nil {
-		return nil, errors.Errorf("meta.yml not found %s", u)
+		return nil, releaseNotFound(name, timestamp, "meta.yml not found %s", u)
 	}
 	return release.New(meta, u), nil
 }
 
-// CreateRelease creates the release on S3.
-func (s *_s3) CreateRelease(name string, timestamp string, bins []*release.Binary) (*release.Release, error) {
+// CreateRelease creates the release of the meta on S3. The existing release
+// is checked before uploading, not by the conditional put, which the S3 API
+// of the SDK doesn't support, so two concurrent pushes of the same timestamp
+// may both succeed.
+func (s *_s3) CreateRelease(ctx context.Context, name string, timestamp string, meta *release.Meta) (*release.Release, error) {
 	u, err := s.buildReleaseURL(name, timestamp)
 	if err != nil {
 		return nil, err
 	}
-	meta, err := s.createMeta(u, bins)
+	existing, err := s.getMeta(ctx, u)
 	if err != nil {
 		return nil, err
 	}
+	if existing != nil {
+		return nil, conflict(name, timestamp, u.String())
+	}
+	last, err := s.lastLink(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	meta.Chain = last.NextChain()
+	// Upload meta.yml last so that the release is not found until all the
+	// binaries are uploaded, and remove the uploaded binaries on failure.
+	var keys []string
 	for _, bin := range meta.Binaries {
-		_, err := s.uploader.Upload(&s3manager.UploadInput{
+		if bin.IsLink() {
+			continue
+		}
+		key := filepath.Join(u.Path, bin.Name)
+		input := &s3manager.UploadInput{
 			Bucket: aws.String(s.bucket),
-			Key:    aws.String(filepath.Join(u.Path, bin.Name)),
+			Key:    aws.String(key),
 			Body:   bin.Body,
-		})
+		}
+		s.opts.applyUpload(input)
+		_, err := s.uploader.UploadWithContext(ctx, input)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to upload file to %s", u)
+			s.cleanupKeys(keys)
+			return nil, wrapS3Error(err, "failed to upload file to %s", u)
 		}
+		keys = append(keys, key)
+	}
+	if err := s.putMeta(ctx, u, meta); err != nil {
+		s.cleanupKeys(keys)
+		return nil, err
 	}
 	return release.New(meta, u), nil
 }
 
-// latestTimestamp gets the latest timestamp.
-func (s *_s3) latestTimestamp(name string) (string, error) {
-	resp, err := s.svc.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket:    aws.String(s.bucket),
-		Prefix:    aws.String(name + "/"),
-		Delimiter: aws.String("/"),
-	})
-	if err != nil {
-		return "", errors.Wrapf(err, "failed to list objects (bucket: %v, path: %v/)", s.bucket, name)
+// cleanupKeys deletes the keys of the partial release within cleanupTimeout.
+// It doesn't use the context of the request, which may be already canceled.
+func (s *_s3) cleanupKeys(keys []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := s.deleteKeys(ctx, keys); err != nil {
+		loggerOf(s.logger).Error("Failed to clean up the partial release", "error", err)
 	}
-	if len(resp.CommonPrefixes) < 1 {
-		return "", errors.Errorf("no such projects %v", name)
-	}
-	timestamps := make([]string, 0, len(resp.CommonPrefixes))
-	for _, cp := range resp.CommonPrefixes {
-		timestamps = append(timestamps, filepath.Base(*cp.Prefix))
-	}
-	sort.Strings(timestamps)
-	return timestamps[len(timestamps)-1], nil
 }
 
-// createMeta creates the meta.yml on S3.
-func (s *_s3) createMeta(u *url.URL, bins []*release.Binary) (*release.Meta, error) {
-	m := release.NewMeta(bins)
+// putMeta puts the meta.yml on S3.
+func (s *_s3) putMeta(ctx context.Context, u *url.URL, m *release.Meta) error {
 	data, err := yaml.Marshal(m)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal yaml")
+		return errors.Wrap(err, "failed to marshal yaml")
 	}
-	_, err = s.svc.PutObject(&s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(filepath.Join(u.Path, release.MetaFileName)),
 		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
-	})
+	}
+	s.opts.applyPut(input)
+	_, err = s.svc.PutObjectWithContext(ctx, input)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to put meta.yml into s3 (%s)", u)
+		return wrapS3Error(err, "failed to put meta.yml into s3 (%s)", u)
 	}
-	return m, nil
+	return nil
 }
 
 // FindMeta finds metadata from S3, and returns nil if meta.yml is not found.
-func (s *_s3) FindMeta(u *url.URL) (*release.Meta, error) {
-	resp, err := s.svc.GetObject(&s3.GetObjectInput{
+func (s *_s3) FindMeta(ctx context.Context, u *url.URL) (*release.Meta, error) {
+	m, err := s.getMeta(ctx, u)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	if err := s.openBinaryBodies(ctx, u, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// getMeta gets metadata from S3 without opening the binary bodies, and
+// returns nil if meta.yml is not found.
+func (s *_s3) getMeta(ctx context.Context, u *url.URL) (*release.Meta, error) {
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(filepath.Join(u.Path, release.MetaFileName)),
 	})
@@ -212,8 +344,9 @@ func (s *_s3) FindMeta(u *url.URL) (*release.Meta, error) {
 			default:
 			}
 		}
-		return nil, errors.Wrapf(err, "failed to get object from s3 %s", u)
+		return nil, wrapS3Error(err, "failed to get object from s3 %s", u)
 	}
+	defer resp.Body.Close()
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read meta.yml on s3")
@@ -222,19 +355,28 @@ func (s *_s3) FindMeta(u *url.URL) (*release.Meta, error) {
 	if err := yaml.Unmarshal(data, &m); err != nil {
 		return nil, errors.Wrapf(err, "failed to read meta.yml on s3")
 	}
+	return &m, nil
+}
+
+// openBinaryBodies opens the bodies of the binaries of the metadata.
+func (s *_s3) openBinaryBodies(ctx context.Context, u *url.URL, m *release.Meta) error {
+	var err error
 	for _, b := range m.Binaries {
-		b.Body, err = s.getBinaryBody(u, b.Name)
+		if b.IsLink() {
+			continue
+		}
+		b.Body, err = s.getBinaryBody(ctx, u, b.Name)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
-	return &m, nil
+	return nil
 }
 
 // getBinaryBody returns the binary body reader.
-func (s *_s3) getBinaryBody(relURL *url.URL, binName string) (io.Reader, error) {
+func (s *_s3) getBinaryBody(ctx context.Context, relURL *url.URL, binName string) (io.Reader, error) {
 	key := filepath.Join(relURL.Path, binName)
-	resp, err := s.svc.GetObject(&s3.GetObjectInput{
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
@@ -242,126 +384,383 @@ func (s *_s3) getBinaryBody(relURL *url.URL, binName string) (io.Reader, error)
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case s3.ErrCodeNoSuchKey:
-				return nil, errors.Wrapf(err, "not found %v", key)
+				return nil, releaseNotFound(strings.TrimPrefix(filepath.Dir(relURL.Path), "/"), filepath.Base(relURL.Path), "not found %v", key)
 			default:
 			}
 		}
-		return nil, errors.Wrapf(err, "failed to get object from s3 %s", relURL)
+		return nil, wrapS3Error(err, "failed to get object from s3 %s", relURL)
 	}
-	return resp.Body, nil
+	return &s3Body{ReadCloser: resp.Body, ctx: ctx}, nil
 }
 
-func (s *_s3) ascTimestamps(name string) ([]string, error) {
-	resp, err := s.svc.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket:    aws.String(s.bucket),
-		Prefix:    aws.String(name + "/"),
-		Delimiter: aws.String("/"),
+// OpenBinary opens the body of the binary from the offset with the range request.
+func (s *_s3) OpenBinary(ctx context.Context, name, timestamp, binName string, offset int64) (io.ReadCloser, error) {
+	u, err := s.buildReleaseURL(name, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	key := filepath.Join(u.Path, binName)
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
 	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to list objects (bucket: %v, path: %v/)", s.bucket, name)
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey:
+				return nil, releaseNotFound(name, timestamp, "not found %v", key)
+			case "InvalidRange":
+				// The offset is the end of the body.
+				return ioutil.NopCloser(bytes.NewReader(nil)), nil
+			}
+		}
+		return nil, wrapS3Error(err, "failed to get object from s3 %s", u)
 	}
-	if len(resp.CommonPrefixes) < 1 {
-		return nil, errors.Errorf("no such projects %v", name)
+	return &s3Body{ReadCloser: resp.Body, ctx: ctx}, nil
+}
+
+// s3Body is the body of the object, whose read errors other than io.EOF are
+// the network errors, that is, TransientError, unless ctx of the request is
+// done, in which case the error of ctx is returned.
+type s3Body struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (b *s3Body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if b.ctx != nil && b.ctx.Err() != nil {
+		return n, b.ctx.Err()
+	}
+	return n, &TransientError{Err: err}
+}
+
+func (s *_s3) ascTimestamps(ctx context.Context, name string) ([]string, error) {
+	prefixes, err := s.listCommonPrefixes(ctx, name+"/")
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) < 1 {
+		return nil, projectNotFound(name)
 	}
-	timestamps := make([]string, 0, len(resp.CommonPrefixes))
-	for _, cp := range resp.CommonPrefixes {
-		timestamps = append(timestamps, filepath.Base(*cp.Prefix))
+	timestamps := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		timestamps = append(timestamps, filepath.Base(prefix))
 	}
 	sort.Strings(timestamps)
 	return timestamps, nil
 }
 
-// DeleteRelease deletes the release with the `timestamp`.
-func (s *_s3) DeleteRelease(name, timestamp string) error {
-	rel, err := s.FindReleaseByTimestamp(name, timestamp)
+// listCommonPrefixes lists all common prefixes delimited by '/' under the prefix.
+func (s *_s3) listCommonPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	var prefixes []string
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	for {
+		resp, err := s.svc.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, wrapS3Error(err, "failed to list objects (bucket: %v, path: %v)", s.bucket, prefix)
+		}
+		for _, cp := range resp.CommonPrefixes {
+			prefixes = append(prefixes, *cp.Prefix)
+		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return prefixes, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+// DeleteRelease deletes the release with the `timestamp`, leaving the tombstone.
+func (s *_s3) DeleteRelease(ctx context.Context, name, timestamp string) error {
+	meta, err := s.getLiveMeta(ctx, name, timestamp)
 	if err != nil {
 		return err
 	}
+	if err := s.putTombstone(ctx, name, timestamp, meta); err != nil {
+		return err
+	}
 	// recursively delete
-	resp, err := s.svc.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(rel.Prefix()),
-	})
+	keys, err := s.listKeys(ctx, name+"/"+timestamp+"/")
 	if err != nil {
-		return errors.Wrapf(err, "failed to list objects (bucket: %v, key: %v/)", s.bucket, rel.URL.Path)
+		return err
+	}
+	return s.deleteKeys(ctx, keys)
+}
+
+// listKeys lists all keys under the prefix.
+func (s *_s3) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	return s.listKeysAfter(ctx, prefix, "")
+}
+
+// listKeysAfter lists the keys under the prefix after the key `after`.
+func (s *_s3) listKeysAfter(ctx context.Context, prefix, after string) ([]string, error) {
+	var keys []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
 	}
-	if *resp.IsTruncated {
-		//TODO: paging
-		log.Printf("too many objects (bucket: %v, key: %v/)\n", s.bucket, rel.URL.Path)
+	if after != "" {
+		input.StartAfter = aws.String(after)
 	}
-	for _, obj := range resp.Contents {
-		_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+	for {
+		resp, err := s.svc.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, wrapS3Error(err, "failed to list objects (bucket: %v, key: %v)", s.bucket, prefix)
+		}
+		for _, obj := range resp.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return keys, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+func (s *_s3) deleteKeys(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 			Bucket: aws.String(s.bucket),
-			Key:    obj.Key,
+			Key:    aws.String(key),
 		})
 		if err != nil {
-			return errors.Wrapf(err, "failed to delete object (bucket: %v, key: %v)", s.bucket, obj.Key)
+			return wrapS3Error(err, "failed to delete object (bucket: %v, key: %v)", s.bucket, key)
 		}
 	}
 	return nil
 }
 
-// PruneReleases prunes the `keep` of old releases.
-func (s *_s3) PruneReleases(name string, keep int) ([]string, error) {
-	timestamps, err := s.ascTimestamps(name)
+func (s *_s3) copyObject(ctx context.Context, src, dst, storageClass string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(dst),
+		CopySource: aws.String((&url.URL{Path: s.bucket + "/" + src}).EscapedPath()),
+	}
+	if storageClass != "" {
+		input.StorageClass = aws.String(storageClass)
+	}
+	s.opts.applyCopy(input)
+	if _, err := s.svc.CopyObjectWithContext(ctx, input); err != nil {
+		return wrapS3Error(err, "failed to copy object (bucket: %v, key: %v -> %v)", s.bucket, src, dst)
+	}
+	return nil
+}
+
+// ArchiveRelease moves the release with the `timestamp` under the archive
+// prefix with the storage class such as GLACIER or DEEP_ARCHIVE.
+func (s *_s3) ArchiveRelease(ctx context.Context, name, timestamp, storageClass string) error {
+	prefix := name + "/" + timestamp + "/"
+	keys, err := s.listKeys(ctx, prefix)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var prunedTimestamps []string
-	if len(timestamps) > keep {
-		n := len(timestamps) - keep
-		prunedTimestamps = timestamps[0:n]
-		for _, t := range prunedTimestamps {
-			if err := s.DeleteRelease(name, t); err != nil {
-				return nil, err
-			}
+	if len(keys) < 1 {
+		return releaseNotFound(name, timestamp, "no such release %v/%v", name, timestamp)
+	}
+	u, err := s.buildReleaseURL(name, timestamp)
+	if err != nil {
+		return err
+	}
+	meta, err := s.getMeta(ctx, u)
+	if err != nil {
+		return err
+	}
+	if meta != nil {
+		if err := s.putTombstone(ctx, name, timestamp, meta); err != nil {
+			return err
 		}
 	}
-	return prunedTimestamps, nil
+	for _, key := range keys {
+		if err := s.copyObject(ctx, key, archivePrefix+key, storageClass); err != nil {
+			return err
+		}
+	}
+	return s.deleteKeys(ctx, keys)
 }
 
-func (s *_s3) walkReleases(pool *grpool.Pool, prefix string, walkfn func(*release.Release) error) error {
-	resp, err := s.svc.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket:    aws.String(s.bucket),
-		Prefix:    aws.String(prefix),
-		Delimiter: aws.String("/"),
+// RestoreRelease moves the archived release with the `timestamp` back into
+// the live releases. The objects in GLACIER or DEEP_ARCHIVE must be restored
+// before being copied, so that it requests the restoration and returns false
+// until all objects are restored. It returns true once the release is back.
+func (s *_s3) RestoreRelease(ctx context.Context, name, timestamp string) (bool, error) {
+	prefix := archivePrefix + name + "/" + timestamp + "/"
+	keys, err := s.listKeys(ctx, prefix)
+	if err != nil {
+		return false, err
+	}
+	if len(keys) < 1 {
+		return false, releaseNotFound(name, timestamp, "no such archived release %v/%v", name, timestamp)
+	}
+	restored := true
+	for _, key := range keys {
+		ok, err := s.requestRestore(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		restored = restored && ok
+	}
+	if !restored {
+		return false, nil
+	}
+	for _, key := range keys {
+		if err := s.copyObject(ctx, key, strings.TrimPrefix(key, archivePrefix), ""); err != nil {
+			return false, err
+		}
+	}
+	if err := s.deleteKeys(ctx, keys); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// requestRestore requests the restoration of the object in GLACIER or
+// DEEP_ARCHIVE unless it is restored or in progress. It returns whether
+// the object is readable or not.
+func (s *_s3) requestRestore(ctx context.Context, key string) (bool, error) {
+	head, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		return errors.Wrapf(err, "failed to list objects (bucket: %v, key: %v/)", s.bucket, prefix)
+		return false, wrapS3Error(err, "failed to head object (bucket: %v, key: %v)", s.bucket, key)
 	}
-	if *resp.IsTruncated {
-		//TODO: paging
-		log.Printf("too many objects (bucket: %v, key: %v/)\n", s.bucket, prefix)
+	switch aws.StringValue(head.StorageClass) {
+	case "GLACIER", "DEEP_ARCHIVE":
+	default:
+		return true, nil
 	}
-	var foundErr error // just use nonzeo exit
-	for _, obj := range resp.CommonPrefixes {
-		releasePath := *obj.Prefix
+	restore := aws.StringValue(head.Restore)
+	if strings.Contains(restore, `ongoing-request="false"`) {
+		return true, nil
+	}
+	if strings.Contains(restore, `ongoing-request="true"`) {
+		return false, nil
+	}
+	_, err = s.svc.RestoreObjectWithContext(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(restoreDays),
+			GlacierJobParameters: &s3.GlacierJobParameters{
+				Tier: aws.String(s3.TierStandard),
+			},
+		},
+	})
+	if err != nil {
+		return false, wrapS3Error(err, "failed to restore object (bucket: %v, key: %v)", s.bucket, key)
+	}
+	return false, nil
+}
+
+// YankRelease marks the release with the `timestamp` as yanked with the reason.
+func (s *_s3) YankRelease(ctx context.Context, name, timestamp, reason string) error {
+	return s.updateMeta(ctx, name, timestamp, func(m *release.Meta) {
+		m.Yanked = &release.Yank{Reason: reason, Timestamp: release.Now()}
+	})
+}
+
+// UnyankRelease restores the yanked release with the `timestamp`.
+func (s *_s3) UnyankRelease(ctx context.Context, name, timestamp string) error {
+	return s.updateMeta(ctx, name, timestamp, func(m *release.Meta) {
+		m.Yanked = nil
+	})
+}
+
+// updateMeta updates the meta.yml of the release with the `timestamp` by fn.
+func (s *_s3) updateMeta(ctx context.Context, name, timestamp string, fn func(*release.Meta)) error {
+	u, err := s.buildReleaseURL(name, timestamp)
+	if err != nil {
+		return err
+	}
+	meta, err := s.getMeta(ctx, u)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return releaseNotFound(name, timestamp, "meta.yml not found %s", u)
+	}
+	fn(meta)
+	return s.putMeta(ctx, u, meta)
+}
+
+// PruneReleases prunes the old releases which the retention policy doesn't keep,
+// and returns the retentions of all releases in ascending order of the timestamps.
+func (s *_s3) PruneReleases(ctx context.Context, name string, opts *PruneOptions) ([]*release.Retention, error) {
+	timestamps, err := s.ascTimestamps(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return pruneReleases(ctx, s, name, timestamps, func(ts string) (*release.Meta, error) {
+		u, err := s.buildReleaseURL(name, ts)
+		if err != nil {
+			return nil, err
+		}
+		return s.getMeta(ctx, u)
+	}, opts)
+}
+
+func (s *_s3) walkReleases(ctx context.Context, pool *grpool.Pool, prefix string, walkfn func(*release.Release) error) error {
+	prefixes, err := s.listCommonPrefixes(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	var (
+		mu       sync.Mutex
+		foundErr error // just use nonzeo exit
+	)
+	for _, releasePath := range prefixes {
+		releasePath := releasePath
+		if err := ctx.Err(); err != nil {
+			pool.WaitAll()
+			return err
+		}
+		if releasePath == archivePrefix || releasePath == auditPrefix || releasePath == tombstonePrefix {
+			continue
+		}
 		if ok, name := release.ParseName(releasePath); ok {
 			name := name
 			pool.WaitCount(1)
 			pool.JobQueue <- func() {
 				defer pool.JobDone()
+				if ctx.Err() != nil {
+					return
+				}
 
-				rel, err := s.FindReleaseByTimestamp(name, filepath.Base(releasePath))
+				rel, err := s.FindReleaseByTimestamp(ctx, name, filepath.Base(releasePath))
 				if err != nil {
-					log.Printf("failed to find release %s: %s\n", releasePath, err)
+					loggerOf(s.logger).Error("Failed to find the release", "prefix", releasePath, "error", err)
 					// just put error log, not to exit
+					mu.Lock()
 					foundErr = err
+					mu.Unlock()
 					return
 				}
 				if err := walkfn(rel); err != nil {
-					log.Printf("failed to walk %s: %s\n", releasePath, err)
+					loggerOf(s.logger).Error("Failed to walk the release", "prefix", releasePath, "error", err)
 					// just put error log, not to exit
+					mu.Lock()
 					foundErr = err
+					mu.Unlock()
 					return
 				}
 			}
 		}
-		if err := s.walkReleases(pool, releasePath, walkfn); err != nil {
+		if err := s.walkReleases(ctx, pool, releasePath, walkfn); err != nil {
 			return err
 		}
 	}
 	pool.WaitAll()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if foundErr != nil {
 		return foundErr
 	}
@@ -369,11 +768,11 @@ func (s *_s3) walkReleases(pool *grpool.Pool, prefix string, walkfn func(*releas
 }
 
 // WalkReleases walks releases.
-func (s *_s3) WalkReleases(concurrency int, releaseFn func(*release.Release) error) error {
+func (s *_s3) WalkReleases(ctx context.Context, concurrency int, releaseFn func(*release.Release) error) error {
 	pool := grpool.NewPool(concurrency, jobQueueLen)
 	defer pool.Release()
 
-	err := s.walkReleases(pool, "", func(rel *release.Release) error {
+	err := s.walkReleases(ctx, pool, "", func(rel *release.Release) error {
 		return releaseFn(rel)
 	})
 	if err != nil {
@@ -381,3 +780,300 @@ func (s *_s3) WalkReleases(concurrency int, releaseFn func(*release.Release) err
 	}
 	return nil
 }
+
+// ListReleases returns the releases of the name by listing the timestamps
+// and getting the meta.yml of each release without the bodies.
+func (s *_s3) ListReleases(ctx context.Context, name string) ([]*release.Release, error) {
+	timestamps, err := s.ascTimestamps(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rels := make([]*release.Release, 0, len(timestamps))
+	for _, ts := range timestamps {
+		u, err := s.buildReleaseURL(name, ts)
+		if err != nil {
+			return nil, err
+		}
+		meta, err := s.getMeta(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		// The release being uploaded has no meta.yml yet.
+		if meta == nil {
+			continue
+		}
+		rels = append(rels, release.New(meta, u))
+	}
+	return rels, nil
+}
+
+// ProjectNames returns the sorted names of all projects by listing the
+// prefixes without reading the releases.
+func (s *_s3) ProjectNames(ctx context.Context) ([]string, error) {
+	found := map[string]bool{}
+	if err := s.findProjects(ctx, "", found); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// findProjects adds the names of the projects under the prefix into found.
+func (s *_s3) findProjects(ctx context.Context, prefix string, found map[string]bool) error {
+	prefixes, err := s.listCommonPrefixes(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, p := range prefixes {
+		if p == archivePrefix || p == auditPrefix || p == tombstonePrefix {
+			continue
+		}
+		// The prefixes of the project are the releases, which are not
+		// listed any further.
+		if ok, name := release.ParseName(p); ok {
+			found[name] = true
+			continue
+		}
+		if err := s.findProjects(ctx, p, found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tombstoneKey returns the key of the tombstone of the release.
+func tombstoneKey(name, timestamp string) string {
+	return tombstonePrefix + name + "/" + timestamp + ".yml"
+}
+
+// putTombstone puts the tombstone of the release with the meta.
+func (s *_s3) putTombstone(ctx context.Context, name, timestamp string, meta *release.Meta) error {
+	t, err := release.NewTombstone(timestamp, meta)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal yaml")
+	}
+	key := tombstoneKey(name, timestamp)
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
+	}
+	s.opts.applyPut(input)
+	if _, err := s.svc.PutObjectWithContext(ctx, input); err != nil {
+		return wrapS3Error(err, "failed to put the tombstone into s3 (bucket: %v, key: %v)", s.bucket, key)
+	}
+	return nil
+}
+
+// getTombstone gets the tombstone of the release.
+func (s *_s3) getTombstone(ctx context.Context, name, timestamp string) (*release.Tombstone, error) {
+	key := tombstoneKey(name, timestamp)
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapS3Error(err, "failed to get object from s3 (bucket: %v, key: %v)", s.bucket, key)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &TransientError{Err: errors.Wrapf(err, "failed to read the tombstone %s", key)}
+	}
+	var t release.Tombstone
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, errors.Wrapf(err, "failed to read the tombstone %s", key)
+	}
+	return &t, nil
+}
+
+// tombstoneTimestamps returns the timestamps of the tombstones of the name
+// sorted in ascending order.
+func (s *_s3) tombstoneTimestamps(ctx context.Context, name string) ([]string, error) {
+	prefix := tombstonePrefix + name + "/"
+	keys, err := s.listKeys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var timestamps []string
+	for _, key := range keys {
+		ts := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".yml")
+		// The tombstones of the nested names such as <name>/<sub> are skipped.
+		if !strings.Contains(ts, "/") {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	sort.Strings(timestamps)
+	return timestamps, nil
+}
+
+// liveTimestamps returns the timestamps of the live releases of the name
+// sorted in ascending order, which is empty if the project is not found.
+func (s *_s3) liveTimestamps(ctx context.Context, name string) ([]string, error) {
+	timestamps, err := s.ascTimestamps(ctx, name)
+	if errors.Is(err, ErrProjectNotFound) {
+		return nil, nil
+	}
+	return timestamps, err
+}
+
+// lastLink returns the link of the latest release or tombstone of the name,
+// or nil if there is neither of them. It is read without any lock, so the
+// concurrent pushes may chain their releases to the same link, which
+// release.VerifyChain reports as the fork.
+func (s *_s3) lastLink(ctx context.Context, name string) (*release.Link, error) {
+	timestamps, err := s.liveTimestamps(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	tombstones, err := s.tombstoneTimestamps(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var latest string
+	if len(timestamps) > 0 {
+		latest = timestamps[len(timestamps)-1]
+	}
+	if len(tombstones) > 0 && tombstones[len(tombstones)-1] > latest {
+		t, err := s.getTombstone(ctx, name, tombstones[len(tombstones)-1])
+		if err != nil {
+			return nil, err
+		}
+		return release.NewLink(t.Timestamp, nil, t)
+	}
+	if latest == "" {
+		return nil, nil
+	}
+	meta, err := s.getLiveMeta(ctx, name, latest)
+	if err != nil {
+		return nil, err
+	}
+	return release.NewLink(latest, meta, nil)
+}
+
+// getLiveMeta gets the metadata of the live release without opening the
+// binary bodies.
+func (s *_s3) getLiveMeta(ctx context.Context, name, timestamp string) (*release.Meta, error) {
+	u, err := s.buildReleaseURL(name, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := s.getMeta(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, releaseNotFound(name, timestamp, "meta.yml not found %s", u)
+	}
+	return meta, nil
+}
+
+// ChainLinks returns the links of the releases and the tombstones of the
+// project in ascending order of the timestamps.
+func (s *_s3) ChainLinks(ctx context.Context, name string) ([]*release.Link, error) {
+	timestamps, err := s.liveTimestamps(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	tombstoneTimestamps, err := s.tombstoneTimestamps(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(timestamps) == 0 && len(tombstoneTimestamps) == 0 {
+		return nil, projectNotFound(name)
+	}
+	tombstones := make(map[string]*release.Tombstone, len(tombstoneTimestamps))
+	for _, ts := range tombstoneTimestamps {
+		if tombstones[ts], err = s.getTombstone(ctx, name, ts); err != nil {
+			return nil, err
+		}
+	}
+	return mergeLinks(timestamps, tombstones, func(ts string) (*release.Meta, error) {
+		return s.getLiveMeta(ctx, name, ts)
+	})
+}
+
+// AppendAudit puts the record as a new object under the audit prefix.
+func (s *_s3) AppendAudit(ctx context.Context, rec *AuditRecord) error {
+	key, err := auditKey(rec)
+	if err != nil {
+		return err
+	}
+	data, err := encodeAuditRecord(rec)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        aws.ReadSeekCloser(bytes.NewReader(data)),
+		ContentType: aws.String("application/json"),
+	}
+	s.opts.applyPut(input)
+	if _, err := s.svc.PutObjectWithContext(ctx, input); err != nil {
+		return wrapS3Error(err, "failed to put the audit record into s3 (bucket: %v, key: %v)", s.bucket, key)
+	}
+	return nil
+}
+
+// WalkAudit walks the records at or after since in the order of the time.
+// The records of the days before since are not listed.
+func (s *_s3) WalkAudit(ctx context.Context, since time.Time, fn func(*AuditRecord) error) error {
+	var after string
+	if !since.IsZero() {
+		after = auditDayPrefix(since)
+	}
+	keys, err := s.listKeysAfter(ctx, auditPrefix, after)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		rec, err := s.getAuditRecord(ctx, key)
+		if err != nil {
+			return err
+		}
+		if rec.Time.Before(since) {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *_s3) getAuditRecord(ctx context.Context, key string) (*AuditRecord, error) {
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapS3Error(err, "failed to get object from s3 (bucket: %v, key: %v)", s.bucket, key)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &TransientError{Err: errors.Wrapf(err, "failed to read the audit record %s", key)}
+	}
+	return decodeAuditRecord(key, data)
+}
+
+// CallerIdentity returns the ARN of the credentials from STS.
+func (s *_s3) CallerIdentity(ctx context.Context) (string, error) {
+	if s.sts == nil {
+		return "", nil
+	}
+	resp, err := s.sts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", wrapS3Error(err, "failed to get the caller identity")
+	}
+	return aws.StringValue(resp.Arn), nil
+}
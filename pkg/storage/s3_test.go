@@ -2,17 +2,20 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/kylelemons/godebug/pretty"
+	"github.com/pkg/errors"
 	"github.com/yuuki/binrep/pkg/release"
 )
 
@@ -58,7 +61,7 @@ func TestS3ExistRelease(t *testing.T) {
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		ok, err := store.ExistRelease("github.com/yuuki/droot")
+		ok, err := store.ExistRelease(context.Background(), "github.com/yuuki/droot")
 
 		if err != nil {
 			t.Fatalf("should not raise error: %s", err)
@@ -85,7 +88,7 @@ func TestS3ExistRelease(t *testing.T) {
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		ok, err := store.ExistRelease("github.com/yuuki/droot")
+		ok, err := store.ExistRelease(context.Background(), "github.com/yuuki/droot")
 
 		if err != nil {
 			t.Fatalf("should not raise error: %s", err)
@@ -179,7 +182,7 @@ binaries:
 			},
 		}
 
-		ok, err := store.HaveSameChecksums("github.com/yuuki/droot", bins)
+		ok, err := store.HaveSameChecksums(context.Background(), "github.com/yuuki/droot", bins)
 
 		if err != nil {
 			t.Fatalf("should not raise error: %s", err)
@@ -255,7 +258,7 @@ binaries:
 			},
 		}
 
-		ok, err := store.HaveSameChecksums("github.com/yuuki/droot", bins)
+		ok, err := store.HaveSameChecksums(context.Background(), "github.com/yuuki/droot", bins)
 
 		if err != nil {
 			t.Fatalf("should not raise error: %s", err)
@@ -267,38 +270,7 @@ binaries:
 	})
 }
 
-func TestS3LatestTimestamp(t *testing.T) {
-	fakeS3 := &fakeS3API{
-		FakeListObjectsV2: func(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
-			if *input.Bucket != "binrep-testing" {
-				t.Errorf("got %q, want %q", *input.Bucket, "binrep-testing")
-			}
-			if *input.Prefix != "github.com/yuuki/droot/" {
-				t.Errorf("got %q, want %q", *input.Prefix, "github.com/yuuki/droot/")
-			}
-			return &s3.ListObjectsV2Output{
-				CommonPrefixes: []*s3.CommonPrefix{
-					{Prefix: aws.String("github.com/yuuki/droot/20171016152508")},
-					{Prefix: aws.String("github.com/yuuki/droot/20171017152508")},
-					{Prefix: aws.String("github.com/yuuki/droot/20171015152508")},
-				},
-			}, nil
-		},
-	}
-	store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
-
-	timestamp, err := store.latestTimestamp("github.com/yuuki/droot")
-
-	if err != nil {
-		t.Fatalf("should not raise error: %s", err)
-	}
-
-	if timestamp != "20171017152508" {
-		t.Errorf("got: %q, want %q", timestamp, "20171017152508")
-	}
-}
-
-func TestS3CreateMeta(t *testing.T) {
+func TestS3PutMeta(t *testing.T) {
 	fakeS3 := &fakeS3API{
 		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
 			if *input.Bucket != "binrep-testing" {
@@ -347,15 +319,11 @@ binaries:
 		},
 	}
 
-	meta, err := store.createMeta(u, bins)
+	err = store.putMeta(context.Background(), u, release.NewMeta(bins))
 
 	if err != nil {
 		t.Fatalf("should not raise error: %s", err)
 	}
-
-	if diff := pretty.Compare(meta.Binaries, bins); diff != "" {
-		t.Errorf("diff: (-actual +expected)\n%s", diff)
-	}
 }
 
 func TestS3FindMeta(t *testing.T) {
@@ -409,7 +377,7 @@ binaries:
 		panic(err)
 	}
 
-	meta, err := store.FindMeta(u)
+	meta, err := store.FindMeta(context.Background(), u)
 
 	if err != nil {
 		t.Fatalf("should not raise error: %s", err)
@@ -420,13 +388,13 @@ binaries:
 			Name:     "droot",
 			Checksum: "ec9efb6249e0e4797bde75afbfe962e0db81c530b5bb1cfd2cbe0e2fc2c8cf48",
 			Mode:     0755,
-			Body:     ioutil.NopCloser(bytes.NewBufferString("droot-body")),
+			Body:     &s3Body{ReadCloser: ioutil.NopCloser(bytes.NewBufferString("droot-body")), ctx: context.Background()},
 		},
 		{
 			Name:     "grabeni",
 			Checksum: "3e30f16f0ec41ab92ceca57a527efff18b6bacabd12a842afda07b8329e32259",
 			Mode:     0755,
-			Body:     ioutil.NopCloser(bytes.NewBufferString("grabeni-body")),
+			Body:     &s3Body{ReadCloser: ioutil.NopCloser(bytes.NewBufferString("grabeni-body")), ctx: context.Background()},
 		},
 	}
 	if diff := pretty.Compare(meta.Binaries, expected); diff != "" {
@@ -488,7 +456,7 @@ binaries:
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		rel, err := store.FindLatestRelease("github.com/yuuki/droot")
+		rel, err := store.FindLatestRelease(context.Background(), "github.com/yuuki/droot")
 
 		if err != nil {
 			t.Fatalf("should not raise error: %s", err)
@@ -513,7 +481,7 @@ binaries:
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		_, err := store.FindLatestRelease("github.com/yuuki/droot")
+		_, err := store.FindLatestRelease(context.Background(), "github.com/yuuki/droot")
 
 		if err == nil {
 			t.Fatal("should raise error")
@@ -560,7 +528,7 @@ binaries:
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		rel, err := store.FindReleaseByTimestamp("github.com/yuuki/droot", "20171016152508")
+		rel, err := store.FindReleaseByTimestamp(context.Background(), "github.com/yuuki/droot", "20171016152508")
 
 		if err != nil {
 			t.Fatalf("should not raise error: %s", err)
@@ -582,7 +550,7 @@ binaries:
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		_, err := store.FindReleaseByTimestamp("github.com/yuuki/droot", "20001016152508")
+		_, err := store.FindReleaseByTimestamp(context.Background(), "github.com/yuuki/droot", "20001016152508")
 
 		if err == nil {
 			t.Fatal("should raise error")
@@ -593,8 +561,18 @@ binaries:
 	})
 }
 
+// listNothing fakes ListObjectsV2 of no objects.
+func listNothing(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return &s3.ListObjectsV2Output{}, nil
+}
+
 func TestS3CreateRelease(t *testing.T) {
 	fakeS3 := &fakeS3API{
+		FakeListObjectsV2: listNothing,
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			// no existing release
+			return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+		},
 		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
 			if *input.Bucket != "binrep-testing" {
 				t.Errorf("got %q, want %q", *input.Bucket, "binrep-testing")
@@ -615,6 +593,7 @@ binaries:
 - name: grabeni
   checksum: 3e30f16f0ec41ab92ceca57a527efff18b6bacabd12a842afda07b8329e32259
   mode: 493
+chain: {}
 `, "\n")
 			if diff := pretty.Compare(string(body), expectedBody); diff != "" {
 				t.Errorf("diff: (-actual +expected)\n%s", diff)
@@ -665,7 +644,7 @@ binaries:
 		},
 	}
 
-	rel, err := store.CreateRelease("github.com/yuuki/droot", "20171017152508", bins)
+	rel, err := store.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", release.NewMeta(bins))
 
 	if err != nil {
 		t.Fatalf("should not raise error: %s", err)
@@ -682,6 +661,217 @@ binaries:
 	}
 }
 
+func TestS3CreateRelease_cleanupOnFailure(t *testing.T) {
+	var deleted []string
+	fakeS3 := &fakeS3API{
+		FakeListObjectsV2: listNothing,
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+		},
+		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			t.Errorf("meta.yml should not be put on the failure")
+			return &s3.PutObjectOutput{}, nil
+		},
+		FakeDeleteObject: func(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+			deleted = append(deleted, *input.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	fakeS3Uploader := &fakeS3UploaderAPI{
+		FakeUpload: func(input *s3manager.UploadInput, fn ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+			if *input.Key == "/github.com/yuuki/droot/20171017152508/grabeni" {
+				return nil, context.Canceled
+			}
+			return &s3manager.UploadOutput{}, nil
+		},
+	}
+	store := newTestS3(fakeS3, fakeS3Uploader)
+	bins := []*release.Binary{
+		{Name: "droot", Mode: 0755, Body: bytes.NewBufferString("droot-body")},
+		{Name: "grabeni", Mode: 0755, Body: bytes.NewBufferString("grabeni-body")},
+	}
+
+	_, err := store.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", release.NewMeta(bins))
+
+	if err == nil {
+		t.Fatalf("should raise error")
+	}
+	expected := []string{"/github.com/yuuki/droot/20171017152508/droot"}
+	if diff := pretty.Compare(deleted, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
+
+func TestS3CreateRelease_duplicateTimestamp(t *testing.T) {
+	fakeS3 := &fakeS3API{
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				Body: ioutil.NopCloser(bytes.NewBufferString("binaries: []\n")),
+			}, nil
+		},
+		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			t.Errorf("should not overwrite the existing meta.yml")
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+	store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
+
+	_, err := store.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", release.NewMeta(nil))
+
+	if err == nil || !strings.Contains(err.Error(), "release already exists") {
+		t.Errorf("should raise the error of the existing release, got %v", err)
+	}
+}
+
+func TestS3CreateRelease_objectOptions(t *testing.T) {
+	type settings struct {
+		SSE          *string
+		SSEKMSKeyID  *string
+		StorageClass *string
+		Tagging      *string
+		Metadata     map[string]*string
+	}
+	expected := settings{
+		SSE:          aws.String("aws:kms"),
+		SSEKMSKeyID:  aws.String("alias/binrep"),
+		StorageClass: aws.String("STANDARD_IA"),
+		Tagging:      aws.String("owner=sre&project=droot"),
+		Metadata:     map[string]*string{"built-by": aws.String("ci")},
+	}
+	fakeS3 := &fakeS3API{
+		FakeListObjectsV2: listNothing,
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			// no existing release
+			return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+		},
+		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			actual := settings{input.ServerSideEncryption, input.SSEKMSKeyId, input.StorageClass, input.Tagging, input.Metadata}
+			if diff := pretty.Compare(actual, expected); diff != "" {
+				t.Errorf("meta.yml diff: (-actual +expected)\n%s", diff)
+			}
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+	fakeS3Uploader := &fakeS3UploaderAPI{
+		FakeUpload: func(input *s3manager.UploadInput, fn ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+			actual := settings{input.ServerSideEncryption, input.SSEKMSKeyId, input.StorageClass, input.Tagging, input.Metadata}
+			if diff := pretty.Compare(actual, expected); diff != "" {
+				t.Errorf("%s diff: (-actual +expected)\n%s", *input.Key, diff)
+			}
+			return &s3manager.UploadOutput{}, nil
+		},
+	}
+	store := newTestS3(fakeS3, fakeS3Uploader)
+	store.opts = &ObjectOptions{
+		SSE:          "aws:kms",
+		SSEKMSKeyID:  "alias/binrep",
+		StorageClass: "STANDARD_IA",
+		Tags:         map[string]string{"project": "droot", "owner": "sre"},
+		Metadata:     map[string]string{"built-by": "ci"},
+	}
+	bins := []*release.Binary{
+		{
+			Name:     "droot",
+			Checksum: "ec9efb6249e0e4797bde75afbfe962e0db81c530b5bb1cfd2cbe0e2fc2c8cf48",
+			Mode:     0755,
+			Body:     bytes.NewBufferString("droot-body"),
+		},
+	}
+
+	_, err := store.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", release.NewMeta(bins))
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+}
+
+func TestS3CreateRelease_noObjectOptions(t *testing.T) {
+	fakeS3 := &fakeS3API{
+		FakeListObjectsV2: listNothing,
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			// no existing release
+			return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+		},
+		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			if input.ServerSideEncryption != nil || input.StorageClass != nil || input.Tagging != nil || input.Metadata != nil {
+				t.Errorf("should not set the object options: %v", input)
+			}
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+	fakeS3Uploader := &fakeS3UploaderAPI{
+		FakeUpload: func(input *s3manager.UploadInput, fn ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+			if input.ServerSideEncryption != nil || input.StorageClass != nil || input.Tagging != nil || input.Metadata != nil {
+				t.Errorf("should not set the object options: %v", input)
+			}
+			return &s3manager.UploadOutput{}, nil
+		},
+	}
+	store := newTestS3(fakeS3, fakeS3Uploader)
+	store.opts = &ObjectOptions{}
+	bins := []*release.Binary{
+		{Name: "droot", Mode: 0755, Body: bytes.NewBufferString("droot-body")},
+	}
+
+	_, err := store.CreateRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", release.NewMeta(bins))
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+}
+
+func TestObjectOptionsValidate(t *testing.T) {
+	tests := []struct {
+		desc  string
+		opts  *ObjectOptions
+		isErr bool
+	}{
+		{desc: "nil", opts: nil},
+		{desc: "kms", opts: &ObjectOptions{SSE: "aws:kms", SSEKMSKeyID: "alias/binrep", StorageClass: "STANDARD_IA"}},
+		{desc: "unsupported encryption", opts: &ObjectOptions{SSE: "DES"}, isErr: true},
+		{desc: "key ID without kms", opts: &ObjectOptions{SSE: "AES256", SSEKMSKeyID: "alias/binrep"}, isErr: true},
+		{desc: "archive storage class", opts: &ObjectOptions{StorageClass: "GLACIER"}, isErr: true},
+	}
+	for _, tc := range tests {
+		err := tc.opts.Validate()
+		if tc.isErr && err == nil {
+			t.Errorf("desc: %s, should raise error", tc.desc)
+		}
+		if !tc.isErr && err != nil {
+			t.Errorf("desc: %s, should not raise error: %s", tc.desc, err)
+		}
+	}
+}
+
+func TestS3Body_readError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+
+	tests := []struct {
+		desc     string
+		ctx      context.Context
+		expected error
+	}{
+		{desc: "network error", ctx: context.Background(), expected: ErrTransient},
+		{desc: "canceled", ctx: canceled, expected: context.Canceled},
+		{desc: "deadline exceeded", ctx: expired, expected: context.DeadlineExceeded},
+	}
+	for _, tc := range tests {
+		body := &s3Body{ReadCloser: ioutil.NopCloser(iotest.ErrReader(errors.New("connection reset"))), ctx: tc.ctx}
+
+		_, err := body.Read(make([]byte, 1))
+
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("desc: %s, got %v, want %v", tc.desc, err, tc.expected)
+		}
+		if tc.expected != ErrTransient && errors.Is(err, ErrTransient) {
+			t.Errorf("desc: %s, should not be transient: %v", tc.desc, err)
+		}
+	}
+}
+
 func TestS3ascTimestamps(t *testing.T) {
 	t.Run("normal", func(t *testing.T) {
 		fakeS3 := &fakeS3API{
@@ -703,7 +893,7 @@ func TestS3ascTimestamps(t *testing.T) {
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		timestamps, err := store.ascTimestamps("github.com/yuuki/droot")
+		timestamps, err := store.ascTimestamps(context.Background(), "github.com/yuuki/droot")
 
 		if err != nil {
 			t.Fatalf("should not raise error: %s", err)
@@ -732,7 +922,7 @@ func TestS3ascTimestamps(t *testing.T) {
 		}
 		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
 
-		_, err := store.ascTimestamps("github.com/yuuki/droot")
+		_, err := store.ascTimestamps(context.Background(), "github.com/yuuki/droot")
 
 		if err == nil {
 			t.Fatalf("should raise error: %s", err)
@@ -742,3 +932,313 @@ func TestS3ascTimestamps(t *testing.T) {
 		}
 	})
 }
+
+func TestS3PruneReleases_dryRun(t *testing.T) {
+	fakeS3 := &fakeS3API{
+		FakeListObjectsV2: func(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+			if *input.Prefix != "github.com/yuuki/droot/" {
+				t.Errorf("got %q, want %q", *input.Prefix, "github.com/yuuki/droot/")
+			}
+			return &s3.ListObjectsV2Output{
+				CommonPrefixes: []*s3.CommonPrefix{
+					{Prefix: aws.String("github.com/yuuki/droot/20171016152508")},
+					{Prefix: aws.String("github.com/yuuki/droot/20171017152508")},
+					{Prefix: aws.String("github.com/yuuki/droot/20171015152508")},
+				},
+			}, nil
+		},
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			meta := "binaries: []\n"
+			if *input.Key == "/github.com/yuuki/droot/20171017152508/meta.yml" {
+				meta += "yanked:\n  reason: segfault on startup\n  timestamp: \"20171018000000\"\n"
+			}
+			return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewBufferString(meta))}, nil
+		},
+		FakeDeleteObject: func(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+			t.Errorf("should not delete %q on dry-run", *input.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
+
+	rets, err := store.PruneReleases(context.Background(), "github.com/yuuki/droot", &PruneOptions{
+		Policy: &release.RetentionPolicy{KeepLast: 2},
+		DryRun: true,
+	})
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	expected := []*release.Retention{
+		{Timestamp: "20171015152508", Keep: true, Reason: "within the last 2 releases"},
+		{Timestamp: "20171016152508", Keep: true, Reason: "within the last 2 releases"},
+		{Timestamp: "20171017152508", Keep: false, Reason: "not kept by the retention policy"},
+	}
+	if diff := pretty.Compare(rets, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
+
+func TestS3FindLatestRelease_skipYanked(t *testing.T) {
+	fakeS3 := &fakeS3API{
+		FakeListObjectsV2: func(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				CommonPrefixes: []*s3.CommonPrefix{
+					{Prefix: aws.String("github.com/yuuki/droot/20171016152508")},
+					{Prefix: aws.String("github.com/yuuki/droot/20171017152508")},
+					{Prefix: aws.String("github.com/yuuki/droot/20171015152508")},
+				},
+			}, nil
+		},
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			switch *input.Key {
+			case "/github.com/yuuki/droot/20171017152508/meta.yml":
+				return &s3.GetObjectOutput{
+					Body: ioutil.NopCloser(bytes.NewBufferString(strings.TrimPrefix(`
+binaries:
+- name: droot
+  checksum: ec9efb6249e0e4797bde75afbfe962e0db81c530b5bb1cfd2cbe0e2fc2c8cf48
+  mode: 493
+yanked:
+  reason: segfault on startup
+  timestamp: "20171018000000"
+`, "\n"))),
+				}, nil
+			case "/github.com/yuuki/droot/20171016152508/meta.yml":
+				return &s3.GetObjectOutput{
+					Body: ioutil.NopCloser(bytes.NewBufferString(strings.TrimPrefix(`
+binaries:
+- name: droot
+  checksum: 3e30f16f0ec41ab92ceca57a527efff18b6bacabd12a842afda07b8329e32259
+  mode: 493
+`, "\n"))),
+				}, nil
+			case "/github.com/yuuki/droot/20171016152508/droot":
+				return &s3.GetObjectOutput{
+					Body: ioutil.NopCloser(bytes.NewBufferString("droot-body")),
+				}, nil
+			}
+			t.Errorf("unexpected key %q", *input.Key)
+			return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+		},
+	}
+	store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
+
+	rel, err := store.FindLatestRelease(context.Background(), "github.com/yuuki/droot")
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if rel.Timestamp() != "20171016152508" {
+		t.Errorf("got %q, want %q", rel.Timestamp(), "20171016152508")
+	}
+}
+
+func TestS3YankRelease(t *testing.T) {
+	putCnt := 0
+	fakeS3 := &fakeS3API{
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			expectedKey := "/github.com/yuuki/droot/20171017152508/meta.yml"
+			if *input.Key != expectedKey {
+				t.Errorf("got %q, want %q", *input.Key, expectedKey)
+			}
+			return &s3.GetObjectOutput{
+				Body: ioutil.NopCloser(bytes.NewBufferString(strings.TrimPrefix(`
+binaries:
+- name: droot
+  checksum: ec9efb6249e0e4797bde75afbfe962e0db81c530b5bb1cfd2cbe0e2fc2c8cf48
+  mode: 493
+`, "\n"))),
+			}, nil
+		},
+		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			putCnt++
+			expectedKey := "/github.com/yuuki/droot/20171017152508/meta.yml"
+			if *input.Key != expectedKey {
+				t.Errorf("got %q, want %q", *input.Key, expectedKey)
+			}
+			body, err := ioutil.ReadAll(input.Body)
+			if err != nil {
+				panic(err)
+			}
+			if !strings.Contains(string(body), "yanked:\n  reason: segfault on startup\n") {
+				t.Errorf("meta.yml should be yanked, got %q", body)
+			}
+			if !strings.HasPrefix(string(body), "binaries:\n- name: droot\n") {
+				t.Errorf("meta.yml should keep the binaries, got %q", body)
+			}
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+	store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
+
+	err := store.YankRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", "segfault on startup")
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if putCnt != 1 {
+		t.Errorf("PutObject should be called once, got %d", putCnt)
+	}
+}
+
+func TestS3ArchiveRelease(t *testing.T) {
+	var copied, deleted, put []string
+	fakeS3 := &fakeS3API{
+		FakeGetObject: func(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				Body: ioutil.NopCloser(bytes.NewBufferString("binaries: []\nchain: {}\n")),
+			}, nil
+		},
+		FakePutObject: func(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			put = append(put, *input.Key)
+			return &s3.PutObjectOutput{}, nil
+		},
+		FakeListObjectsV2: func(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+			if *input.Prefix != "github.com/yuuki/droot/20171017152508/" {
+				t.Errorf("got %q, want %q", *input.Prefix, "github.com/yuuki/droot/20171017152508/")
+			}
+			return &s3.ListObjectsV2Output{
+				Contents: []*s3.Object{
+					{Key: aws.String("github.com/yuuki/droot/20171017152508/droot")},
+					{Key: aws.String("github.com/yuuki/droot/20171017152508/meta.yml")},
+				},
+				IsTruncated: aws.Bool(false),
+			}, nil
+		},
+		FakeCopyObject: func(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+			if *input.StorageClass != "DEEP_ARCHIVE" {
+				t.Errorf("got %q, want %q", *input.StorageClass, "DEEP_ARCHIVE")
+			}
+			if aws.StringValue(input.ServerSideEncryption) != "AES256" {
+				t.Errorf("got %q, want %q", aws.StringValue(input.ServerSideEncryption), "AES256")
+			}
+			copied = append(copied, *input.CopySource+" -> "+*input.Key)
+			return &s3.CopyObjectOutput{}, nil
+		},
+		FakeDeleteObject: func(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+			deleted = append(deleted, *input.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
+	store.opts = &ObjectOptions{SSE: "AES256", StorageClass: "STANDARD_IA"}
+
+	err := store.ArchiveRelease(context.Background(), "github.com/yuuki/droot", "20171017152508", "DEEP_ARCHIVE")
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expectedCopied := []string{
+		"binrep-testing/github.com/yuuki/droot/20171017152508/droot -> archive/github.com/yuuki/droot/20171017152508/droot",
+		"binrep-testing/github.com/yuuki/droot/20171017152508/meta.yml -> archive/github.com/yuuki/droot/20171017152508/meta.yml",
+	}
+	if diff := pretty.Compare(copied, expectedCopied); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+	expectedDeleted := []string{
+		"github.com/yuuki/droot/20171017152508/droot",
+		"github.com/yuuki/droot/20171017152508/meta.yml",
+	}
+	if diff := pretty.Compare(deleted, expectedDeleted); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+	if diff := pretty.Compare(put, []string{"_tombstones/github.com/yuuki/droot/20171017152508.yml"}); diff != "" {
+		t.Errorf("the tombstone should be put, diff: (-actual +expected)\n%s", diff)
+	}
+}
+
+func TestS3RestoreRelease(t *testing.T) {
+	fakeListObjects := func(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+		if *input.Prefix != "archive/github.com/yuuki/droot/20171017152508/" {
+			t.Errorf("got %q, want %q", *input.Prefix, "archive/github.com/yuuki/droot/20171017152508/")
+		}
+		return &s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("archive/github.com/yuuki/droot/20171017152508/droot")},
+				{Key: aws.String("archive/github.com/yuuki/droot/20171017152508/meta.yml")},
+			},
+			IsTruncated: aws.Bool(false),
+		}, nil
+	}
+
+	t.Run("restoration requested", func(t *testing.T) {
+		restoreCnt := 0
+		fakeS3 := &fakeS3API{
+			FakeListObjectsV2: fakeListObjects,
+			FakeHeadObject: func(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+				return &s3.HeadObjectOutput{StorageClass: aws.String("GLACIER")}, nil
+			},
+			FakeRestoreObject: func(input *s3.RestoreObjectInput) (*s3.RestoreObjectOutput, error) {
+				restoreCnt++
+				return &s3.RestoreObjectOutput{}, nil
+			},
+			FakeCopyObject: func(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+				t.Errorf("should not copy %q before restored", *input.CopySource)
+				return &s3.CopyObjectOutput{}, nil
+			},
+		}
+		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
+
+		ok, err := store.RestoreRelease(context.Background(), "github.com/yuuki/droot", "20171017152508")
+
+		if err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+		if ok {
+			t.Error("release should not be restored yet")
+		}
+		if restoreCnt != 2 {
+			t.Errorf("RestoreObject should be called twice, got %d", restoreCnt)
+		}
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		var copied, deleted []string
+		fakeS3 := &fakeS3API{
+			FakeListObjectsV2: fakeListObjects,
+			FakeHeadObject: func(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+				return &s3.HeadObjectOutput{
+					StorageClass: aws.String("GLACIER"),
+					Restore:      aws.String(`ongoing-request="false", expiry-date="Fri, 20 Oct 2017 00:00:00 GMT"`),
+				}, nil
+			},
+			FakeCopyObject: func(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+				if input.StorageClass != nil {
+					t.Errorf("storage class should be the default, got %q", *input.StorageClass)
+				}
+				copied = append(copied, *input.Key)
+				return &s3.CopyObjectOutput{}, nil
+			},
+			FakeDeleteObject: func(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+				deleted = append(deleted, *input.Key)
+				return &s3.DeleteObjectOutput{}, nil
+			},
+		}
+		store := newTestS3(fakeS3, &fakeS3UploaderAPI{})
+
+		ok, err := store.RestoreRelease(context.Background(), "github.com/yuuki/droot", "20171017152508")
+
+		if err != nil {
+			t.Fatalf("should not raise error: %s", err)
+		}
+		if !ok {
+			t.Error("release should be restored")
+		}
+		expectedCopied := []string{
+			"github.com/yuuki/droot/20171017152508/droot",
+			"github.com/yuuki/droot/20171017152508/meta.yml",
+		}
+		if diff := pretty.Compare(copied, expectedCopied); diff != "" {
+			t.Errorf("diff: (-actual +expected)\n%s", diff)
+		}
+		expectedDeleted := []string{
+			"archive/github.com/yuuki/droot/20171017152508/droot",
+			"archive/github.com/yuuki/droot/20171017152508/meta.yml",
+		}
+		if diff := pretty.Compare(deleted, expectedDeleted); diff != "" {
+			t.Errorf("diff: (-actual +expected)\n%s", diff)
+		}
+	})
+}
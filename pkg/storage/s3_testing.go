@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
@@ -11,6 +13,9 @@ type fakeS3API struct {
 	FakeListObjectsV2 func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
 	FakePutObject     func(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
 	FakeDeleteObject  func(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	FakeCopyObject    func(*s3.CopyObjectInput) (*s3.CopyObjectOutput, error)
+	FakeHeadObject    func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	FakeRestoreObject func(*s3.RestoreObjectInput) (*s3.RestoreObjectOutput, error)
 }
 
 type fakeS3UploaderAPI struct {
@@ -18,27 +23,42 @@ type fakeS3UploaderAPI struct {
 	FakeUpload func(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
 }
 
-// GetObject fakes S3 GetObject.
-func (s *fakeS3API) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+// GetObjectWithContext fakes S3 GetObjectWithContext.
+func (s *fakeS3API) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
 	return s.FakeGetObject(input)
 }
 
-// ListObjectsV2 fakes S3 ListObjectsV2.
-func (s *fakeS3API) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+// ListObjectsV2WithContext fakes S3 ListObjectsV2WithContext.
+func (s *fakeS3API) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
 	return s.FakeListObjectsV2(input)
 }
 
-// PutObject fakes S3 PutObject.
-func (s *fakeS3API) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+// PutObjectWithContext fakes S3 PutObjectWithContext.
+func (s *fakeS3API) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
 	return s.FakePutObject(input)
 }
 
-// DeleteObject fakes S3 DeleteObject.
-func (s *fakeS3API) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+// DeleteObjectWithContext fakes S3 DeleteObjectWithContext.
+func (s *fakeS3API) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
 	return s.FakeDeleteObject(input)
 }
 
-// Upload fakes S3 Upload.
-func (u *fakeS3UploaderAPI) Upload(input *s3manager.UploadInput, fn ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+// CopyObjectWithContext fakes S3 CopyObjectWithContext.
+func (s *fakeS3API) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	return s.FakeCopyObject(input)
+}
+
+// HeadObjectWithContext fakes S3 HeadObjectWithContext.
+func (s *fakeS3API) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	return s.FakeHeadObject(input)
+}
+
+// RestoreObjectWithContext fakes S3 RestoreObjectWithContext.
+func (s *fakeS3API) RestoreObjectWithContext(ctx aws.Context, input *s3.RestoreObjectInput, opts ...request.Option) (*s3.RestoreObjectOutput, error) {
+	return s.FakeRestoreObject(input)
+}
+
+// UploadWithContext fakes S3 UploadWithContext.
+func (u *fakeS3UploaderAPI) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, fn ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
 	return u.FakeUpload(input, fn...)
 }
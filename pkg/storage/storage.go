@@ -1,17 +1,164 @@
 package storage
 
 import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
 	"github.com/yuuki/binrep/pkg/release"
 )
 
-// API defines the interface of the storage backend layer for S3.
+// API defines the interface of the storage backend layer for S3. The
+// operations are canceled when the context is done.
 type API interface {
-	ExistRelease(name string) (bool, error)
-	HaveSameChecksums(name string, bins []*release.Binary) (bool, error)
-	FindLatestRelease(name string) (*release.Release, error)
-	FindReleaseByTimestamp(name, timestamp string) (*release.Release, error)
-	CreateRelease(name string, timestamp string, bins []*release.Binary) (*release.Release, error)
-	DeleteRelease(name, timestamp string) error
-	PruneReleases(name string, keep int) ([]string, error)
-	WalkReleases(concurrency int, walkfn func(*release.Release) error) error
+	ExistRelease(ctx context.Context, name string) (bool, error)
+	HaveSameChecksums(ctx context.Context, name string, bins []*release.Binary) (bool, error)
+	FindLatestRelease(ctx context.Context, name string) (*release.Release, error)
+	FindReleaseByTimestamp(ctx context.Context, name, timestamp string) (*release.Release, error)
+	// FindReleaseMeta finds the release of the timestamp, or the latest
+	// release which is not yanked if timestamp is empty, by reading only its
+	// meta. The bodies of the binaries are nil.
+	FindReleaseMeta(ctx context.Context, name, timestamp string) (*release.Release, error)
+	// CreateRelease creates the release, and returns ErrConflict if the
+	// release of the timestamp already exists. The check is best-effort:
+	// the concurrent creations of the same timestamp may both succeed, and
+	// the later one overwrites the other.
+	CreateRelease(ctx context.Context, name string, timestamp string, meta *release.Meta) (*release.Release, error)
+	DeleteRelease(ctx context.Context, name, timestamp string) error
+	ArchiveRelease(ctx context.Context, name, timestamp, storageClass string) error
+	RestoreRelease(ctx context.Context, name, timestamp string) (bool, error)
+	YankRelease(ctx context.Context, name, timestamp, reason string) error
+	UnyankRelease(ctx context.Context, name, timestamp string) error
+	PruneReleases(ctx context.Context, name string, opts *PruneOptions) ([]*release.Retention, error)
+	WalkReleases(ctx context.Context, concurrency int, walkfn func(*release.Release) error) error
+	// ListReleases returns the releases of the project in ascending order of
+	// the timestamps, including the yanked releases. The bodies of the
+	// binaries are nil.
+	ListReleases(ctx context.Context, name string) ([]*release.Release, error)
+	// ProjectNames returns the sorted names of all projects which have the
+	// live releases without reading the releases.
+	ProjectNames(ctx context.Context) ([]string, error)
+	// ChainLinks returns the links of the releases and the tombstones of the
+	// project in ascending order of the timestamps, including the yanked
+	// releases.
+	ChainLinks(ctx context.Context, name string) ([]*release.Link, error)
+}
+
+// LoggerSetter is implemented by the storages logging the failures which
+// are not returned, such as the retries and the cleanups.
+type LoggerSetter interface {
+	SetLogger(logger *slog.Logger)
+}
+
+// SetLogger sets the logger of st, which is passed down to the storages
+// wrapped by st, if st implements LoggerSetter. The storages log to the
+// default logger until it is set.
+func SetLogger(st API, logger *slog.Logger) {
+	if ls, ok := st.(LoggerSetter); ok {
+		ls.SetLogger(logger)
+	}
+}
+
+// loggerOf returns logger, or the default logger if it is nil.
+func loggerOf(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// PruneOptions represents the options of PruneReleases.
+type PruneOptions struct {
+	Policy *release.RetentionPolicy
+	// DryRun only reports which releases would be pruned without deleting them.
+	DryRun bool
+	// ArchiveStorageClass archives the pruned releases with the storage class
+	// instead of deleting them if it is not empty.
+	ArchiveStorageClass string
+}
+
+// mergeLinks merges the links of the live releases and of the tombstones in
+// ascending order of the timestamps. The meta of the timestamp of the
+// release is got by getMeta.
+func mergeLinks(timestamps []string, tombstones map[string]*release.Tombstone, getMeta func(timestamp string) (*release.Meta, error)) ([]*release.Link, error) {
+	all := make([]string, 0, len(timestamps)+len(tombstones))
+	live := make(map[string]bool, len(timestamps))
+	for _, ts := range timestamps {
+		all = append(all, ts)
+		live[ts] = true
+	}
+	for ts := range tombstones {
+		if !live[ts] {
+			all = append(all, ts)
+		}
+	}
+	sort.Strings(all)
+	links := make([]*release.Link, 0, len(all))
+	for _, ts := range all {
+		var meta *release.Meta
+		if live[ts] {
+			var err error
+			meta, err = getMeta(ts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		link, err := release.NewLink(ts, meta, tombstones[ts])
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// pruneReleases prunes the releases of the timestamps in ascending order by
+// deleting or archiving them through st. The meta of the timestamp of the
+// release is got by getMeta.
+func pruneReleases(ctx context.Context, st API, name string, timestamps []string, getMeta func(timestamp string) (*release.Meta, error), opts *PruneOptions) ([]*release.Retention, error) {
+	metas := make(map[string]*release.Meta, len(timestamps))
+	for _, ts := range timestamps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		meta, err := getMeta(ts)
+		if err != nil {
+			return nil, err
+		}
+		metas[ts] = meta
+	}
+	rets := opts.Policy.Apply(timestamps, metas, time.Now())
+	if opts.DryRun {
+		return rets, nil
+	}
+	if err := deleteRetentions(ctx, st, name, rets, opts, nil); err != nil {
+		return nil, err
+	}
+	return rets, nil
+}
+
+// deleteRetentions deletes or archives the releases which the retentions
+// don't keep through st, and calls done after each of them if it is not nil.
+func deleteRetentions(ctx context.Context, st API, name string, rets []*release.Retention, opts *PruneOptions, done func(*release.Retention) error) error {
+	for _, ret := range rets {
+		if ret.Keep {
+			continue
+		}
+		var err error
+		if opts.ArchiveStorageClass != "" {
+			err = st.ArchiveRelease(ctx, name, ret.Timestamp, opts.ArchiveStorageClass)
+		} else {
+			err = st.DeleteRelease(ctx, name, ret.Timestamp)
+		}
+		if err != nil {
+			return err
+		}
+		if done != nil {
+			if err := done(ret); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
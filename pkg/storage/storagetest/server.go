@@ -0,0 +1,553 @@
+// Package storagetest provides an in-process S3-compatible server for the
+// integration tests of the storage backed by S3.
+package storagetest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Region is the region of the server, which is not validated.
+	Region = "us-east-1"
+
+	defaultMaxKeys = 1000
+	s3Namespace    = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// Object represents an object stored in the server.
+type Object struct {
+	Body         []byte
+	ETag         string
+	LastModified time.Time
+	StorageClass string
+	Metadata     map[string]string
+	// Tagging is the URL-encoded tags such as 'k1=v1&k2=v2'.
+	Tagging     string
+	SSE         string
+	SSEKMSKeyID string
+	// Restored is whether the object in GLACIER or DEEP_ARCHIVE is restored or not.
+	Restored bool
+}
+
+type upload struct {
+	bucket string
+	key    string
+	header http.Header
+	parts  map[int][]byte
+}
+
+// Server is the S3-compatible server keeping the objects in memory. It
+// supports the path-style requests of GetObject, HeadObject, PutObject,
+// CopyObject, DeleteObject, RestoreObject, ListObjectsV2 and the multipart
+// uploads. The requests are not authenticated.
+type Server struct {
+	*httptest.Server
+
+	// MaxKeys caps the number of keys of a ListObjectsV2 response to test paging.
+	MaxKeys int
+
+	mu       sync.Mutex
+	buckets  map[string]map[string]*Object
+	uploads  map[string]*upload
+	uploadID int
+	requests []string
+}
+
+// NewServer starts the server with the buckets.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		MaxKeys: defaultMaxKeys,
+		buckets: map[string]map[string]*Object{},
+		uploads: map[string]*upload{},
+	}
+	for _, b := range buckets {
+		s.buckets[b] = map[string]*Object{}
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Endpoint returns the binrep endpoint of the bucket on the server.
+func (s *Server) Endpoint(bucket string) string {
+	return fmt.Sprintf("s3://%s?endpoint=%s&region=%s&path_style=true", bucket, url.QueryEscape(s.URL), Region)
+}
+
+// Object returns the copy of the object of the key.
+func (s *Server) Object(bucket, key string) (*Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	o := *obj
+	return &o, true
+}
+
+// PutObject puts the object directly, such as the fixtures of the tests.
+func (s *Server) PutObject(bucket, key string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bucket(bucket)[key] = newObject(body, http.Header{})
+}
+
+// Keys returns the sorted keys in the bucket.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Requests returns the requests such as 'GET /bucket/key' received so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) bucket(name string) map[string]*Object {
+	b, ok := s.buckets[name]
+	if !ok {
+		b = map[string]*Object{}
+		s.buckets[name] = b
+	}
+	return b
+}
+
+func newObject(body []byte, h http.Header) *Object {
+	obj := &Object{
+		Body:         body,
+		ETag:         fmt.Sprintf(`"%x"`, md5.Sum(body)),
+		LastModified: time.Now().UTC(),
+		StorageClass: h.Get("X-Amz-Storage-Class"),
+		Tagging:      h.Get("X-Amz-Tagging"),
+		SSE:          h.Get("X-Amz-Server-Side-Encryption"),
+		SSEKMSKeyID:  h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+		Metadata:     metadata(h),
+	}
+	if obj.StorageClass == "" {
+		obj.StorageClass = "STANDARD"
+	}
+	return obj
+}
+
+func metadata(h http.Header) map[string]string {
+	var m map[string]string
+	for k := range h {
+		if !strings.HasPrefix(k, "X-Amz-Meta-") {
+			continue
+		}
+		if m == nil {
+			m = map[string]string{}
+		}
+		m[strings.ToLower(strings.TrimPrefix(k, "X-Amz-Meta-"))] = h.Get(k)
+	}
+	return m
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	q := r.URL.Query()
+
+	if key == "" {
+		if r.Method == http.MethodGet {
+			s.listObjectsV2(w, bucket, objects, q)
+			return
+		}
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "unsupported bucket operation")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && has(q, "uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && q.Get("uploadId") != "":
+		s.uploadPart(w, r, q)
+	case r.Method == http.MethodPost && q.Get("uploadId") != "":
+		s.completeMultipartUpload(w, objects, q)
+	case r.Method == http.MethodDelete && q.Get("uploadId") != "":
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && has(q, "restore"):
+		s.restoreObject(w, objects, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, objects, key)
+	case r.Method == http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		obj := newObject(body, r.Header)
+		objects[key] = obj
+		w.Header().Set("ETag", obj.ETag)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, objects, key)
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "unsupported object operation")
+	}
+}
+
+func has(q url.Values, name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+func isArchived(obj *Object) bool {
+	return (obj.StorageClass == "GLACIER" || obj.StorageClass == "DEEP_ARCHIVE") && !obj.Restored
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, objects map[string]*Object, key string) {
+	obj, ok := objects[key]
+	if !ok {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	h := w.Header()
+	h.Set("ETag", obj.ETag)
+	h.Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if obj.StorageClass != "STANDARD" {
+		h.Set("X-Amz-Storage-Class", obj.StorageClass)
+	}
+	if obj.Restored {
+		h.Set("X-Amz-Restore", `ongoing-request="false", expiry-date="`+obj.LastModified.Add(24*time.Hour).Format(http.TimeFormat)+`"`)
+	}
+	if obj.SSE != "" {
+		h.Set("X-Amz-Server-Side-Encryption", obj.SSE)
+	}
+	for k, v := range obj.Metadata {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+	if r.Method == http.MethodHead {
+		h.Set("Content-Length", strconv.Itoa(len(obj.Body)))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if isArchived(obj) {
+		writeError(w, http.StatusForbidden, "InvalidObjectState", "The operation is not valid for the object's storage class")
+		return
+	}
+	body, status := obj.Body, http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, ok := parseRange(rng, len(obj.Body))
+		if !ok {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(obj.Body)))
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.Body)))
+		body, status = obj.Body[start:end+1], http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// parseRange parses the single range such as 'bytes=10-' or 'bytes=10-19'.
+func parseRange(rng string, size int) (int, int, bool) {
+	if !strings.HasPrefix(rng, "bytes=") {
+		return 0, 0, false
+	}
+	se := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+	if len(se) != 2 {
+		return 0, 0, false
+	}
+	start, err := strconv.Atoi(se[0])
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if se[1] != "" {
+		end, err = strconv.Atoi(se[1])
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, objects map[string]*Object, key string) {
+	src, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid x-amz-copy-source")
+		return
+	}
+	i := strings.Index(src, "/")
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid x-amz-copy-source")
+		return
+	}
+	srcObj, ok := s.buckets[src[:i]][src[i+1:]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if isArchived(srcObj) {
+		writeError(w, http.StatusForbidden, "InvalidObjectState", "The source object of the COPY operation is not in the active tier")
+		return
+	}
+	obj := newObject(srcObj.Body, r.Header)
+	// The tags and the metadata are copied unless the directives are REPLACE.
+	if r.Header.Get("X-Amz-Metadata-Directive") != "REPLACE" {
+		obj.Metadata = srcObj.Metadata
+	}
+	if r.Header.Get("X-Amz-Tagging-Directive") != "REPLACE" {
+		obj.Tagging = srcObj.Tagging
+	}
+	objects[key] = obj
+	writeXML(w, http.StatusOK, &struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: obj.ETag, LastModified: obj.LastModified.Format(time.RFC3339)})
+}
+
+func (s *Server) restoreObject(w http.ResponseWriter, objects map[string]*Object, key string) {
+	obj, ok := objects[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if !isArchived(obj) {
+		if obj.Restored {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeError(w, http.StatusForbidden, "InvalidObjectState", "Restore is not allowed for the object's current storage class")
+		return
+	}
+	// The restoration completes immediately.
+	obj.Restored = true
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type listEntry struct {
+	key    string
+	obj    *Object
+	prefix bool
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, bucket string, objects map[string]*Object, q url.Values) {
+	if q.Get("list-type") != "2" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported")
+		return
+	}
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := s.MaxKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	after := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		b, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid continuation-token")
+			return
+		}
+		after = string(b)
+	}
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var entries []listEntry
+	for _, key := range keys {
+		entry := listEntry{key: key, obj: objects[key]}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = listEntry{key: key[:len(prefix)+i+len(delimiter)], prefix: true}
+			}
+		}
+		if entry.key <= after || (entry.prefix && len(entries) > 0 && entries[len(entries)-1].key == entry.key) {
+			continue
+		}
+		// The keys under the common prefix returned by the previous page are skipped.
+		if delimiter != "" && strings.HasSuffix(after, delimiter) && strings.HasPrefix(key, after) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	res := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Xmlns                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		MaxKeys               int
+		KeyCount              int
+		IsTruncated           bool
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+	}{
+		Xmlns:             s3Namespace,
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: q.Get("continuation-token"),
+		StartAfter:        q.Get("start-after"),
+	}
+	if len(entries) > maxKeys {
+		entries = entries[:maxKeys]
+		res.IsTruncated = true
+		res.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(entries[len(entries)-1].key))
+	}
+	for _, e := range entries {
+		if e.prefix {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: e.key})
+			continue
+		}
+		res.Contents = append(res.Contents, content{
+			Key:          e.key,
+			LastModified: e.obj.LastModified.Format(time.RFC3339),
+			ETag:         e.obj.ETag,
+			Size:         len(e.obj.Body),
+			StorageClass: e.obj.StorageClass,
+		})
+	}
+	res.KeyCount = len(entries)
+	writeXML(w, http.StatusOK, &res)
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.uploadID++
+	id := strconv.Itoa(s.uploadID)
+	s.uploads[id] = &upload{bucket: bucket, key: key, header: r.Header, parts: map[int][]byte{}}
+	writeXML(w, http.StatusOK, &struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: bucket, Key: key, UploadId: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, q url.Values) {
+	u, ok := s.uploads[q.Get("uploadId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	n, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || n < 1 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid partNumber")
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	u.parts[n] = body
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, objects map[string]*Object, q url.Values) {
+	id := q.Get("uploadId")
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	nums := make([]int, 0, len(u.parts))
+	for n := range u.parts {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	var body []byte
+	for _, n := range nums {
+		body = append(body, u.parts[n]...)
+	}
+	obj := newObject(body, u.header)
+	objects[u.key] = obj
+	delete(s.uploads, id)
+	writeXML(w, http.StatusOK, &struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: u.bucket, Key: u.key, ETag: obj.ETag})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeXML(w, status, &struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
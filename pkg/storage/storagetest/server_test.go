@@ -0,0 +1,233 @@
+package storagetest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func newTestClient(srv *Server) *s3.S3 {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String(Region),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	}))
+	return s3.New(sess)
+}
+
+func TestServer_object(t *testing.T) {
+	srv := NewServer("binrep-testing")
+	defer srv.Close()
+	svc := newTestClient(srv)
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String("binrep-testing"),
+		Key:                  aws.String("github.com/yuuki/droot/20171017152508/droot"),
+		Body:                 bytes.NewReader([]byte("droot-body")),
+		ServerSideEncryption: aws.String("AES256"),
+		Metadata:             map[string]*string{"built-by": aws.String("ci")},
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("binrep-testing"),
+		Key:    aws.String("github.com/yuuki/droot/20171017152508/droot"),
+		Range:  aws.String("bytes=6-"),
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	if string(body) != "body" {
+		t.Errorf("got %q, want %q", body, "body")
+	}
+	if got := aws.StringValue(resp.Metadata["Built-By"]); got != "ci" {
+		t.Errorf("got %q, want %q", got, "ci")
+	}
+
+	obj, ok := srv.Object("binrep-testing", "github.com/yuuki/droot/20171017152508/droot")
+	if !ok {
+		t.Fatalf("object should exist")
+	}
+	if obj.SSE != "AES256" {
+		t.Errorf("got %q, want %q", obj.SSE, "AES256")
+	}
+
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String("binrep-testing"),
+		Key:    aws.String("github.com/yuuki/droot/20171017152508/droot"),
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	_, err = svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("binrep-testing"),
+		Key:    aws.String("github.com/yuuki/droot/20171017152508/droot"),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != s3.ErrCodeNoSuchKey {
+		t.Errorf("should raise NoSuchKey, got %v", err)
+	}
+}
+
+func TestServer_listObjectsV2(t *testing.T) {
+	srv := NewServer("binrep-testing")
+	defer srv.Close()
+	srv.MaxKeys = 2
+	for _, key := range []string{
+		"github.com/motemen/ghq/20171013140424/ghq",
+		"github.com/yuuki/droot/20171017152508/droot",
+		"github.com/yuuki/droot/20171017152508/meta.yml",
+		"github.com/yuuki/droot/20171017152626/droot",
+		"github.com/yuuki/droot/20171017152626/meta.yml",
+		"github.com/yuuki/droot/20171018000000/meta.yml",
+		"github.com/yuuki/grabeni/20171017152626/meta.yml",
+	} {
+		srv.PutObject("binrep-testing", key, []byte(key))
+	}
+	svc := newTestClient(srv)
+
+	var prefixes []string
+	pages := 0
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String("binrep-testing"),
+		Prefix:    aws.String("github.com/yuuki/droot/"),
+		Delimiter: aws.String("/"),
+	}, func(resp *s3.ListObjectsV2Output, last bool) bool {
+		pages++
+		for _, cp := range resp.CommonPrefixes {
+			prefixes = append(prefixes, *cp.Prefix)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expected := []string{
+		"github.com/yuuki/droot/20171017152508/",
+		"github.com/yuuki/droot/20171017152626/",
+		"github.com/yuuki/droot/20171018000000/",
+	}
+	if diff := pretty.Compare(prefixes, expected); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+	if pages != 2 {
+		t.Errorf("got %d pages, want 2", pages)
+	}
+
+	var keys []string
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String("binrep-testing"),
+		Prefix: aws.String("github.com/yuuki/"),
+	}, func(resp *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range resp.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if diff := pretty.Compare(keys, srv.Keys("binrep-testing")[1:]); diff != "" {
+		t.Errorf("diff: (-actual +expected)\n%s", diff)
+	}
+}
+
+func TestServer_multipartUpload(t *testing.T) {
+	srv := NewServer("binrep-testing")
+	defer srv.Close()
+	svc := newTestClient(srv)
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = s3manager.MinUploadPartSize
+	})
+	body := bytes.Repeat([]byte("droot-body"), int(s3manager.MinUploadPartSize)/5)
+
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket:       aws.String("binrep-testing"),
+		Key:          aws.String("github.com/yuuki/droot/20171017152508/droot"),
+		Body:         bytes.NewReader(body),
+		StorageClass: aws.String("STANDARD_IA"),
+	})
+
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	obj, ok := srv.Object("binrep-testing", "github.com/yuuki/droot/20171017152508/droot")
+	if !ok {
+		t.Fatalf("object should exist")
+	}
+	if !bytes.Equal(obj.Body, body) {
+		t.Errorf("got %d bytes, want %d bytes", len(obj.Body), len(body))
+	}
+	if obj.StorageClass != "STANDARD_IA" {
+		t.Errorf("got %q, want %q", obj.StorageClass, "STANDARD_IA")
+	}
+	multipart := 0
+	for _, req := range srv.Requests() {
+		if req == "POST /binrep-testing/github.com/yuuki/droot/20171017152508/droot" {
+			multipart++
+		}
+	}
+	if multipart != 2 {
+		t.Errorf("got %d POST requests, want 2 (create and complete)", multipart)
+	}
+}
+
+func TestServer_copyAndRestore(t *testing.T) {
+	srv := NewServer("binrep-testing")
+	defer srv.Close()
+	srv.PutObject("binrep-testing", "github.com/yuuki/droot/20171017152508/droot", []byte("droot-body"))
+	svc := newTestClient(srv)
+
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:       aws.String("binrep-testing"),
+		Key:          aws.String("archive/github.com/yuuki/droot/20171017152508/droot"),
+		CopySource:   aws.String("binrep-testing/github.com/yuuki/droot/20171017152508/droot"),
+		StorageClass: aws.String("GLACIER"),
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String("binrep-testing"),
+		Key:    aws.String("archive/github.com/yuuki/droot/20171017152508/droot"),
+	}
+	if _, err := svc.GetObject(input); err == nil {
+		t.Errorf("should not get the archived object before the restoration")
+	}
+
+	_, err = svc.RestoreObject(&s3.RestoreObjectInput{
+		Bucket: aws.String("binrep-testing"),
+		Key:    aws.String("archive/github.com/yuuki/droot/20171017152508/droot"),
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String("binrep-testing"),
+		Key:    aws.String("archive/github.com/yuuki/droot/20171017152508/droot"),
+	})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if aws.StringValue(head.StorageClass) != "GLACIER" || aws.StringValue(head.Restore) == "" {
+		t.Errorf("got storage class %q and restore %q, want the restored GLACIER object",
+			aws.StringValue(head.StorageClass), aws.StringValue(head.Restore))
+	}
+	if _, err := svc.GetObject(input); err != nil {
+		t.Errorf("should get the restored object: %s", err)
+	}
+}